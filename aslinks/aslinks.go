@@ -0,0 +1,225 @@
+// Package aslinks extracts the AS adjacency graph from the AS paths of RIB
+// dumps, counting the collectors and peers each link is visible from.
+package aslinks
+
+import (
+	"math/bits"
+	"net/netip"
+	"sort"
+
+	"bgp_downloader/mrt"
+)
+
+// Link is an adjacency between two ASes, with A < B.
+type Link struct {
+	A, B uint32
+}
+
+// newLink returns the link between two ASes in either order
+func newLink(a, b uint32) Link {
+	if a > b {
+		a, b = b, a
+	}
+	return Link{a, b}
+}
+
+// less orders links by A, then B
+func (l Link) less(m Link) bool {
+	return l.A < m.A || l.A == m.A && l.B < m.B
+}
+
+// Collector identifies a route collector.
+type Collector struct {
+	Source string
+	Name   string
+}
+
+// peer is a peer session of a collector
+type peer struct {
+	collector int
+	ip        netip.Addr
+	as        uint32
+}
+
+// Graph accumulates the links of AS paths. The zero value is not usable;
+// create one with New.
+type Graph struct {
+	// Skipped counts the undecodable records, and the routes whose AS
+	// paths are missing from the links because they could not be decoded.
+	Skipped int
+
+	collectors   []Collector
+	collectorIDs map[Collector]int
+	peers        []peer
+	peerIDs      map[peer]int
+	links        map[Link][]uint64 // set of the peers seeing the link
+}
+
+// New returns an empty graph.
+func New() *Graph {
+	return &Graph{
+		collectorIDs: make(map[Collector]int),
+		peerIDs:      make(map[peer]int),
+		links:        make(map[Link][]uint64),
+	}
+}
+
+// AddRIB adds the links of the AS paths of a RIB record of a collector.
+//
+// Prepending is ignored. Only consecutive ASes of AS_SEQUENCE segments are
+// taken as adjacent: an AS_SET hides which of its members connect to the
+// neighbouring ASes, and confederation segments list the member ASes of a
+// confederation, so both interrupt the path.
+func (g *Graph) AddRIB(c Collector, r *mrt.RIB) {
+	cid, ok := g.collectorIDs[c]
+	if !ok {
+		cid = len(g.collectors)
+		g.collectors = append(g.collectors, c)
+		g.collectorIDs[c] = cid
+	}
+	for _, e := range r.Entries {
+		attrs, err := e.Attributes.Decode()
+		if err != nil {
+			g.Skipped++
+			continue
+		}
+		id := g.peerID(peer{cid, e.Peer.IP, e.Peer.AS})
+		var prev uint32
+		for _, seg := range attrs.ASPath {
+			if seg.Type != mrt.ASSequence {
+				prev = 0
+				continue
+			}
+			for _, as := range seg.ASNs {
+				if prev != 0 && as != prev {
+					g.add(newLink(prev, as), id)
+				}
+				prev = as
+			}
+		}
+	}
+}
+
+// peerID returns the number of a peer session
+func (g *Graph) peerID(p peer) int {
+	id, ok := g.peerIDs[p]
+	if !ok {
+		id = len(g.peers)
+		g.peers = append(g.peers, p)
+		g.peerIDs[p] = id
+	}
+	return id
+}
+
+// add marks a link as seen by a peer
+func (g *Graph) add(l Link, id int) {
+	set := g.links[l]
+	if n := id/64 + 1; len(set) < n {
+		set = append(set, make([]uint64, n-len(set))...)
+		g.links[l] = set
+	}
+	set[id/64] |= 1 << (id % 64)
+}
+
+// ReadFile adds the RIB records of an MRT file of a collector.
+func (g *Graph) ReadFile(c Collector, path string) error {
+	skipped, err := mrt.ForEachFile(path, func(rec mrt.Record) bool {
+		if rib, ok := rec.(*mrt.RIB); ok {
+			g.AddRIB(c, rib)
+		}
+		return true
+	})
+	g.Skipped += skipped
+	return err
+}
+
+// LinkInfo is a link with its visibility.
+type LinkInfo struct {
+	Link
+	// Collectors counts the collectors with a peer seeing the link.
+	Collectors int
+	// Peers counts the peer sessions seeing the link; a peer of several
+	// collectors counts once for each.
+	Peers int
+}
+
+// Links returns the links seen by at least minPeers peers, ordered by A,
+// then B.
+func (g *Graph) Links(minPeers int) []LinkInfo {
+	var links []LinkInfo
+	seen := make([]bool, len(g.collectors))
+	for l, set := range g.links {
+		info := LinkInfo{Link: l}
+		for i := range seen {
+			seen[i] = false
+		}
+		g.eachPeer(set, func(p peer) {
+			info.Peers++
+			if !seen[p.collector] {
+				seen[p.collector] = true
+				info.Collectors++
+			}
+		})
+		if info.Peers >= minPeers {
+			links = append(links, info)
+		}
+	}
+	sort.Slice(links, func(i, j int) bool { return links[i].Link.less(links[j].Link) })
+	return links
+}
+
+// CollectorLink is the visibility of a link from one collector.
+type CollectorLink struct {
+	Link
+	Collector
+	// Peers counts the peers of the collector seeing the link.
+	Peers int
+}
+
+// CollectorLinks returns the visibility of the links seen by at least
+// minPeers peers in total from each collector seeing them, ordered by
+// link, then collector.
+func (g *Graph) CollectorLinks(minPeers int) []CollectorLink {
+	var links []CollectorLink
+	counts := make([]int, len(g.collectors))
+	for l, set := range g.links {
+		for i := range counts {
+			counts[i] = 0
+		}
+		total := 0
+		g.eachPeer(set, func(p peer) {
+			counts[p.collector]++
+			total++
+		})
+		if total < minPeers {
+			continue
+		}
+		for i, n := range counts {
+			if n > 0 {
+				links = append(links, CollectorLink{Link: l, Collector: g.collectors[i], Peers: n})
+			}
+		}
+	}
+	sort.Slice(links, func(i, j int) bool {
+		a, b := links[i], links[j]
+		if a.Link != b.Link {
+			return a.Link.less(b.Link)
+		}
+		if a.Source != b.Source {
+			return a.Source < b.Source
+		}
+		return a.Name < b.Name
+	})
+	return links
+}
+
+// eachPeer calls fn with the peers of a set
+func (g *Graph) eachPeer(set []uint64, fn func(peer)) {
+	for i, word := range set {
+		for word != 0 {
+			b := bits.TrailingZeros64(word)
+			fn(g.peers[i*64+b])
+			word &^= 1 << b
+		}
+	}
+}
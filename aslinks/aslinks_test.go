@@ -0,0 +1,66 @@
+package aslinks
+
+import (
+	"strings"
+	"testing"
+
+	"bgp_downloader/internal/mrttest"
+)
+
+func TestGraph(t *testing.T) {
+	confed := mrttest.Cat([]byte{3, 1}, mrttest.U32(65000))
+	rrc00 := mrttest.WriteFile(t,
+		mrttest.PeerIndex(1000,
+			mrttest.Peer{IP: "198.51.100.1", AS: 64500},
+			mrttest.Peer{IP: "198.51.100.2", AS: 64501},
+		),
+		mrttest.RIB(1000, 0, "192.0.2.0/24",
+			// Prepending adds no link
+			mrttest.Route{Peer: 0, Attrs: mrttest.Attrs(mrttest.ASPath4(64500, 64500, 64510, 64520))},
+			// Neither AS sets nor confederation segments are adjacent to
+			// their neighbours
+			mrttest.Route{Peer: 1, Attrs: mrttest.Attrs(mrttest.ASPath4(64501, 64510), mrttest.ASSet4(64530, 64531))},
+		),
+		mrttest.RIB(1000, 1, "198.18.0.0/15",
+			mrttest.Route{Peer: 0, Attrs: mrttest.Attrs(mrttest.ASPath4(64500), confed, mrttest.ASPath4(64540))},
+			mrttest.Route{Peer: 1, Attrs: mrttest.Attrs(mrttest.ASPath4(64501, 64510, 64520))},
+		),
+	)
+	// The same peer at another collector counts again
+	rv2 := mrttest.WriteFile(t,
+		mrttest.PeerIndex(1000, mrttest.Peer{IP: "198.51.100.1", AS: 64500}),
+		mrttest.RIB(1000, 0, "192.0.2.0/24", mrttest.Route{Attrs: mrttest.Attrs(mrttest.ASPath4(64500, 64510))}),
+	)
+
+	g := New()
+	if err := g.ReadFile(Collector{"ripe", "rrc00"}, rrc00); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.ReadFile(Collector{"routeviews", "rv2"}, rv2); err != nil {
+		t.Fatal(err)
+	}
+
+	var b strings.Builder
+	if err := WriteCSV(&b, g.Links(1)); err != nil {
+		t.Fatal(err)
+	}
+	want := "as1,as2,collectors,peers\n" +
+		"64500,64510,2,2\n" +
+		"64501,64510,1,1\n" +
+		"64510,64520,1,2\n"
+	if b.String() != want {
+		t.Errorf("got links\n%swant\n%s", b.String(), want)
+	}
+
+	b.Reset()
+	if err := WriteCollectorCSV(&b, g.CollectorLinks(2)); err != nil {
+		t.Fatal(err)
+	}
+	want = "as1,as2,source,collector,peers\n" +
+		"64500,64510,ripe,rrc00,1\n" +
+		"64500,64510,routeviews,rv2,1\n" +
+		"64510,64520,ripe,rrc00,2\n"
+	if b.String() != want {
+		t.Errorf("got collector links\n%swant\n%s", b.String(), want)
+	}
+}
@@ -0,0 +1,82 @@
+package aslinks
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// WriteCSV writes links as CSV with the columns as1, as2, collectors and
+// peers, preceded by a header line.
+func WriteCSV(w io.Writer, links []LinkInfo) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"as1", "as2", "collectors", "peers"})
+	for _, l := range links {
+		cw.Write([]string{
+			strconv.FormatUint(uint64(l.A), 10),
+			strconv.FormatUint(uint64(l.B), 10),
+			strconv.Itoa(l.Collectors),
+			strconv.Itoa(l.Peers),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteCollectorCSV writes the visibility of links from each collector as
+// CSV with the columns as1, as2, source, collector and peers, preceded by
+// a header line.
+func WriteCollectorCSV(w io.Writer, links []CollectorLink) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"as1", "as2", "source", "collector", "peers"})
+	for _, l := range links {
+		cw.Write([]string{
+			strconv.FormatUint(uint64(l.A), 10),
+			strconv.FormatUint(uint64(l.B), 10),
+			l.Source,
+			l.Name,
+			strconv.Itoa(l.Peers),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteGraphML writes links as an undirected GraphML graph. Nodes are the
+// ASes, with their number in the asn attribute; edges carry the collectors
+// and peers counts.
+func WriteGraphML(w io.Writer, links []LinkInfo) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString(xml.Header)
+	bw.WriteString(`<graphml xmlns="http://graphml.graphdrawing.org/xmlns">` + "\n")
+	bw.WriteString(`  <key id="asn" for="node" attr.name="asn" attr.type="long"/>` + "\n")
+	bw.WriteString(`  <key id="collectors" for="edge" attr.name="collectors" attr.type="int"/>` + "\n")
+	bw.WriteString(`  <key id="peers" for="edge" attr.name="peers" attr.type="int"/>` + "\n")
+	bw.WriteString(`  <graph id="aslinks" edgedefault="undirected">` + "\n")
+
+	// Nodes must be declared before use by some readers; links are ordered
+	// by their first AS only, so collect the nodes first
+	seen := make(map[uint32]bool)
+	var nodes []uint32
+	for _, l := range links {
+		for _, as := range [2]uint32{l.A, l.B} {
+			if !seen[as] {
+				seen[as] = true
+				nodes = append(nodes, as)
+			}
+		}
+	}
+	for _, as := range nodes {
+		fmt.Fprintf(bw, "    <node id=\"AS%d\"><data key=\"asn\">%d</data></node>\n", as, as)
+	}
+	for _, l := range links {
+		fmt.Fprintf(bw, "    <edge source=\"AS%d\" target=\"AS%d\"><data key=\"collectors\">%d</data><data key=\"peers\">%d</data></edge>\n",
+			l.A, l.B, l.Collectors, l.Peers)
+	}
+
+	bw.WriteString("  </graph>\n</graphml>\n")
+	return bw.Flush()
+}
@@ -0,0 +1,351 @@
+// Package churn computes update churn statistics over BGP updates: update
+// counts, duplicate announcements, path exploration and flaps, per prefix,
+// origin AS, peer and time bucket.
+package churn
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"net/netip"
+	"sort"
+	"time"
+
+	"bgp_downloader/mrt"
+)
+
+// Stats are the update counts of a prefix, origin, peer or time bucket.
+type Stats struct {
+	Announcements int `json:"announcements"`
+	Withdrawals   int `json:"withdrawals"`
+	// Duplicates are announcements repeating the route of the peer with
+	// the same attributes.
+	Duplicates int `json:"duplicates"`
+	// Explorations are announcements changing the AS path of a route of
+	// the peer within the exploration window of its previous update.
+	Explorations int `json:"explorations"`
+	// Flaps are announcements of a route the peer had announced and then
+	// withdrawn.
+	Flaps int `json:"flaps"`
+}
+
+// Updates returns the number of announcements and withdrawals.
+func (s Stats) Updates() int {
+	return s.Announcements + s.Withdrawals
+}
+
+// Ratio returns the number of announcements per withdrawal, and false if
+// there were no withdrawals.
+func (s Stats) Ratio() (float64, bool) {
+	if s.Withdrawals == 0 {
+		return 0, false
+	}
+	return float64(s.Announcements) / float64(s.Withdrawals), true
+}
+
+func (s *Stats) add(d Stats) {
+	s.Announcements += d.Announcements
+	s.Withdrawals += d.Withdrawals
+	s.Duplicates += d.Duplicates
+	s.Explorations += d.Explorations
+	s.Flaps += d.Flaps
+}
+
+// Peer identifies a peer session of a collector.
+type Peer struct {
+	Source    string
+	Collector string
+	PeerIP    netip.Addr
+	PeerAS    uint32
+}
+
+// Options tunes a Counter.
+type Options struct {
+	// Bucket is the length of the time buckets; the default is an hour.
+	Bucket time.Duration
+	// ExplorationWindow is the longest time between two updates of a
+	// route for an AS path change to count as path exploration; the
+	// default is two minutes.
+	ExplorationWindow time.Duration
+}
+
+// Counter accumulates the statistics of a stream of updates. The zero
+// value is not usable; create one with New.
+type Counter struct {
+	opts     Options
+	total    Stats
+	prefixes map[netip.Prefix]Stats
+	origins  map[string]Stats
+	peers    map[Peer]Stats
+	buckets  map[time.Time]Stats
+	routes   map[Peer]map[routeKey]*route
+}
+
+// routeKey identifies a route of a peer
+type routeKey struct {
+	prefix netip.Prefix
+	pathID uint32
+}
+
+// route is the last update of a route of a peer that was announced. A
+// withdrawn route is kept, marked, until it is announced again, when it is
+// counted as a flap and replaced.
+type route struct {
+	attrs     uint64 // fingerprint of the attributes
+	path      uint64 // fingerprint of the AS path
+	origin    string
+	withdrawn bool
+	time      time.Time
+}
+
+// New returns an empty Counter.
+func New(opts Options) *Counter {
+	if opts.Bucket <= 0 {
+		opts.Bucket = time.Hour
+	}
+	if opts.ExplorationWindow <= 0 {
+		opts.ExplorationWindow = 2 * time.Minute
+	}
+	return &Counter{
+		opts:     opts,
+		prefixes: make(map[netip.Prefix]Stats),
+		origins:  make(map[string]Stats),
+		peers:    make(map[Peer]Stats),
+		buckets:  make(map[time.Time]Stats),
+		routes:   make(map[Peer]map[routeKey]*route),
+	}
+}
+
+// Add counts an element of a collector's updates. Elements must be given
+// in time order. A session leaving the Established state forgets the
+// routes of the peer, so that their announcement after the session is
+// re-established does not count as flaps.
+func (c *Counter) Add(source, collector string, e mrt.Element) {
+	peer := Peer{source, collector, e.PeerIP, e.PeerAS}
+	switch e.Type {
+	case mrt.ElemState:
+		if e.OldState == mrt.StateEstablished && e.NewState != mrt.StateEstablished {
+			delete(c.routes, peer)
+		}
+		return
+	case mrt.ElemAnnounce, mrt.ElemWithdraw:
+	default:
+		return
+	}
+
+	routes, ok := c.routes[peer]
+	if !ok {
+		routes = make(map[routeKey]*route)
+		c.routes[peer] = routes
+	}
+	key := routeKey{e.Prefix, e.PathID}
+	prev, had := routes[key]
+
+	var d Stats
+	var origin string
+	if e.Type == mrt.ElemAnnounce {
+		d.Announcements = 1
+		attrs, path := fingerprint(e.Attributes)
+		switch {
+		case had && prev.withdrawn:
+			d.Flaps = 1
+		case had && attrs == prev.attrs:
+			d.Duplicates = 1
+		case had && path != prev.path && e.Time.Sub(prev.time) <= c.opts.ExplorationWindow:
+			d.Explorations = 1
+		}
+		origin = e.Attributes.ASPath.OriginString()
+		routes[key] = &route{attrs: attrs, path: path, origin: origin, time: e.Time}
+	} else {
+		// The origin of a withdrawal is that of the route it removes. A
+		// withdrawal of a route never seen announced is kept nowhere, so
+		// that announcing it later is not a flap.
+		d.Withdrawals = 1
+		if had {
+			origin = prev.origin
+			prev.withdrawn = true
+			prev.time = e.Time
+		}
+	}
+
+	c.total.add(d)
+	s := c.prefixes[e.Prefix]
+	s.add(d)
+	c.prefixes[e.Prefix] = s
+	s = c.peers[peer]
+	s.add(d)
+	c.peers[peer] = s
+	bucket := e.Time.Truncate(c.opts.Bucket)
+	s = c.buckets[bucket]
+	s.add(d)
+	c.buckets[bucket] = s
+	if origin != "" {
+		s = c.origins[origin]
+		s.add(d)
+		c.origins[origin] = s
+	}
+}
+
+// Total returns the statistics of all updates.
+func (c *Counter) Total() Stats {
+	return c.total
+}
+
+// PrefixStats are the statistics of a prefix.
+type PrefixStats struct {
+	Prefix netip.Prefix
+	Stats
+}
+
+// OriginStats are the statistics of an origin AS, or of the members of an
+// AS set separated by commas.
+type OriginStats struct {
+	Origin string
+	Stats
+}
+
+// PeerStats are the statistics of a peer.
+type PeerStats struct {
+	Peer
+	Stats
+}
+
+// BucketStats are the statistics of a time bucket.
+type BucketStats struct {
+	Start time.Time
+	Stats
+}
+
+// Prefixes returns the statistics of the prefixes, most updates first.
+func (c *Counter) Prefixes() []PrefixStats {
+	list := make([]PrefixStats, 0, len(c.prefixes))
+	for p, s := range c.prefixes {
+		list = append(list, PrefixStats{p, s})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if a.Updates() != b.Updates() {
+			return a.Updates() > b.Updates()
+		}
+		return mrt.ComparePrefix(a.Prefix, b.Prefix) < 0
+	})
+	return list
+}
+
+// Origins returns the statistics of the origins, most updates first.
+// Withdrawals count for the origin of the route they remove, and are not
+// counted if that is unknown.
+func (c *Counter) Origins() []OriginStats {
+	list := make([]OriginStats, 0, len(c.origins))
+	for o, s := range c.origins {
+		list = append(list, OriginStats{o, s})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if a.Updates() != b.Updates() {
+			return a.Updates() > b.Updates()
+		}
+		return a.Origin < b.Origin
+	})
+	return list
+}
+
+// Peers returns the statistics of the peers, most updates first.
+func (c *Counter) Peers() []PeerStats {
+	list := make([]PeerStats, 0, len(c.peers))
+	for p, s := range c.peers {
+		list = append(list, PeerStats{p, s})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if a.Updates() != b.Updates() {
+			return a.Updates() > b.Updates()
+		}
+		if a.Source != b.Source {
+			return a.Source < b.Source
+		}
+		if a.Collector != b.Collector {
+			return a.Collector < b.Collector
+		}
+		return a.PeerIP.Less(b.PeerIP)
+	})
+	return list
+}
+
+// Buckets returns the statistics of the time buckets with updates, in time
+// order.
+func (c *Counter) Buckets() []BucketStats {
+	list := make([]BucketStats, 0, len(c.buckets))
+	for t, s := range c.buckets {
+		list = append(list, BucketStats{t, s})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Start.Before(list[j].Start) })
+	return list
+}
+
+// fingerprint hashes the attributes of a route, and its AS path alone.
+// Optional attributes are preceded by whether they are present and lists
+// by their length, so that different attributes do not encode alike.
+func fingerprint(a *mrt.Attributes) (attrs, path uint64) {
+	h := fnv.New64a()
+	var buf []byte
+	for _, seg := range a.ASPath {
+		buf = append(buf, seg.Type, byte(len(seg.ASNs)))
+		for _, asn := range seg.ASNs {
+			buf = binary.BigEndian.AppendUint32(buf, asn)
+		}
+	}
+	h.Write(buf)
+	path = h.Sum64()
+
+	buf = append(buf[:0], byte(a.Origin))
+	nextHops := a.NextHops()
+	buf = append(buf, byte(len(nextHops)))
+	for _, nh := range nextHops {
+		buf = append(buf, byte(nh.BitLen()))
+		buf = append(buf, nh.AsSlice()...)
+	}
+	buf = appendOptional(buf, a.Has(mrt.AttrMED), a.MED)
+	buf = appendOptional(buf, a.Has(mrt.AttrLocalPref), a.LocalPref)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(a.Communities)))
+	for _, c := range a.Communities {
+		buf = binary.BigEndian.AppendUint32(buf, uint32(c))
+	}
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(a.LargeCommunities)))
+	for _, c := range a.LargeCommunities {
+		buf = binary.BigEndian.AppendUint32(buf, c.Global)
+		buf = binary.BigEndian.AppendUint32(buf, c.Local1)
+		buf = binary.BigEndian.AppendUint32(buf, c.Local2)
+	}
+	buf = appendFlag(buf, a.AtomicAggregate)
+	buf = appendFlag(buf, a.Aggregator != nil)
+	if a.Aggregator != nil {
+		buf = binary.BigEndian.AppendUint32(buf, a.Aggregator.AS)
+		buf = append(buf, byte(a.Aggregator.Addr.BitLen()))
+		buf = append(buf, a.Aggregator.Addr.AsSlice()...)
+	}
+	for _, o := range a.Other {
+		buf = append(buf, o.Type)
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(o.Value)))
+		buf = append(buf, o.Value...)
+	}
+	h.Write(buf)
+	return h.Sum64(), path
+}
+
+// appendFlag appends 1 if set, else 0
+func appendFlag(b []byte, set bool) []byte {
+	if set {
+		return append(b, 1)
+	}
+	return append(b, 0)
+}
+
+// appendOptional appends whether a scalar attribute is present, and its
+// value if it is
+func appendOptional(b []byte, present bool, v uint32) []byte {
+	b = appendFlag(b, present)
+	if present {
+		b = binary.BigEndian.AppendUint32(b, v)
+	}
+	return b
+}
@@ -0,0 +1,132 @@
+package churn
+
+import (
+	"fmt"
+	"net/netip"
+	"testing"
+	"time"
+
+	"bgp_downloader/internal/mrttest"
+	"bgp_downloader/mrt"
+)
+
+func announce(sec int64, peer, prefix string, path ...uint32) mrt.Element {
+	return mrt.Element{
+		Type:       mrt.ElemAnnounce,
+		Time:       time.Unix(sec, 0),
+		PeerIP:     netip.MustParseAddr(peer),
+		PeerAS:     path[0],
+		Prefix:     netip.MustParsePrefix(prefix),
+		Attributes: &mrt.Attributes{ASPath: mrt.ASPath{{Type: mrt.ASSequence, ASNs: path}}},
+	}
+}
+
+func withdraw(sec int64, peer string, peerAS uint32, prefix string) mrt.Element {
+	return mrt.Element{
+		Type:   mrt.ElemWithdraw,
+		Time:   time.Unix(sec, 0),
+		PeerIP: netip.MustParseAddr(peer),
+		PeerAS: peerAS,
+		Prefix: netip.MustParsePrefix(prefix),
+	}
+}
+
+func TestCounter(t *testing.T) {
+	c := New(Options{})
+	for _, e := range []mrt.Element{
+		announce(0, "198.51.100.1", "192.0.2.0/24", 64500, 64600),
+		// Duplicate
+		announce(10, "198.51.100.1", "192.0.2.0/24", 64500, 64600),
+		// Exploration: a new path within two minutes
+		announce(20, "198.51.100.1", "192.0.2.0/24", 64500, 64501, 64600),
+		// A new path after the window
+		announce(500, "198.51.100.1", "192.0.2.0/24", 64500, 64600),
+		withdraw(510, "198.51.100.1", 64500, "192.0.2.0/24"),
+		// Flap
+		announce(520, "198.51.100.1", "192.0.2.0/24", 64500, 64600),
+		// A withdrawal of an unknown route has no origin, and its
+		// announcement is no flap
+		withdraw(530, "198.51.100.1", 64500, "198.18.0.0/15"),
+		announce(540, "198.51.100.1", "198.18.0.0/15", 64500, 64601),
+		// The session going down forgets the routes of the peer
+		{Type: mrt.ElemState, Time: time.Unix(600, 0), PeerIP: netip.MustParseAddr("198.51.100.1"), PeerAS: 64500, OldState: mrt.StateEstablished, NewState: mrt.StateActive},
+		announce(610, "198.51.100.1", "192.0.2.0/24", 64500, 64600),
+		announce(3700, "198.51.100.2", "192.0.2.0/24", 64502, 64600),
+	} {
+		c.Add("ripe", "rrc00", e)
+	}
+
+	if got, want := c.Total(), (Stats{Announcements: 8, Withdrawals: 2, Duplicates: 1, Explorations: 1, Flaps: 1}); got != want {
+		t.Errorf("got total %+v, want %+v", got, want)
+	}
+	if r, ok := c.Total().Ratio(); !ok || r != 4 {
+		t.Errorf("got ratio %v, %v", r, ok)
+	}
+
+	prefixes := c.Prefixes()
+	if len(prefixes) != 2 || prefixes[0].Prefix.String() != "192.0.2.0/24" || prefixes[0].Updates() != 8 || prefixes[1].Updates() != 2 {
+		t.Errorf("got prefixes %+v", prefixes)
+	}
+	origins := c.Origins()
+	if len(origins) != 2 || origins[0].Origin != "64600" || origins[0].Withdrawals != 1 || origins[0].Updates() != 8 ||
+		origins[1].Origin != "64601" || origins[1].Updates() != 1 {
+		t.Errorf("got origins %+v", origins)
+	}
+	peers := c.Peers()
+	if len(peers) != 2 || peers[0].PeerIP.String() != "198.51.100.1" || peers[0].Updates() != 9 || peers[1].Updates() != 1 {
+		t.Errorf("got peers %+v", peers)
+	}
+	buckets := c.Buckets()
+	if len(buckets) != 2 || !buckets[1].Start.Equal(time.Unix(3600, 0)) || buckets[0].Updates() != 9 || buckets[1].Updates() != 1 {
+		t.Errorf("got buckets %+v", buckets)
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	path := mrttest.Attrs(mrttest.ASPath4(64500, 64600))
+	nextHop := mrttest.Attr(0x40, mrt.AttrNextHop, mrttest.IP("198.51.100.1"))
+	// Each pair of attributes differs; the first ones are announced, then
+	// the second ones, which must not count as duplicates
+	pairs := []struct {
+		name string
+		a, b []byte
+	}{
+		{"MED 0 and none", mrttest.Attr(0x80, mrt.AttrMED, mrttest.U32(0)), nil},
+		{"LOCAL_PREF 0 and none", nil, mrttest.Attr(0x40, mrt.AttrLocalPref, mrttest.U32(0))},
+		{"MED and LOCAL_PREF", mrttest.Attr(0x80, mrt.AttrMED, mrttest.U32(100)), mrttest.Attr(0x40, mrt.AttrLocalPref, mrttest.U32(100))},
+		{
+			"communities and large communities",
+			mrttest.Attr(0xc0, mrt.AttrCommunities, mrttest.Cat(mrttest.U32(1), mrttest.U32(2), mrttest.U32(3))),
+			mrttest.Attr(0xc0, mrt.AttrLargeCommunity, mrttest.Cat(mrttest.U32(1), mrttest.U32(2), mrttest.U32(3))),
+		},
+	}
+	var records [][]byte
+	for i, p := range pairs {
+		prefix := mrttest.Prefixes(fmt.Sprintf("192.0.%d.0/24", i))
+		for j, attr := range [][]byte{p.a, p.b, p.b} {
+			msg := mrttest.Update(nil, mrttest.Cat(path, nextHop, attr), prefix)
+			records = append(records, mrttest.Message(uint32(1000+10*i+j), "198.51.100.1", 64500, msg))
+		}
+	}
+
+	c := New(Options{})
+	_, err := mrt.ForEachFile(mrttest.WriteFile(t, records...), func(rec mrt.Record) bool {
+		elems, err := mrt.Elements(rec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range elems {
+			c.Add("ripe", "rrc00", e)
+		}
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range c.Prefixes() {
+		// Only the repeated second attributes are duplicates
+		if p.Announcements != 3 || p.Duplicates != 1 {
+			t.Errorf("%s: got %+v", pairs[p.Prefix.Addr().As4()[2]].name, p.Stats)
+		}
+	}
+}
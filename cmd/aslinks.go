@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"bgp_downloader/aslinks"
+	"bgp_downloader/downloader"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	aslinksDir          string
+	aslinksSource       string
+	aslinksFormat       string
+	aslinksOutput       string
+	aslinksPerCollector bool
+	aslinksMinPeers     int
+)
+
+var aslinksCmd = &cobra.Command{
+	Use:   "aslinks [file or directory]...",
+	Short: "Extract the AS adjacency graph from downloaded RIB dumps",
+	Long: `Extract the AS adjacency graph from downloaded RIB dumps.
+
+Consecutive ASes of the AS paths of the RIB entries are taken as linked,
+ignoring prepending. AS_SET and confederation segments interrupt a path, as
+the links of their members are not known. Each link is printed with the
+number of collectors and of peer sessions that see it; a peer of several
+collectors counts once for each.
+
+Directories are searched for files stored in --layout; updates files are
+skipped. The collector of a file is told from its location below --dir.
+
+With --format csv the columns are as1, as2, collectors and peers, or as1,
+as2, source, collector and peers with --per-collector. With --format graphml
+the ASes are the nodes of an undirected graph.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		l, err := downloader.ParseLayout(layout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		switch aslinksFormat {
+		case "csv":
+		case "graphml":
+			if aslinksPerCollector {
+				fmt.Fprintf(os.Stderr, "Error: --per-collector requires --format csv\n")
+				os.Exit(1)
+			}
+		default:
+			fmt.Fprintf(os.Stderr, "Error: invalid format: %s\n", aslinksFormat)
+			os.Exit(1)
+		}
+		files, err := downloader.LocalFiles(aslinksDir, l, aslinksSource, args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		g := aslinks.New()
+		for _, f := range files {
+			if f.DumpType == downloader.DumpUpdates {
+				continue
+			}
+			feed := f.Feed()
+			c := aslinks.Collector{Source: feed.Source, Name: feed.Collector}
+			if err := g.ReadFile(c, f.Path); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %s: %v\n", f.Path, err)
+				os.Exit(1)
+			}
+		}
+		if g.Skipped > 0 {
+			slog.Warn("skipped undecodable records", "count", g.Skipped)
+		}
+
+		out := os.Stdout
+		if aslinksOutput != "" {
+			if out, err = os.Create(aslinksOutput); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		bw := bufio.NewWriter(out)
+		switch {
+		case aslinksFormat == "graphml":
+			err = aslinks.WriteGraphML(bw, g.Links(aslinksMinPeers))
+		case aslinksPerCollector:
+			err = aslinks.WriteCollectorCSV(bw, g.CollectorLinks(aslinksMinPeers))
+		default:
+			err = aslinks.WriteCSV(bw, g.Links(aslinksMinPeers))
+		}
+		if err == nil {
+			err = bw.Flush()
+		}
+		if aslinksOutput != "" {
+			if cerr := out.Close(); err == nil {
+				err = cerr
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(aslinksCmd)
+
+	aslinksCmd.Flags().StringVarP(&aslinksDir, "dir", "d", ".", "Download directory the files are stored in, used to tell their source and collector")
+	aslinksCmd.Flags().StringVar(&layout, "layout", "default", "Layout of the download directory (preset or template)")
+	aslinksCmd.Flags().StringVarP(&aslinksSource, "source", "S", "", "Source to assume when the layout does not encode it (ripe, routeviews)")
+	aslinksCmd.Flags().StringVarP(&aslinksFormat, "format", "f", "csv", "Output format (csv, graphml)")
+	aslinksCmd.Flags().StringVarP(&aslinksOutput, "output", "o", "", "File to write to instead of standard output")
+	aslinksCmd.Flags().BoolVar(&aslinksPerCollector, "per-collector", false, "Print a row per link and collector")
+	aslinksCmd.Flags().IntVar(&aslinksMinPeers, "min-peers", 1, "Omit links seen by fewer peers")
+}
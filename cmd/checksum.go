@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"bgp_downloader/downloader"
+
+	"github.com/spf13/cobra"
+)
+
+var checksumQuiet bool
+
+var checksumCmd = &cobra.Command{
+	Use:   "checksum",
+	Short: "Write or verify SHA256SUMS files",
+}
+
+var checksumVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Check every file listed in the SHA256SUMS files of a tree",
+	Run: func(cmd *cobra.Command, args []string) {
+		counts, err := downloader.VerifyChecksums(outputDir, func(res downloader.ChecksumResult) {
+			switch {
+			case res.Err != nil:
+				fmt.Printf("%s: FAILED (%v)\n", res.Path, res.Err)
+			case res.Status == downloader.StatusMissing:
+				fmt.Printf("%s: MISSING\n", res.Path)
+			case res.Status == downloader.StatusMismatch:
+				fmt.Printf("%s: FAILED\n", res.Path)
+			case !checksumQuiet:
+				fmt.Printf("%s: OK\n", res.Path)
+			}
+		})
+		if err != nil {
+			fmt.Printf("Error verifying %s: %v\n", outputDir, err)
+			os.Exit(1)
+		}
+		fmt.Printf("%d OK, %d FAILED, %d MISSING\n",
+			counts[downloader.StatusOK], counts[downloader.StatusMismatch], counts[downloader.StatusMissing])
+		if counts[downloader.StatusMismatch] > 0 || counts[downloader.StatusMissing] > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+var checksumGenerateCmd = &cobra.Command{
+	Use:   "generate",
+	Short: "Write SHA256SUMS files for every directory recorded in the manifest",
+	Run: func(cmd *cobra.Command, args []string) {
+		m, err := downloader.OpenManifest(outputDir)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		dirs := downloader.ManifestDirs(m)
+		if err := downloader.WriteChecksums(m, dirs); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Wrote checksums for %d directories\n", len(dirs))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(checksumCmd)
+	checksumCmd.AddCommand(checksumVerifyCmd)
+	checksumCmd.AddCommand(checksumGenerateCmd)
+
+	checksumCmd.PersistentFlags().StringVarP(&outputDir, "output", "o", ".", "Directory holding the downloaded files")
+	checksumVerifyCmd.Flags().BoolVarP(&checksumQuiet, "quiet", "q", false, "Only report files that fail")
+}
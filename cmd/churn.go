@@ -0,0 +1,220 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	"bgp_downloader/churn"
+	"bgp_downloader/downloader"
+	"bgp_downloader/mrt"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	churnDir               string
+	churnSource            string
+	churnFormat            string
+	churnBucket            time.Duration
+	churnExplorationWindow time.Duration
+	churnTop               int
+)
+
+var churnCmd = &cobra.Command{
+	Use:   "churn [file or directory]...",
+	Short: "Report update churn per prefix, origin, peer and time bucket",
+	Long: `Report update churn per prefix, origin, peer and time bucket.
+
+The updates files are read in timestamp order across collectors, and the
+announcements and withdrawals are counted along with:
+
+  duplicates     announcements repeating the peer's route with the same attributes
+  explorations   announcements changing the AS path of the peer's route within
+                 --exploration-window of its previous update
+  flaps          announcements of a route the peer had announced and withdrawn
+
+Withdrawals count for the origin of the route they remove. The peers,
+prefixes and origins with the most updates are listed up to --top, followed
+by the counts of every --bucket. RIB dumps are skipped. Nothing is
+downloaded.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		l, err := downloader.ParseLayout(layout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if churnFormat != "text" && churnFormat != "json" {
+			fmt.Fprintf(os.Stderr, "Error: invalid format: %s\n", churnFormat)
+			os.Exit(1)
+		}
+		if churnBucket <= 0 {
+			fmt.Fprintf(os.Stderr, "Error: invalid bucket: %s\n", churnBucket)
+			os.Exit(1)
+		}
+		files, err := downloader.LocalFiles(churnDir, l, churnSource, args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		var updates []downloader.LocalFile
+		for _, f := range files {
+			if f.DumpType != downloader.DumpRIB {
+				updates = append(updates, f)
+			}
+		}
+
+		c := churn.New(churn.Options{Bucket: churnBucket, ExplorationWindow: churnExplorationWindow})
+		s := downloader.OpenFiles(updates, slog.Default())
+		defer s.Close()
+		for s.Next() {
+			rec := s.Record()
+			elems, err := mrt.Elements(rec.Record)
+			if err != nil {
+				slog.Warn("skipped record", "file", rec.File, "error", err)
+				continue
+			}
+			for _, e := range elems {
+				c.Add(rec.Source, rec.Collector, e)
+			}
+		}
+		if err := s.Err(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		out := bufio.NewWriter(os.Stdout)
+		if churnFormat == "json" {
+			err = writeChurnJSON(out, c, churnTop)
+		} else {
+			writeChurn(out, c, churnTop)
+		}
+		if err == nil {
+			err = out.Flush()
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// topN returns how many of a list of length l to list with --top n
+func topN(l, n int) int {
+	if n > 0 && l > n {
+		return n
+	}
+	return l
+}
+
+// writeChurn prints the statistics as text
+func writeChurn(w io.Writer, c *churn.Counter, n int) {
+	fmt.Fprintf(w, "total: %s\n", formatChurn(c.Total()))
+	peers, prefixes, origins := c.Peers(), c.Prefixes(), c.Origins()
+	fmt.Fprintln(w, "\npeers:")
+	for _, p := range peers[:topN(len(peers), n)] {
+		fmt.Fprintf(w, "  %s %s %s AS%d: %s\n", p.Source, p.Collector, p.PeerIP, p.PeerAS, formatChurn(p.Stats))
+	}
+	fmt.Fprintln(w, "\nprefixes:")
+	for _, p := range prefixes[:topN(len(prefixes), n)] {
+		fmt.Fprintf(w, "  %s: %s\n", p.Prefix, formatChurn(p.Stats))
+	}
+	fmt.Fprintln(w, "\norigins:")
+	for _, o := range origins[:topN(len(origins), n)] {
+		fmt.Fprintf(w, "  AS%s: %s\n", o.Origin, formatChurn(o.Stats))
+	}
+	fmt.Fprintln(w, "\nbuckets:")
+	for _, b := range c.Buckets() {
+		fmt.Fprintf(w, "  %s: %s\n", b.Start.UTC().Format(time.RFC3339), formatChurn(b.Stats))
+	}
+}
+
+// formatChurn formats the statistics on one line
+func formatChurn(s churn.Stats) string {
+	ratio := "-"
+	if r, ok := s.Ratio(); ok {
+		ratio = strconv.FormatFloat(r, 'f', 2, 64)
+	}
+	return fmt.Sprintf("%d updates, %d announcements, %d withdrawals, A/W %s, %d duplicates, %d explorations, %d flaps",
+		s.Updates(), s.Announcements, s.Withdrawals, ratio, s.Duplicates, s.Explorations, s.Flaps)
+}
+
+// churnJSON is the JSON form of statistics; the ratio is left out when
+// there were no withdrawals
+type churnJSON struct {
+	churn.Stats
+	Updates int      `json:"updates"`
+	Ratio   *float64 `json:"aw_ratio,omitempty"`
+}
+
+func newChurnJSON(s churn.Stats) churnJSON {
+	j := churnJSON{Stats: s, Updates: s.Updates()}
+	if r, ok := s.Ratio(); ok {
+		j.Ratio = &r
+	}
+	return j
+}
+
+// writeChurnJSON writes the statistics as one JSON document
+func writeChurnJSON(w io.Writer, c *churn.Counter, n int) error {
+	type peer struct {
+		Source    string `json:"source"`
+		Collector string `json:"collector"`
+		PeerIP    string `json:"peer_ip"`
+		PeerAS    uint32 `json:"peer_as"`
+		churnJSON
+	}
+	type prefix struct {
+		Prefix string `json:"prefix"`
+		churnJSON
+	}
+	type origin struct {
+		Origin string `json:"origin"`
+		churnJSON
+	}
+	type bucket struct {
+		Start time.Time `json:"start"`
+		churnJSON
+	}
+	doc := struct {
+		Total    churnJSON `json:"total"`
+		Peers    []peer    `json:"peers"`
+		Prefixes []prefix  `json:"prefixes"`
+		Origins  []origin  `json:"origins"`
+		Buckets  []bucket  `json:"buckets"`
+	}{Total: newChurnJSON(c.Total())}
+	peers, prefixes, origins := c.Peers(), c.Prefixes(), c.Origins()
+	for _, p := range peers[:topN(len(peers), n)] {
+		doc.Peers = append(doc.Peers, peer{p.Source, p.Collector, p.PeerIP.String(), p.PeerAS, newChurnJSON(p.Stats)})
+	}
+	for _, p := range prefixes[:topN(len(prefixes), n)] {
+		doc.Prefixes = append(doc.Prefixes, prefix{p.Prefix.String(), newChurnJSON(p.Stats)})
+	}
+	for _, o := range origins[:topN(len(origins), n)] {
+		doc.Origins = append(doc.Origins, origin{o.Origin, newChurnJSON(o.Stats)})
+	}
+	for _, b := range c.Buckets() {
+		doc.Buckets = append(doc.Buckets, bucket{b.Start.UTC(), newChurnJSON(b.Stats)})
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
+
+func init() {
+	rootCmd.AddCommand(churnCmd)
+
+	churnCmd.Flags().StringVarP(&churnDir, "dir", "d", ".", "Download directory the files are stored in, used to tell their source and collector")
+	churnCmd.Flags().StringVar(&layout, "layout", "default", "Layout of the download directory (preset or template)")
+	churnCmd.Flags().StringVarP(&churnSource, "source", "S", "", "Source to assume when the layout does not encode it (ripe, routeviews)")
+	churnCmd.Flags().StringVarP(&churnFormat, "format", "f", "text", "Output format (text, json)")
+	churnCmd.Flags().DurationVar(&churnBucket, "bucket", time.Hour, "Length of the time buckets")
+	churnCmd.Flags().DurationVar(&churnExplorationWindow, "exploration-window", 2*time.Minute, "Longest time between updates of a route for an AS path change to count as path exploration")
+	churnCmd.Flags().IntVar(&churnTop, "top", 20, "Number of peers, prefixes and origins to list (0 for all)")
+}
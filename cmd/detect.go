@@ -0,0 +1,198 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"bgp_downloader/detect"
+	"bgp_downloader/downloader"
+	"bgp_downloader/mrt"
+	"bgp_downloader/pfx2as"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	detectDir              string
+	detectSource           string
+	detectFormat           string
+	detectShortLived       time.Duration
+	detectBaselineMinPeers int
+)
+
+var detectCmd = &cobra.Command{
+	Use:   "detect",
+	Short: "Flag possible hijacks in downloaded updates",
+	Long: `Flag possible hijacks in downloaded updates.
+
+The RIB dumps among the files form the baseline: the origins of their
+prefixes are the known origins. Of each collector only the last dump taken
+at or before its first updates file is used, so that routes announced
+during the updates are not known from the start; later dumps are ignored
+with a warning. The updates files are read in timestamp
+order across collectors, and announcements that conflict with the known
+origins are reported when the first peer carries them. Conflicts that end
+within --short-lived, as no peer carries the origin any longer, are reported
+again when they end.
+
+The first origin announced for a prefix missing from the RIB dumps, or for
+every prefix without RIB dumps, becomes its known origin. Events against
+such learned origins are marked "(learned)" in text and "learned": true in
+JSON.`,
+}
+
+var detectMOASCmd = &cobra.Command{
+	Use:   "moas [file or directory]...",
+	Short: "Flag prefixes announced by an origin other than their known origins",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runDetect(detect.Options{MOAS: true, ShortLived: detectShortLived}, args)
+	},
+}
+
+var detectSubprefixCmd = &cobra.Command{
+	Use:   "subprefix [file or directory]...",
+	Short: "Flag new more-specifics of prefixes of another origin",
+	Long: `Flag new more-specifics of prefixes of another origin.
+
+An announcement of a prefix without known origins is reported when the
+longest known prefix covering it has other origins. The default route is
+not taken as covering.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runDetect(detect.Options{Subprefix: true, ShortLived: detectShortLived}, args)
+	},
+}
+
+// runDetect prints the events of the updates among paths, with the RIB
+// dumps among them as the baseline
+func runDetect(opts detect.Options, paths []string) {
+	l, err := downloader.ParseLayout(layout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if detectFormat != "text" && detectFormat != "json" {
+		fmt.Fprintf(os.Stderr, "Error: invalid format: %s\n", detectFormat)
+		os.Exit(1)
+	}
+	files, err := downloader.LocalFiles(detectDir, l, detectSource, paths)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	d := detect.New(opts)
+	baseline := pfx2as.New()
+	ribs, updates := baselineRIBs(files)
+	for _, f := range ribs {
+		if err := baseline.ReadFile(f.Path); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s: %v\n", f.Path, err)
+			os.Exit(1)
+		}
+	}
+	if len(ribs) == 0 {
+		slog.Warn("no RIB dumps given, learning origins from the updates")
+	}
+	if baseline.Skipped > 0 {
+		slog.Warn("skipped undecodable records", "count", baseline.Skipped)
+	}
+	d.LoadBaseline(baseline, detectBaselineMinPeers)
+	baseline = nil
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	enc := json.NewEncoder(out)
+	s := downloader.OpenFiles(updates, slog.Default())
+	defer s.Close()
+	for s.Next() {
+		rec := s.Record()
+		elems, err := mrt.Elements(rec.Record)
+		if err != nil {
+			slog.Warn("skipped record", "file", rec.File, "error", err)
+			continue
+		}
+		for _, e := range elems {
+			for _, ev := range d.Process(rec.Source, rec.Collector, e) {
+				if detectFormat == "json" {
+					enc.Encode(ev)
+				} else {
+					fmt.Fprintln(out, ev)
+				}
+			}
+		}
+	}
+	if err := s.Err(); err != nil {
+		out.Flush()
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// baselineRIBs splits files into the RIB dumps of the baseline and the
+// updates. The baseline has the last dump of each collector taken at or
+// before its first updates file, or before the first updates file of any
+// collector if it has none.
+func baselineRIBs(files []downloader.LocalFile) (ribs, updates []downloader.LocalFile) {
+	first := make(map[downloader.Feed]time.Time)
+	var start time.Time
+	for _, f := range files {
+		if f.DumpType == downloader.DumpRIB {
+			continue
+		}
+		updates = append(updates, f)
+		feed := f.Feed()
+		if t, ok := first[feed]; !ok || f.Time.Before(t) {
+			first[feed] = f.Time
+		}
+		if start.IsZero() || f.Time.Before(start) {
+			start = f.Time
+		}
+	}
+
+	latest := make(map[downloader.Feed]downloader.LocalFile)
+	var order []downloader.Feed
+	for _, f := range files {
+		if f.DumpType != downloader.DumpRIB {
+			continue
+		}
+		feed := f.Feed()
+		limit, ok := first[feed]
+		if !ok {
+			limit = start
+		}
+		if !limit.IsZero() && f.Time.After(limit) {
+			slog.Warn("ignoring RIB dump taken after the updates begin", "file", f.Path)
+			continue
+		}
+		if prev, ok := latest[feed]; !ok {
+			order = append(order, feed)
+		} else if f.Time.After(prev.Time) {
+			slog.Debug("ignoring RIB dump older than another of the collector", "file", prev.Path)
+		} else {
+			slog.Debug("ignoring RIB dump older than another of the collector", "file", f.Path)
+			continue
+		}
+		latest[feed] = f
+	}
+	for _, feed := range order {
+		ribs = append(ribs, latest[feed])
+	}
+	return ribs, updates
+}
+
+func init() {
+	rootCmd.AddCommand(detectCmd)
+	detectCmd.AddCommand(detectMOASCmd, detectSubprefixCmd)
+
+	detectCmd.PersistentFlags().StringVarP(&detectDir, "dir", "d", ".", "Download directory the files are stored in, used to tell their source and collector")
+	detectCmd.PersistentFlags().StringVar(&layout, "layout", "default", "Layout of the download directory (preset or template)")
+	detectCmd.PersistentFlags().StringVarP(&detectSource, "source", "S", "", "Source to assume when the layout does not encode it (ripe, routeviews)")
+	detectCmd.PersistentFlags().StringVarP(&detectFormat, "format", "f", "text", "Output format (text, json)")
+	detectCmd.PersistentFlags().DurationVar(&detectShortLived, "short-lived", time.Hour, "Also report conflicts that end within this time when they end (0 to disable)")
+	detectCmd.PersistentFlags().IntVar(&detectBaselineMinPeers, "baseline-min-peers", 1, "Only take origins of prefixes seen by this many peers of the RIB dumps as known")
+}
@@ -0,0 +1,499 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"bgp_downloader/downloader"
+	"bgp_downloader/filter"
+	"bgp_downloader/mrt"
+	"bgp_downloader/parquet"
+	"bgp_downloader/rpki"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	dumpDir    string
+	dumpSource string
+	dumpFormat string
+	dumpOutput string
+	dumpMerge  bool
+
+	dumpRowGroupSize int64
+	dumpPartition    bool
+
+	dumpVRPFile string
+	dumpVRPs    *rpki.VRPs
+
+	// Filter flags
+	filterPrefixes    []string
+	filterPrefixMatch string
+	filterOrigins     []string
+	filterASPath      string
+	filterPeerASNs    []string
+	filterPeerIPs     []string
+	filterCommunities []string
+	filterTypes       []string
+	filterAFI         string
+)
+
+var dumpCmd = &cobra.Command{
+	Use:   "dump [file or directory]...",
+	Short: "Print the routes and events of downloaded MRT files",
+	Long: `Print the routes and events of downloaded MRT files.
+
+Directories are searched for files stored in --layout. Files are printed one
+after the other unless --merge is given, in which case the records of all
+files are interleaved by timestamp and every element is tagged with the
+source and collector of the record: text lines are prefixed with them, and
+JSON objects carry them as the source and collector members.
+
+With --format mrt the matching records are written to the MRT file named by
+--output instead, compressed if its name ends in .gz or .bz2. RIB records
+keep only their matching entries, under a rebuilt PEER_INDEX_TABLE; BGP4MP
+messages are kept whole if any of their routes matches.
+
+With --rpki-vrps, routes are annotated with their RPKI route origin
+validation state (valid, invalid or not-found) against a VRP export of
+rpki-client (JSON) or Routinator (JSON or CSV): as an extra field of text
+lines, an "rpki" member of JSON objects and the rpki column of Parquet
+files. Routes whose AS path has no AS, as learned over iBGP, have no origin
+to validate: their text field is empty, and the JSON member and Parquet
+value are left out.
+
+With --format parquet the elements are written to the Parquet file named by
+--output. With --partition, --output is a directory that receives a file per
+source, collector, dump type and day, stored where the input files are in
+--layout.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		l, err := downloader.ParseLayout(layout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		switch dumpFormat {
+		case "text", "json":
+		case "mrt", "parquet":
+			if dumpOutput == "" {
+				fmt.Fprintf(os.Stderr, "Error: --format %s requires --output\n", dumpFormat)
+				os.Exit(1)
+			}
+		default:
+			fmt.Fprintf(os.Stderr, "Error: invalid format: %s\n", dumpFormat)
+			os.Exit(1)
+		}
+		f, err := buildFilter()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		files, err := downloader.LocalFiles(dumpDir, l, dumpSource, args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		if dumpPartition && dumpFormat != "parquet" {
+			fmt.Fprintf(os.Stderr, "Error: --partition requires --format parquet\n")
+			os.Exit(1)
+		}
+		if dumpVRPFile != "" {
+			if dumpFormat == "mrt" {
+				fmt.Fprintf(os.Stderr, "Error: --rpki-vrps does not apply to --format mrt\n")
+				os.Exit(1)
+			}
+			if dumpVRPs, err = rpki.Load(dumpVRPFile); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
+		w, err := newRecordWriter(l, files)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if dumpMerge {
+			err = dumpStream(w, downloader.OpenFiles(files, slog.Default()), f)
+		} else {
+			if dumpPartition {
+				// Partitions are completed as their files are read in
+				// time order
+				sort.SliceStable(files, func(i, j int) bool { return files[i].Time.Before(files[j].Time) })
+			}
+			for _, file := range files {
+				if err = dumpStream(w, downloader.OpenFiles([]downloader.LocalFile{file}, slog.Default()), f); err != nil {
+					break
+				}
+			}
+		}
+		if cerr := w.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// recordWriter writes the parts of records that match a filter
+type recordWriter interface {
+	write(rec downloader.Record, f *filter.Filter) error
+	Close() error
+}
+
+// newRecordWriter returns the writer for --format and --output
+func newRecordWriter(l downloader.Layout, files []downloader.LocalFile) (recordWriter, error) {
+	switch dumpFormat {
+	case "parquet":
+		return newParquetWriter(l, files)
+	case "mrt":
+		w, err := mrt.Create(dumpOutput)
+		if err != nil {
+			return nil, err
+		}
+		return mrtWriter{w}, nil
+	}
+	if dumpOutput == "" {
+		return &elementWriter{Writer: bufio.NewWriter(os.Stdout)}, nil
+	}
+	file, err := os.Create(dumpOutput)
+	if err != nil {
+		return nil, err
+	}
+	return &elementWriter{Writer: bufio.NewWriter(file), file: file}, nil
+}
+
+// dumpStream writes the records of s that match f
+func dumpStream(w recordWriter, s *downloader.Stream, f *filter.Filter) error {
+	defer s.Close()
+	for s.Next() {
+		if err := w.write(s.Record(), f); err != nil {
+			return err
+		}
+	}
+	return s.Err()
+}
+
+// elementWriter prints elements as text or JSON lines
+type elementWriter struct {
+	*bufio.Writer
+	file *os.File
+}
+
+func (w *elementWriter) write(rec downloader.Record, f *filter.Filter) error {
+	elems, err := f.Elements(rec.Record)
+	if err != nil {
+		slog.Warn("skipped record", "file", rec.File, "error", err)
+		return nil
+	}
+	for _, e := range elems {
+		if err := writeElement(w, e, rec.Feed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *elementWriter) Close() error {
+	err := w.Flush()
+	if w.file != nil {
+		if cerr := w.file.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// mrtWriter re-encodes records as MRT
+type mrtWriter struct {
+	*mrt.Writer
+}
+
+func (w mrtWriter) write(rec downloader.Record, f *filter.Filter) error {
+	r, err := f.Record(rec.Record)
+	if err != nil {
+		slog.Warn("skipped record", "file", rec.File, "error", err)
+		return nil
+	}
+	if r == nil {
+		return nil
+	}
+	return w.Write(r)
+}
+
+// buildFilter builds the filter selected by the filter flags
+func buildFilter() (*filter.Filter, error) {
+	f := &filter.Filter{}
+	var err error
+	for _, s := range filterPrefixes {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("--prefix: %v", err)
+		}
+		f.Prefixes = append(f.Prefixes, p.Masked())
+	}
+	if f.PrefixMatch, err = filter.ParsePrefixMatch(filterPrefixMatch); err != nil {
+		return nil, err
+	}
+	for _, s := range filterOrigins {
+		asn, err := filter.ParseASN(s)
+		if err != nil {
+			return nil, fmt.Errorf("--origin-as: %v", err)
+		}
+		f.OriginASNs = append(f.OriginASNs, asn)
+	}
+	if filterASPath != "" {
+		if f.ASPath, err = filter.CompileASPath(filterASPath); err != nil {
+			return nil, err
+		}
+	}
+	for _, s := range filterPeerASNs {
+		asn, err := filter.ParseASN(s)
+		if err != nil {
+			return nil, fmt.Errorf("--peer-asn: %v", err)
+		}
+		f.PeerASNs = append(f.PeerASNs, asn)
+	}
+	for _, s := range filterPeerIPs {
+		ip, err := netip.ParseAddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("--peer-ip: %v", err)
+		}
+		f.PeerIPs = append(f.PeerIPs, ip)
+	}
+	for _, s := range filterCommunities {
+		c, err := filter.ParseCommunity(s)
+		if err != nil {
+			return nil, err
+		}
+		f.Communities = append(f.Communities, c)
+	}
+	for _, s := range filterTypes {
+		t, err := filter.ParseType(s)
+		if err != nil {
+			return nil, err
+		}
+		f.Types = append(f.Types, t)
+	}
+	if f.AFI, err = filter.ParseAFI(filterAFI); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// addFilterFlags registers the filter flags on cmd
+func addFilterFlags(cmd *cobra.Command) {
+	cmd.Flags().StringSliceVar(&filterPrefixes, "prefix", nil, "Only routes to these prefixes")
+	cmd.Flags().StringVar(&filterPrefixMatch, "prefix-match", "exact", "How --prefix is matched (exact, more, less, any)")
+	cmd.Flags().StringSliceVar(&filterOrigins, "origin-as", nil, "Only routes originated by these ASes")
+	cmd.Flags().StringVar(&filterASPath, "as-path-regex", "", "Only routes whose AS path matches this expression (\"_\" matches an AS boundary)")
+	cmd.Flags().StringSliceVar(&filterPeerASNs, "peer-asn", nil, "Only routes and events of peers in these ASes")
+	cmd.Flags().StringSliceVar(&filterPeerIPs, "peer-ip", nil, "Only routes and events of peers with these addresses")
+	cmd.Flags().StringSliceVar(&filterCommunities, "community", nil, "Only routes carrying one of these communities (AS:value or large global:local1:local2, \"*\" matches any part)")
+	cmd.Flags().StringSliceVar(&filterTypes, "type", nil, "Only these element types (rib, announce, withdraw, state)")
+	cmd.Flags().StringVar(&filterAFI, "afi", "", "Only prefixes of this address family (ipv4, ipv6)")
+}
+
+// writeElement writes an element in the format selected by --format. With
+// --merge, the element is tagged with the feed it came from, and with
+// --rpki-vrps routes are tagged with their validation state.
+func writeElement(w io.Writer, e mrt.Element, feed downloader.Feed) error {
+	validity := rpkiValidity(e)
+	if dumpFormat == "json" {
+		b, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if dumpMerge {
+			// Splice the feed into the flat element object
+			tag, _ := json.Marshal(feed.Source)
+			col, _ := json.Marshal(feed.Collector)
+			b = append([]byte(fmt.Sprintf(`{"source":%s,"collector":%s,`, tag, col)), b[1:]...)
+		}
+		if validity != "" {
+			b = append(b[:len(b)-1], fmt.Sprintf(`,"rpki":%q}`, validity)...)
+		}
+		_, err = fmt.Fprintf(w, "%s\n", b)
+		return err
+	}
+	line := e.String()
+	if validity != "" || dumpVRPs != nil && e.Attributes != nil {
+		// Routes without an origin AS keep the field, empty
+		line += validity + "|"
+	}
+	if dumpMerge {
+		_, err := fmt.Fprintf(w, "%s|%s|%s\n", feed.Source, feed.Collector, line)
+		return err
+	}
+	_, err := fmt.Fprintln(w, line)
+	return err
+}
+
+// rpkiValidity returns the validation state of a route with --rpki-vrps,
+// or "" for other elements and routes without an origin AS
+func rpkiValidity(e mrt.Element) string {
+	if dumpVRPs == nil || e.Attributes == nil || len(e.Attributes.ASPath.Origins()) == 0 {
+		return ""
+	}
+	return dumpVRPs.Validate(e.Prefix, e.Attributes.ASPath).String()
+}
+
+// parquetWriter writes elements to a Parquet file, or with --partition to
+// a file per partition.
+//
+// Each open file holds a row group in memory. The files of a feed and dump
+// type are read in time order, so the partition of a group of files is
+// closed as soon as the group moves on to the next day, and only one
+// partition per group is open at a time.
+type parquetWriter struct {
+	opts    parquet.Options
+	layout  downloader.Layout
+	files   map[string]downloader.LocalFile
+	single  *parquet.ElementWriter
+	writers map[partitionGroup]*partitionWriter
+	closed  map[string]bool
+}
+
+// partitionGroup identifies the input files whose partitions follow each
+// other: those of a feed and dump type
+type partitionGroup struct {
+	feed downloader.Feed
+	typ  downloader.DumpType
+}
+
+// partitionWriter is the open partition of a group
+type partitionWriter struct {
+	path string
+	*parquet.ElementWriter
+}
+
+func newParquetWriter(l downloader.Layout, files []downloader.LocalFile) (*parquetWriter, error) {
+	w := &parquetWriter{
+		opts:    parquet.Options{RowGroupSize: dumpRowGroupSize << 20},
+		layout:  l,
+		files:   make(map[string]downloader.LocalFile),
+		writers: make(map[partitionGroup]*partitionWriter),
+		closed:  make(map[string]bool),
+	}
+	if !dumpPartition {
+		var err error
+		w.single, err = parquet.CreateElementFile(dumpOutput, w.opts)
+		return w, err
+	}
+	if w.layout.IsZero() {
+		w.layout = downloader.DefaultLayout
+	}
+	for _, f := range files {
+		w.files[f.Path] = f
+	}
+	return w, nil
+}
+
+func (w *parquetWriter) write(rec downloader.Record, f *filter.Filter) error {
+	elems, err := f.Elements(rec.Record)
+	if err != nil {
+		slog.Warn("skipped record", "file", rec.File, "error", err)
+		return nil
+	}
+	if len(elems) == 0 {
+		return nil
+	}
+	ew, err := w.writer(rec.File)
+	if err != nil {
+		return err
+	}
+	for _, e := range elems {
+		if err := ew.Write(rec.Source, rec.Collector, e, rpkiValidity(e)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writer returns the writer for the elements of an input file
+func (w *parquetWriter) writer(file string) (*parquet.ElementWriter, error) {
+	if w.single != nil {
+		return w.single, nil
+	}
+	f := w.files[file]
+	g := partitionGroup{f.Feed(), f.DumpType}
+	path := filepath.Join(dumpOutput, w.partition(f))
+	if pw, ok := w.writers[g]; ok {
+		if pw.path == path {
+			return pw.ElementWriter, nil
+		}
+		// The group has moved on: its previous partition is complete
+		delete(w.writers, g)
+		w.closed[pw.path] = true
+		if err := pw.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if w.closed[path] {
+		return nil, fmt.Errorf("%s: partition %s was already completed", file, path)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	ew, err := parquet.CreateElementFile(path, w.opts)
+	if err != nil {
+		return nil, err
+	}
+	w.writers[g] = &partitionWriter{path, ew}
+	return ew, nil
+}
+
+// partition returns the path of the partition of an input file below the
+// output directory: the directory of the file in the layout, and a name
+// made of its dump type and day
+func (w *parquetWriter) partition(f downloader.LocalFile) string {
+	if f.Collector == "" || f.Time.IsZero() {
+		name := strings.TrimSuffix(strings.TrimSuffix(filepath.Base(f.Path), ".gz"), ".bz2")
+		return name + ".parquet"
+	}
+	info := f.FileInfo
+	info.Time = info.Time.Truncate(24 * time.Hour)
+	info.Name = fmt.Sprintf("%s.%s.parquet", info.Type, info.Time.Format("20060102"))
+	return w.layout.Path(info)
+}
+
+func (w *parquetWriter) Close() error {
+	var err error
+	if w.single != nil {
+		err = w.single.Close()
+	}
+	for _, pw := range w.writers {
+		if cerr := pw.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+func init() {
+	rootCmd.AddCommand(dumpCmd)
+
+	dumpCmd.Flags().StringVarP(&dumpDir, "dir", "d", ".", "Download directory the files are stored in, used to tell their source and collector")
+	dumpCmd.Flags().StringVar(&layout, "layout", "default", "Layout of the download directory (preset or template)")
+	dumpCmd.Flags().StringVarP(&dumpSource, "source", "S", "", "Source to assume when the layout does not encode it (ripe, routeviews)")
+	dumpCmd.Flags().StringVarP(&dumpFormat, "format", "f", "text", "Output format (text, json, mrt, parquet)")
+	dumpCmd.Flags().StringVarP(&dumpOutput, "output", "o", "", "File to write to instead of standard output; required for mrt and parquet")
+	dumpCmd.Flags().Int64Var(&dumpRowGroupSize, "row-group-size", 128, "Uncompressed size of Parquet row groups in MiB")
+	dumpCmd.Flags().BoolVar(&dumpPartition, "partition", false, "Write a Parquet file per source, collector, dump type and day below the --output directory")
+	dumpCmd.Flags().BoolVar(&dumpMerge, "merge", false, "Interleave the records of all files by timestamp")
+	dumpCmd.Flags().StringVar(&dumpVRPFile, "rpki-vrps", "", "Annotate routes with their RPKI validity against this VRP export (rpki-client JSON, Routinator JSON or CSV)")
+	addFilterFlags(dumpCmd)
+}
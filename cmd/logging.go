@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+var (
+	logLevel  string
+	logFormat string
+)
+
+// setupLogger installs the logger selected by --log-level and --log-format
+// as the slog default
+func setupLogger() error {
+	return setLogOutput(os.Stderr)
+}
+
+// setLogOutput installs a logger writing to w as the slog default
+func setLogOutput(w io.Writer) error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(logLevel)); err != nil {
+		return fmt.Errorf("invalid log level: %s", logLevel)
+	}
+	opts := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+	switch strings.ToLower(logFormat) {
+	case "text":
+		handler = slog.NewTextHandler(w, opts)
+	case "json":
+		handler = slog.NewJSONHandler(w, opts)
+	default:
+		return fmt.Errorf("invalid log format: %s", logFormat)
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"bgp_downloader/downloader"
+
+	"github.com/spf13/cobra"
+)
+
+var manifestSource string
+
+var manifestCmd = &cobra.Command{
+	Use:   "manifest",
+	Short: "Inspect or rebuild the download manifest",
+}
+
+var manifestShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Summarize the files recorded in the manifest",
+	Run: func(cmd *cobra.Command, args []string) {
+		m, err := downloader.OpenManifest(outputDir)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		type key struct{ source, collector, typ string }
+		type summary struct {
+			files       int
+			bytes       int64
+			first, last string
+			statuses    map[downloader.VerifyStatus]int
+		}
+		summaries := make(map[key]*summary)
+		for _, e := range m.Entries() {
+			k := key{e.Source, e.Collector, e.Type}
+			s := summaries[k]
+			if s == nil {
+				s = &summary{statuses: make(map[downloader.VerifyStatus]int)}
+				summaries[k] = s
+			}
+			s.files++
+			s.bytes += e.Size
+			s.statuses[e.Status]++
+			t := e.DumpTime.Format("2006-01-02 15:04")
+			if s.first == "" || t < s.first {
+				s.first = t
+			}
+			if t > s.last {
+				s.last = t
+			}
+		}
+
+		keys := make([]key, 0, len(summaries))
+		for k := range summaries {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j])
+		})
+		for _, k := range keys {
+			s := summaries[k]
+			fmt.Printf("%s %s %s: %d files, %d bytes, %s .. %s, ok=%d unverified=%d mismatch=%d missing=%d\n",
+				k.source, k.collector, k.typ, s.files, s.bytes, s.first, s.last,
+				s.statuses[downloader.StatusOK], s.statuses[downloader.StatusUnverified],
+				s.statuses[downloader.StatusMismatch], s.statuses[downloader.StatusMissing])
+		}
+	},
+}
+
+var manifestRebuildCmd = &cobra.Command{
+	Use:   "rebuild",
+	Short: "Record files missing from the manifest and drop entries for deleted files",
+	Run: func(cmd *cobra.Command, args []string) {
+		l, err := downloader.ParseLayout(layout)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		m, err := downloader.OpenManifest(outputDir)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		added, removed, err := downloader.RebuildManifest(m, l, manifestSource)
+		if err != nil {
+			fmt.Printf("Error scanning %s: %v\n", outputDir, err)
+			os.Exit(1)
+		}
+		if err := m.Save(); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Added %d files, removed %d entries\n", added, removed)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(manifestCmd)
+	manifestCmd.AddCommand(manifestShowCmd)
+	manifestCmd.AddCommand(manifestRebuildCmd)
+
+	manifestCmd.PersistentFlags().StringVarP(&outputDir, "output", "o", ".", "Directory holding the downloaded files")
+	manifestRebuildCmd.Flags().StringVar(&layout, "layout", "default", "Layout of the directory (preset or template)")
+	manifestRebuildCmd.Flags().StringVarP(&manifestSource, "source", "S", "", "Source to assume when the layout does not encode it (ripe, routeviews)")
+}
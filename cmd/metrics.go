@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+
+	"bgp_downloader/downloader"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	metricsAddr string
+
+	// metrics is the downloader metrics served on --metrics-addr, nil when
+	// the endpoint is disabled
+	metrics *downloader.Metrics
+)
+
+// startMetrics serves the Prometheus endpoint when --metrics-addr is set
+func startMetrics() error {
+	if metricsAddr == "" {
+		return nil
+	}
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	metrics = downloader.NewMetrics(reg)
+
+	ln, err := net.Listen("tcp", metricsAddr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	go func() {
+		if err := http.Serve(ln, mux); err != nil {
+			slog.Error("metrics endpoint stopped", "error", err)
+		}
+	}()
+	slog.Info("serving metrics", "addr", ln.Addr().String())
+	return nil
+}
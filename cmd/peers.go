@@ -0,0 +1,163 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	"bgp_downloader/downloader"
+	"bgp_downloader/peers"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	peersDir       string
+	peersSource    string
+	peersFormat    string
+	peersFullRatio float64
+	peersFullOnly  bool
+)
+
+var peersCmd = &cobra.Command{
+	Use:   "peers [file or directory]...",
+	Short: "Summarise the peers of collectors from downloaded files",
+	Long: `Summarise the peers of collectors from downloaded files.
+
+The peer index tables and RIB records of RIB dumps give the peers of a
+collector and the number of IPv4 and IPv6 unicast prefixes of each, taken
+from the latest dump listing the peer. The updates files give the number of
+UPDATE messages, the session transitions into (up) and out of (down) the
+Established state, and the last time each peer was seen.
+
+A peer has a full table of a family if it carries at least
+--full-feed-ratio times the prefixes of the largest table of the family
+among all peers; --full-feed only lists such peers. Nothing is downloaded.
+
+With --format csv the columns are source, collector, peer_ip, peer_as,
+bgp_id, family, ipv4_prefixes, ipv6_prefixes, full_ipv4, full_ipv6,
+updates, ups, downs and last_seen.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		l, err := downloader.ParseLayout(layout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if peersFormat != "text" && peersFormat != "csv" {
+			fmt.Fprintf(os.Stderr, "Error: invalid format: %s\n", peersFormat)
+			os.Exit(1)
+		}
+		files, err := downloader.LocalFiles(peersDir, l, peersSource, args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		summary := peers.New()
+		for _, f := range files {
+			feed := f.Feed()
+			if err := summary.ReadFile(feed.Source, feed.Collector, f.Path); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %s: %v\n", f.Path, err)
+				os.Exit(1)
+			}
+		}
+		if summary.Skipped > 0 {
+			slog.Warn("skipped undecodable records", "count", summary.Skipped)
+		}
+
+		var list []peers.Peer
+		for _, p := range summary.Peers(peersFullRatio) {
+			if !peersFullOnly || p.FullIPv4 || p.FullIPv6 {
+				list = append(list, p)
+			}
+		}
+		out := bufio.NewWriter(os.Stdout)
+		if peersFormat == "csv" {
+			err = writePeersCSV(out, list)
+		} else {
+			writePeers(out, list)
+		}
+		if err == nil {
+			err = out.Flush()
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// writePeers prints the peers as text, grouped by collector
+func writePeers(w io.Writer, list []peers.Peer) {
+	var source, collector string
+	for i, p := range list {
+		if i == 0 || p.Source != source || p.Collector != collector {
+			source, collector = p.Source, p.Collector
+			name := collector
+			if source != "" {
+				name = source + " " + name
+			}
+			fmt.Fprintf(w, "%s:\n", name)
+		}
+		fmt.Fprintf(w, "  %s AS%d %s %s: %d IPv4 and %d IPv6 prefixes, %d updates, %d up, %d down, last seen %s\n",
+			p.IP, p.AS, p.Family(), formatFeed(p), p.IPv4, p.IPv6, p.Updates, p.Ups, p.Downs, formatLastSeen(p.LastSeen))
+	}
+}
+
+// formatFeed names the full tables of a peer
+func formatFeed(p peers.Peer) string {
+	switch {
+	case p.FullIPv4 && p.FullIPv6:
+		return "full-ipv4+ipv6"
+	case p.FullIPv4:
+		return "full-ipv4"
+	case p.FullIPv6:
+		return "full-ipv6"
+	}
+	return "partial"
+}
+
+func formatLastSeen(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// writePeersCSV writes the peers as CSV
+func writePeersCSV(w io.Writer, list []peers.Peer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"source", "collector", "peer_ip", "peer_as", "bgp_id", "family", "ipv4_prefixes", "ipv6_prefixes",
+		"full_ipv4", "full_ipv6", "updates", "ups", "downs", "last_seen"})
+	for _, p := range list {
+		var bgpID, lastSeen string
+		if p.BGPID.IsValid() {
+			bgpID = p.BGPID.String()
+		}
+		if !p.LastSeen.IsZero() {
+			lastSeen = p.LastSeen.UTC().Format(time.RFC3339)
+		}
+		cw.Write([]string{p.Source, p.Collector, p.IP.String(), strconv.FormatUint(uint64(p.AS), 10), bgpID, p.Family(),
+			strconv.Itoa(p.IPv4), strconv.Itoa(p.IPv6), strconv.FormatBool(p.FullIPv4), strconv.FormatBool(p.FullIPv6),
+			strconv.Itoa(p.Updates), strconv.Itoa(p.Ups), strconv.Itoa(p.Downs), lastSeen})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func init() {
+	rootCmd.AddCommand(peersCmd)
+
+	peersCmd.Flags().StringVarP(&peersDir, "dir", "d", ".", "Download directory the files are stored in, used to tell their source and collector")
+	peersCmd.Flags().StringVar(&layout, "layout", "default", "Layout of the download directory (preset or template)")
+	peersCmd.Flags().StringVarP(&peersSource, "source", "S", "", "Source to assume when the layout does not encode it (ripe, routeviews)")
+	peersCmd.Flags().StringVarP(&peersFormat, "format", "f", "text", "Output format (text, csv)")
+	peersCmd.Flags().Float64Var(&peersFullRatio, "full-feed-ratio", 0.9, "Share of the largest table of a family a peer must carry to have a full table")
+	peersCmd.Flags().BoolVar(&peersFullOnly, "full-feed", false, "Only list peers with a full IPv4 or IPv6 table")
+}
@@ -0,0 +1,133 @@
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"bgp_downloader/downloader"
+	"bgp_downloader/pfx2as"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	pfx2asSources    []string
+	pfx2asCollectors []string
+	pfx2asDate       string
+	pfx2asMinPeers   int
+)
+
+var pfx2asCmd = &cobra.Command{
+	Use:   "pfx2as",
+	Short: "Build a prefix-to-AS table from a day's RIB dumps",
+	Long: `Build a prefix-to-AS table from a day's RIB dumps.
+
+The first RIB dump of --date of each collector is downloaded and the origin
+ASes of its unicast routes are collected. The table is printed in the format
+of the CAIDA Routeviews Prefix-to-AS datasets, with the number of peers that
+route the prefix as an extra column:
+
+  prefix address, prefix length, origin ASes, peers
+
+Multi-origin prefixes list their origins separated by "_", and origins that
+are AS sets list the members separated by ",". Peers are counted per
+collector, so a peer of two collectors counts twice. Collectors without a
+RIB dump on --date are skipped with a warning.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		day, err := time.Parse("2006-01-02", pfx2asDate)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid date: %s (expected YYYY-MM-DD)\n", pfx2asDate)
+			os.Exit(1)
+		}
+		l, err := downloader.ParseLayout(layout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		feeds, err := downloader.Feeds(pfx2asSources, pfx2asCollectors)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		paths, err := downloadDayRIBs(l, feeds, day)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error downloading RIBs: %v\n", err)
+			os.Exit(1)
+		}
+		if len(paths) == 0 {
+			fmt.Fprintf(os.Stderr, "Error: no RIB dumps on %s\n", pfx2asDate)
+			os.Exit(1)
+		}
+
+		table := pfx2as.New()
+		for _, p := range paths {
+			if err := table.ReadFile(p); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %s: %v\n", p, err)
+				os.Exit(1)
+			}
+		}
+		if table.Skipped > 0 {
+			slog.Warn("skipped undecodable records", "count", table.Skipped)
+		}
+		if err := table.Write(os.Stdout, pfx2asMinPeers); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// downloadDayRIBs downloads the first RIB dump of the day of each feed and
+// returns their paths. Feeds without a dump are skipped.
+func downloadDayRIBs(l downloader.Layout, feeds []downloader.Feed, day time.Time) ([]string, error) {
+	var files []downloader.RemoteFile
+	for _, feed := range feeds {
+		f, err := downloader.FirstRIB(feed.Source, feed.Collector, day)
+		if err != nil {
+			slog.Warn("skipping collector", "source", feed.Source, "collector", feed.Collector, "error", err)
+			continue
+		}
+		files = append(files, f)
+	}
+
+	progress, err := newProgress(os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	progress.Start()
+	defer progress.Stop()
+
+	local, err := downloader.DownloadLocal(downloader.Options{
+		OutputDir:   outputDir,
+		Concurrency: concurrency,
+		Layout:      l,
+		Logger:      slog.Default(),
+		Progress:    progress,
+		Metrics:     metrics,
+	}, files)
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, len(local))
+	for i, f := range local {
+		paths[i] = f.Path
+	}
+	return paths, nil
+}
+
+func init() {
+	rootCmd.AddCommand(pfx2asCmd)
+
+	pfx2asCmd.Flags().StringSliceVarP(&pfx2asSources, "source", "S", []string{"ripe", "routeviews"}, "Sources (ripe, routeviews)")
+	pfx2asCmd.Flags().StringSliceVarP(&pfx2asCollectors, "collector", "c", []string{"all"}, "Collector names, or all")
+	pfx2asCmd.Flags().StringVar(&pfx2asDate, "date", "", "Day of the RIB dumps, e.g. 2024-01-01 (required)")
+	pfx2asCmd.Flags().IntVar(&pfx2asMinPeers, "min-peers", 1, "Omit prefixes routed by fewer peers")
+	pfx2asCmd.Flags().StringVarP(&outputDir, "output", "o", ".", "Download directory")
+	pfx2asCmd.Flags().StringVar(&layout, "layout", "default", "Layout of the download directory (preset or template)")
+	pfx2asCmd.Flags().IntVarP(&concurrency, "concurrency", "n", 10, "Maximum number of concurrent downloads")
+	pfx2asCmd.Flags().StringVar(&progressMode, "progress", "auto", "Progress display on stderr (auto, tty, plain, none)")
+
+	pfx2asCmd.MarkFlagRequired("date")
+}
@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"bgp_downloader/downloader"
+)
+
+var progressMode string
+
+// newProgress returns the progress display selected by --progress, drawn
+// on out, or nil when it is disabled. Logging is redirected so that it does
+// not corrupt the terminal view.
+func newProgress(out *os.File) (*downloader.Progress, error) {
+	var p *downloader.Progress
+	switch progressMode {
+	case "auto":
+		p = downloader.NewProgress(out, downloader.IsTerminal(out))
+	case "tty":
+		p = downloader.NewProgress(out, true)
+	case "plain":
+		p = downloader.NewProgress(out, false)
+	case "none":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("invalid progress mode: %s", progressMode)
+	}
+	if err := setLogOutput(p.Wrap(os.Stderr)); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"bgp_downloader/downloader"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	pruneKeepUpdates   string
+	pruneKeepRIBs      string
+	pruneKeepRIBsEvery string
+	pruneSource        string
+	pruneDryRun        bool
+)
+
+var pruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove files outside a retention policy from a local archive",
+	Run: func(cmd *cobra.Command, args []string) {
+		l, err := downloader.ParseLayout(layout)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		opts := downloader.PruneOptions{
+			Root:   outputDir,
+			Layout: l,
+			Source: pruneSource,
+			DryRun: pruneDryRun,
+		}
+		for _, p := range []struct {
+			flag  string
+			value string
+			dest  *time.Duration
+		}{
+			{"--keep-updates", pruneKeepUpdates, &opts.KeepUpdates},
+			{"--keep-ribs", pruneKeepRIBs, &opts.KeepRIBs},
+			{"--keep-ribs-every", pruneKeepRIBsEvery, &opts.KeepRIBsEvery},
+		} {
+			if *p.dest, err = downloader.ParseRetention(p.value); err != nil {
+				fmt.Printf("Error: %s: %v\n", p.flag, err)
+				os.Exit(1)
+			}
+		}
+
+		pruned, err := downloader.Prune(opts)
+		var bytes int64
+		for _, f := range pruned {
+			fmt.Printf("%s (%s)\n", f.Path, f.Reason)
+			bytes += f.Size
+		}
+		if err != nil {
+			fmt.Printf("Error pruning %s: %v\n", outputDir, err)
+			os.Exit(1)
+		}
+		verb := "Removed"
+		if pruneDryRun {
+			verb = "Would remove"
+		}
+		fmt.Printf("%s %d files, %d bytes\n", verb, len(pruned), bytes)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(pruneCmd)
+
+	pruneCmd.Flags().StringVarP(&outputDir, "output", "o", ".", "Directory holding the downloaded files")
+	pruneCmd.Flags().StringVar(&layout, "layout", "default", "Layout of the directory (preset or template)")
+	pruneCmd.Flags().StringVarP(&pruneSource, "source", "S", "", "Source to assume when the layout does not encode it (ripe, routeviews)")
+	pruneCmd.Flags().StringVar(&pruneKeepUpdates, "keep-updates", "", "Keep updates files this long, e.g. 30d (default: keep all)")
+	pruneCmd.Flags().StringVar(&pruneKeepRIBs, "keep-ribs", "", "Keep RIB dumps this long, e.g. 1y (default: keep all)")
+	pruneCmd.Flags().StringVar(&pruneKeepRIBsEvery, "keep-ribs-every", "", "Keep only the first RIB dump of every interval, e.g. 24h")
+	pruneCmd.Flags().BoolVar(&pruneDryRun, "dry-run", false, "Only print the files that would be removed")
+}
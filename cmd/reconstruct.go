@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"time"
+
+	"bgp_downloader/downloader"
+	"bgp_downloader/ribstate"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	reconstructTime    string
+	reconstructRIB     string
+	reconstructUpdates []string
+	reconstructPeer    string
+	reconstructFormat  string
+)
+
+var reconstructCmd = &cobra.Command{
+	Use:   "reconstruct",
+	Short: "Rebuild the routing tables of a collector's peers at a point in time",
+	Long: `Rebuild the routing tables of a collector's peers at a point in time.
+
+The RIB dump preceding --time is loaded and the updates files up to --time
+are replayed on top of it. Unless --rib is given, the files are downloaded
+as by rib-at --updates.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		at, err := parseTime(reconstructTime)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --time: %v\n", err)
+			os.Exit(1)
+		}
+		var peer netip.Addr
+		if reconstructPeer != "" {
+			if peer, err = netip.ParseAddr(reconstructPeer); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: --peer: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		if reconstructFormat != "text" && reconstructFormat != "json" {
+			fmt.Fprintf(os.Stderr, "Error: invalid format: %s\n", reconstructFormat)
+			os.Exit(1)
+		}
+
+		rib, updates := reconstructRIB, reconstructUpdates
+		if rib == "" {
+			if rib, updates, err = downloadSnapshot(at); err != nil {
+				fmt.Fprintf(os.Stderr, "Error downloading RIB: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
+		state, err := ribstate.Reconstruct(rib, updates, at)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if state.Skipped > 0 {
+			slog.Warn("skipped undecodable records", "count", state.Skipped)
+		}
+
+		out := bufio.NewWriter(os.Stdout)
+		defer out.Flush()
+		enc := json.NewEncoder(out)
+		for _, t := range state.Peers() {
+			if peer.IsValid() && t.PeerIP != peer {
+				continue
+			}
+			for _, r := range t.Routes() {
+				e, err := t.Element(r, at)
+				if err != nil {
+					slog.Warn("skipped route", "peer", t.PeerIP, "prefix", r.Prefix, "error", err)
+					continue
+				}
+				if reconstructFormat == "json" {
+					enc.Encode(e)
+				} else {
+					fmt.Fprintln(out, e)
+				}
+			}
+		}
+	},
+}
+
+// downloadSnapshot downloads the RIB dump in effect at the given time and
+// the updates following it, and returns their paths
+func downloadSnapshot(at time.Time) (string, []string, error) {
+	l, err := downloader.ParseLayout(layout)
+	if err != nil {
+		return "", nil, err
+	}
+	progress, err := newProgress(os.Stderr)
+	if err != nil {
+		return "", nil, err
+	}
+	progress.Start()
+	snap, err := downloader.DownloadRIB(downloader.Options{
+		Source:      source,
+		Collector:   collector,
+		OutputDir:   outputDir,
+		Concurrency: concurrency,
+		Layout:      l,
+		Logger:      slog.Default(),
+		Progress:    progress,
+		Metrics:     metrics,
+	}, at, true)
+	progress.Stop()
+	if err != nil {
+		return "", nil, err
+	}
+	var updates []string
+	for _, f := range snap.Updates {
+		updates = append(updates, filepath.Join(outputDir, l.Path(f.FileInfo)))
+	}
+	return filepath.Join(outputDir, l.Path(snap.RIB.FileInfo)), updates, nil
+}
+
+func init() {
+	rootCmd.AddCommand(reconstructCmd)
+
+	reconstructCmd.Flags().StringVar(&reconstructTime, "time", "", "Point in time, e.g. 2021-10-04T15:40Z (UTC unless a zone is given) (required)")
+	reconstructCmd.Flags().StringVar(&reconstructRIB, "rib", "", "Local RIB dump to start from instead of downloading one")
+	reconstructCmd.Flags().StringSliceVar(&reconstructUpdates, "updates", nil, "Local updates files to replay after --rib, in time order")
+	reconstructCmd.Flags().StringVar(&reconstructPeer, "peer", "", "Only print the table of the peer with this address")
+	reconstructCmd.Flags().StringVarP(&reconstructFormat, "format", "f", "text", "Output format (text, json)")
+	reconstructCmd.Flags().StringVarP(&source, "source", "S", "ripe", "Source (ripe, routeviews)")
+	reconstructCmd.Flags().StringVarP(&collector, "collector", "c", "rrc00", "Collector name (rrc00-rrc26)")
+	reconstructCmd.Flags().StringVarP(&outputDir, "output", "o", ".", "Download directory")
+	reconstructCmd.Flags().StringVar(&layout, "layout", "default", "Layout of the download directory (preset or template)")
+	reconstructCmd.Flags().IntVarP(&concurrency, "concurrency", "n", 10, "Maximum number of concurrent downloads")
+	reconstructCmd.Flags().StringVar(&progressMode, "progress", "auto", "Progress display on stderr (auto, tty, plain, none)")
+
+	reconstructCmd.MarkFlagRequired("time")
+}
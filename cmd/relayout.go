@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"bgp_downloader/downloader"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	relayoutFrom   string
+	relayoutTo     string
+	relayoutSource string
+	relayoutDryRun bool
+)
+
+var relayoutCmd = &cobra.Command{
+	Use:   "relayout",
+	Short: "Move an existing download tree to a different layout",
+	Run: func(cmd *cobra.Command, args []string) {
+		from, err := downloader.ParseLayout(relayoutFrom)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		to, err := downloader.ParseLayout(relayoutTo)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		moves, err := downloader.PlanRelayout(outputDir, from, to, relayoutSource)
+		if err != nil {
+			fmt.Printf("Error scanning %s: %v\n", outputDir, err)
+			os.Exit(1)
+		}
+		for _, m := range moves {
+			fmt.Printf("%s -> %s\n", m.From, m.To)
+		}
+		if relayoutDryRun {
+			fmt.Printf("%d files would be moved\n", len(moves))
+			return
+		}
+		if err := downloader.ApplyRelayout(outputDir, moves); err != nil {
+			fmt.Printf("Error moving files: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Moved %d files\n", len(moves))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(relayoutCmd)
+
+	relayoutCmd.Flags().StringVarP(&outputDir, "output", "o", ".", "Directory holding the downloaded files")
+	relayoutCmd.Flags().StringVar(&relayoutFrom, "from", "default", "Current layout (preset or template)")
+	relayoutCmd.Flags().StringVar(&relayoutTo, "to", "", "New layout (preset or template) (required)")
+	relayoutCmd.Flags().StringVarP(&relayoutSource, "source", "S", "", "Source to assume when the current layout does not encode it (ripe, routeviews)")
+	relayoutCmd.Flags().BoolVar(&relayoutDryRun, "dry-run", false, "Only print the planned moves")
+
+	relayoutCmd.MarkFlagRequired("to")
+}
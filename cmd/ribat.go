@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"bgp_downloader/downloader"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	ribAtTime    string
+	ribAtUpdates bool
+)
+
+var ribAtCmd = &cobra.Command{
+	Use:   "rib-at",
+	Short: "Download the RIB dump of a collector as of a point in time",
+	Run: func(cmd *cobra.Command, args []string) {
+		at, err := parseTime(ribAtTime)
+		if err != nil {
+			fmt.Printf("Error: --time: %v\n", err)
+			os.Exit(1)
+		}
+		l, err := downloader.ParseLayout(layout)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		progress, err := newProgress(os.Stdout)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		progress.Start()
+		snap, err := downloader.DownloadRIB(downloader.Options{
+			Source:      source,
+			Collector:   collector,
+			OutputDir:   outputDir,
+			Concurrency: concurrency,
+			Layout:      l,
+			Logger:      slog.Default(),
+			Progress:    progress,
+			Metrics:     metrics,
+		}, at, ribAtUpdates)
+		progress.Stop()
+		if err != nil {
+			fmt.Printf("Error downloading RIB: %v\n", err)
+			os.Exit(1)
+		}
+		for _, f := range snap.Files() {
+			fmt.Println(filepath.Join(outputDir, l.Path(f.FileInfo)))
+		}
+	},
+}
+
+// timeLayouts are the formats accepted for points in time. Times without a
+// zone are taken as UTC.
+var timeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// parseTime parses a point in time given on the command line
+func parseTime(s string) (time.Time, error) {
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time: %s (expected e.g. 2021-10-04T15:40Z)", s)
+}
+
+func init() {
+	rootCmd.AddCommand(ribAtCmd)
+
+	ribAtCmd.Flags().StringVar(&ribAtTime, "time", "", "Point in time, e.g. 2021-10-04T15:40Z (UTC unless a zone is given) (required)")
+	ribAtCmd.Flags().BoolVar(&ribAtUpdates, "updates", false, "Also download the updates files from the RIB dump up to --time")
+	ribAtCmd.Flags().StringVarP(&source, "source", "S", "ripe", "Source (ripe, routeviews)")
+	ribAtCmd.Flags().StringVarP(&collector, "collector", "c", "rrc00", "Collector name (rrc00-rrc26)")
+	ribAtCmd.Flags().StringVarP(&outputDir, "output", "o", ".", "Output directory")
+	ribAtCmd.Flags().StringVar(&layout, "layout", "default", "Output layout (preset or template)")
+	ribAtCmd.Flags().IntVarP(&concurrency, "concurrency", "n", 10, "Maximum number of concurrent downloads")
+	ribAtCmd.Flags().StringVar(&progressMode, "progress", "auto", "Progress display (auto, tty, plain, none)")
+
+	ribAtCmd.MarkFlagRequired("time")
+}
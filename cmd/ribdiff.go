@@ -0,0 +1,204 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"bgp_downloader/ribstate"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	ribDiffAUpdates []string
+	ribDiffBUpdates []string
+	ribDiffATime    string
+	ribDiffBTime    string
+	ribDiffFormat   string
+	ribDiffSummary  bool
+)
+
+var ribDiffCmd = &cobra.Command{
+	Use:   "ribdiff A B",
+	Short: "Compare two RIB dumps or reconstructed states of a collector",
+	Long: `Compare two RIB dumps or reconstructed states of a collector.
+
+A and B are TABLE_DUMP_V2 files. With --a-updates or --b-updates the
+updates files are replayed on top of the dump, up to --a-time or --b-time
+if given, as by reconstruct.
+
+The prefixes carried by some peer only in B are reported as added, those
+only in A as removed, and those whose origins across all peers differ as
+origin changes. Routes of a peer in both states with a different AS path
+are reported as path changes, and the table of every peer is compared in
+size. With --summary only the counts and the peers are printed.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		if ribDiffFormat != "text" && ribDiffFormat != "json" {
+			fmt.Fprintf(os.Stderr, "Error: invalid format: %s\n", ribDiffFormat)
+			os.Exit(1)
+		}
+		a, err := loadRIBState(args[0], ribDiffAUpdates, ribDiffATime, "--a-time")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		b, err := loadRIBState(args[1], ribDiffBUpdates, ribDiffBTime, "--b-time")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		changes := ribstate.Diff(a, b)
+		if n := a.Skipped + b.Skipped + changes.Skipped; n > 0 {
+			slog.Warn("skipped undecodable records", "count", n)
+		}
+
+		out := bufio.NewWriter(os.Stdout)
+		if ribDiffFormat == "json" {
+			err = writeRIBDiffJSON(out, changes, ribDiffSummary)
+		} else {
+			writeRIBDiff(out, changes, ribDiffSummary)
+		}
+		if err == nil {
+			err = out.Flush()
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// loadRIBState reads a RIB dump and replays updates on top of it, up to
+// the time at if given
+func loadRIBState(rib string, updates []string, at, flag string) (*ribstate.State, error) {
+	var until time.Time
+	if at != "" {
+		var err error
+		if until, err = parseTime(at); err != nil {
+			return nil, fmt.Errorf("%s: %v", flag, err)
+		}
+	}
+	return ribstate.Reconstruct(rib, updates, until)
+}
+
+// writeRIBDiff prints the changes as text
+func writeRIBDiff(w io.Writer, c *ribstate.Changes, summary bool) {
+	fmt.Fprintf(w, "%d prefixes added, %d removed, %d origin changes, %d path changes\n",
+		len(c.Added), len(c.Removed), len(c.OriginChanges), len(c.PathChanges))
+	fmt.Fprintln(w, "\npeers:")
+	for _, p := range c.Peers {
+		fmt.Fprintf(w, "  %s AS%d: %d -> %d routes (%+d), %d added, %d removed, %d path changes\n",
+			p.PeerIP, p.PeerAS, p.Before, p.After, p.Delta(), p.Added, p.Removed, p.Changed)
+	}
+	if summary {
+		return
+	}
+	fmt.Fprintln(w)
+	for _, p := range c.Added {
+		fmt.Fprintf(w, "+ %s origin %s\n", p.Prefix, formatOrigins(p.Origins))
+	}
+	for _, p := range c.Removed {
+		fmt.Fprintf(w, "- %s origin %s\n", p.Prefix, formatOrigins(p.Origins))
+	}
+	for _, o := range c.OriginChanges {
+		fmt.Fprintf(w, "~ %s origin %s -> %s\n", o.Prefix, formatOrigins(o.Before), formatOrigins(o.After))
+	}
+	for _, p := range c.PathChanges {
+		fmt.Fprintf(w, "~ %s path %s -> %s from %s AS%d\n", p.Prefix, p.Before, p.After, p.PeerIP, p.PeerAS)
+	}
+}
+
+// formatOrigins joins origins with underscores, as in pfx2as tables
+func formatOrigins(origins []string) string {
+	if len(origins) == 0 {
+		return "none"
+	}
+	return strings.Join(origins, "_")
+}
+
+// writeRIBDiffJSON writes the changes as one JSON document
+func writeRIBDiffJSON(w io.Writer, c *ribstate.Changes, summary bool) error {
+	type peer struct {
+		PeerIP  string `json:"peer_ip"`
+		PeerAS  uint32 `json:"peer_as"`
+		Before  int    `json:"before"`
+		After   int    `json:"after"`
+		Delta   int    `json:"delta"`
+		Added   int    `json:"added"`
+		Removed int    `json:"removed"`
+		Changed int    `json:"path_changes"`
+	}
+	type prefix struct {
+		Prefix  string   `json:"prefix"`
+		Origins []string `json:"origins"`
+	}
+	type originChange struct {
+		Prefix string   `json:"prefix"`
+		Before []string `json:"before"`
+		After  []string `json:"after"`
+	}
+	type pathChange struct {
+		Prefix string `json:"prefix"`
+		PathID uint32 `json:"path_id,omitempty"`
+		PeerIP string `json:"peer_ip"`
+		PeerAS uint32 `json:"peer_as"`
+		Before string `json:"before"`
+		After  string `json:"after"`
+	}
+	doc := struct {
+		Summary struct {
+			Added         int `json:"added"`
+			Removed       int `json:"removed"`
+			OriginChanges int `json:"origin_changes"`
+			PathChanges   int `json:"path_changes"`
+		} `json:"summary"`
+		Peers         []peer         `json:"peers"`
+		Added         []prefix       `json:"added,omitempty"`
+		Removed       []prefix       `json:"removed,omitempty"`
+		OriginChanges []originChange `json:"origin_changes,omitempty"`
+		PathChanges   []pathChange   `json:"path_changes,omitempty"`
+	}{}
+	doc.Summary.Added = len(c.Added)
+	doc.Summary.Removed = len(c.Removed)
+	doc.Summary.OriginChanges = len(c.OriginChanges)
+	doc.Summary.PathChanges = len(c.PathChanges)
+	doc.Peers = []peer{}
+	for _, p := range c.Peers {
+		doc.Peers = append(doc.Peers, peer{p.PeerIP.String(), p.PeerAS, p.Before, p.After, p.Delta(), p.Added, p.Removed, p.Changed})
+	}
+	if !summary {
+		for _, p := range c.Added {
+			doc.Added = append(doc.Added, prefix{p.Prefix.String(), p.Origins})
+		}
+		for _, p := range c.Removed {
+			doc.Removed = append(doc.Removed, prefix{p.Prefix.String(), p.Origins})
+		}
+		for _, o := range c.OriginChanges {
+			doc.OriginChanges = append(doc.OriginChanges, originChange{o.Prefix.String(), o.Before, o.After})
+		}
+		for _, p := range c.PathChanges {
+			doc.PathChanges = append(doc.PathChanges, pathChange{p.Prefix.String(), p.PathID, p.PeerIP.String(), p.PeerAS, p.Before.String(), p.After.String()})
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
+
+func init() {
+	rootCmd.AddCommand(ribDiffCmd)
+
+	ribDiffCmd.Flags().StringSliceVar(&ribDiffAUpdates, "a-updates", nil, "Local updates files to replay after A, in time order")
+	ribDiffCmd.Flags().StringSliceVar(&ribDiffBUpdates, "b-updates", nil, "Local updates files to replay after B, in time order")
+	ribDiffCmd.Flags().StringVar(&ribDiffATime, "a-time", "", "Only replay the updates after A up to this time, e.g. 2021-10-04T15:40Z")
+	ribDiffCmd.Flags().StringVar(&ribDiffBTime, "b-time", "", "Only replay the updates after B up to this time, e.g. 2021-10-04T15:40Z")
+	ribDiffCmd.Flags().StringVarP(&ribDiffFormat, "format", "f", "text", "Output format (text, json)")
+	ribDiffCmd.Flags().BoolVar(&ribDiffSummary, "summary", false, "Only print the counts and the peers")
+}
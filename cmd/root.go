@@ -2,7 +2,9 @@ package cmd
 
 import (
 	"fmt"
+	"log/slog"
 	"os"
+	"strings"
 
 	"bgp_downloader/downloader"
 
@@ -17,12 +19,19 @@ var (
 	outputDir   string
 	concurrency int
 	source      string
+	layout      string
 )
 
 var rootCmd = &cobra.Command{
 	Use:   "bgp-downloader",
 	Short: "A tool to download BGP data",
 	Long:  `A tool to download BGP data from RIPE and RouteViews repositories.`,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := setupLogger(); err != nil {
+			return err
+		}
+		return startMetrics()
+	},
 	Run: func(cmd *cobra.Command, args []string) {
 		fmt.Println("Please specify a subcommand. Use --help for more information.")
 	},
@@ -32,7 +41,36 @@ var downloadCmd = &cobra.Command{
 	Use:   "download",
 	Short: "Download BGP data",
 	Run: func(cmd *cobra.Command, args []string) {
-		err := downloader.DownloadBGPData(source, collector, dataType, startDate, endDate, outputDir, concurrency)
+		l, err := downloader.ParseLayout(layout)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		types, err := downloader.ParseDumpTypes(dataType)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		progress, err := newProgress(os.Stdout)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		progress.Start()
+		err = downloader.Download(downloader.Options{
+			Source:      source,
+			Collector:   collector,
+			Types:       types,
+			StartDate:   startDate,
+			EndDate:     endDate,
+			OutputDir:   outputDir,
+			Concurrency: concurrency,
+			Layout:      l,
+			Logger:      slog.Default(),
+			Progress:    progress,
+			Metrics:     metrics,
+		})
+		progress.Stop()
 		if err != nil {
 			fmt.Printf("Error downloading BGP data: %v\n", err)
 			os.Exit(1)
@@ -47,14 +85,21 @@ func Execute() error {
 func init() {
 	rootCmd.AddCommand(downloadCmd)
 
+	// Logging flags
+	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "info", "Log level (debug, info, warn, error)")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", "text", "Log format (text, json)")
+	rootCmd.PersistentFlags().StringVar(&metricsAddr, "metrics-addr", "", "Serve Prometheus metrics on this address (e.g. :9100)")
+
 	// Download command flags
 	downloadCmd.Flags().StringVarP(&source, "source", "S", "ripe", "Source (ripe, routeviews)")
 	downloadCmd.Flags().StringVarP(&collector, "collector", "c", "rrc00", "Collector name (rrc00-rrc26)")
-	downloadCmd.Flags().StringVarP(&dataType, "type", "t", "bview", "Data type (bview/rib, updates, all)")
+	downloadCmd.Flags().StringVarP(&dataType, "type", "t", "bview", "Data type (rib/bview, updates, all); RIPE and RouteViews names are interchangeable")
 	downloadCmd.Flags().StringVarP(&startDate, "start-date", "s", "", "Start date (YYYY-MM-DD) (required)")
 	downloadCmd.Flags().StringVarP(&endDate, "end-date", "e", "", "End date (YYYY-MM-DD) (required)")
 	downloadCmd.Flags().StringVarP(&outputDir, "output", "o", ".", "Output directory")
 	downloadCmd.Flags().IntVarP(&concurrency, "concurrency", "n", 10, "Maximum number of concurrent downloads")
+	downloadCmd.Flags().StringVar(&progressMode, "progress", "auto", "Progress display (auto, tty, plain, none)")
+	downloadCmd.Flags().StringVar(&layout, "layout", "default", "Output layout: preset ("+strings.Join(downloader.LayoutPresets(), ", ")+") or template")
 
 	downloadCmd.MarkFlagRequired("start-date")
 	downloadCmd.MarkFlagRequired("end-date")
@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+
+	"bgp_downloader/downloader"
+	"bgp_downloader/rpki"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	rovReportDir     string
+	rovReportSource  string
+	rovReportVRPFile string
+	rovReportFormat  string
+)
+
+var rovReportCmd = &cobra.Command{
+	Use:   "rov-report [file or directory]...",
+	Short: "Summarise the RPKI validity of the routes of downloaded RIB dumps",
+	Long: `Summarise the RPKI validity of the routes of downloaded RIB dumps.
+
+Every unicast route of the RIB dumps is validated against the VRP export
+given with --rpki-vrps, and the routes in each state are counted per
+collector and per peer. Directories are searched for files stored in
+--layout; updates files are skipped. Nothing is downloaded.
+
+A path ending in an AS set has no single origin (NONE in RFC 6811) and is
+invalid wherever a VRP covers the prefix. Routes whose AS path has no AS
+at all, as learned over iBGP, are originated by an AS the path does not
+tell: they are left out of the counts and their number is logged.
+
+With --format csv the columns are source, collector, peer_ip, peer_as,
+routes, valid, invalid and not_found; the peer columns are empty in the
+rows of collector totals.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		l, err := downloader.ParseLayout(layout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if rovReportFormat != "text" && rovReportFormat != "csv" {
+			fmt.Fprintf(os.Stderr, "Error: invalid format: %s\n", rovReportFormat)
+			os.Exit(1)
+		}
+		vrps, err := rpki.Load(rovReportVRPFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		files, err := downloader.LocalFiles(rovReportDir, l, rovReportSource, args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		report := rpki.NewReport(vrps)
+		for _, f := range files {
+			if f.DumpType == downloader.DumpUpdates {
+				continue
+			}
+			collector := f.Feed().Collector
+			if err := report.ReadFile(f.Source, collector, f.Path); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %s: %v\n", f.Path, err)
+				os.Exit(1)
+			}
+		}
+		if report.Skipped > 0 {
+			slog.Warn("skipped undecodable records", "count", report.Skipped)
+		}
+		if report.NoOrigin > 0 {
+			slog.Info("skipped routes without an origin AS", "count", report.NoOrigin)
+		}
+
+		out := bufio.NewWriter(os.Stdout)
+		if rovReportFormat == "csv" {
+			err = writeROVReportCSV(out, report.Collectors())
+		} else {
+			writeROVReport(out, report.Collectors(), vrps.Len())
+		}
+		if err == nil {
+			err = out.Flush()
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// writeROVReport prints the summaries as text
+func writeROVReport(w io.Writer, reports []rpki.CollectorReport, vrps int) {
+	fmt.Fprintf(w, "%d VRPs\n", vrps)
+	for _, c := range reports {
+		name := c.Collector
+		if c.Source != "" {
+			name = c.Source + " " + name
+		}
+		fmt.Fprintf(w, "%s: %s\n", name, formatCounts(c.Counts))
+		for _, p := range c.Peers {
+			fmt.Fprintf(w, "  %s AS%d: %s\n", p.PeerIP, p.PeerAS, formatCounts(p.Counts))
+		}
+	}
+}
+
+// formatCounts formats counts with the share of each state
+func formatCounts(c rpki.Counts) string {
+	total := c.Total()
+	pct := func(n int) float64 {
+		if total == 0 {
+			return 0
+		}
+		return 100 * float64(n) / float64(total)
+	}
+	return fmt.Sprintf("%d routes, valid %.1f%% (%d), invalid %.1f%% (%d), not-found %.1f%% (%d)",
+		total, pct(c.Valid), c.Valid, pct(c.Invalid), c.Invalid, pct(c.NotFound), c.NotFound)
+}
+
+// writeROVReportCSV writes the summaries as CSV
+func writeROVReportCSV(w io.Writer, reports []rpki.CollectorReport) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"source", "collector", "peer_ip", "peer_as", "routes", "valid", "invalid", "not_found"})
+	row := func(c rpki.CollectorReport, ip, as string, n rpki.Counts) {
+		cw.Write([]string{c.Source, c.Collector, ip, as,
+			strconv.Itoa(n.Total()), strconv.Itoa(n.Valid), strconv.Itoa(n.Invalid), strconv.Itoa(n.NotFound)})
+	}
+	for _, c := range reports {
+		row(c, "", "", c.Counts)
+		for _, p := range c.Peers {
+			row(c, p.PeerIP.String(), strconv.FormatUint(uint64(p.PeerAS), 10), p.Counts)
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func init() {
+	rootCmd.AddCommand(rovReportCmd)
+
+	rovReportCmd.Flags().StringVar(&rovReportVRPFile, "rpki-vrps", "", "VRP export to validate against (rpki-client JSON, Routinator JSON or CSV) (required)")
+	rovReportCmd.Flags().StringVarP(&rovReportDir, "dir", "d", ".", "Download directory the files are stored in, used to tell their source and collector")
+	rovReportCmd.Flags().StringVar(&layout, "layout", "default", "Layout of the download directory (preset or template)")
+	rovReportCmd.Flags().StringVarP(&rovReportSource, "source", "S", "", "Source to assume when the layout does not encode it (ripe, routeviews)")
+	rovReportCmd.Flags().StringVarP(&rovReportFormat, "format", "f", "text", "Output format (text, csv)")
+
+	rovReportCmd.MarkFlagRequired("rpki-vrps")
+}
@@ -0,0 +1,172 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"time"
+
+	"bgp_downloader/downloader"
+	"bgp_downloader/sessions"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	sessionsSource      string
+	sessionsCollectors  []string
+	sessionsFrom        string
+	sessionsTo          string
+	sessionsFormat      string
+	sessionsAllStates   bool
+	sessionsResetWindow time.Duration
+	sessionsResetShare  float64
+)
+
+var sessionsCmd = &cobra.Command{
+	Use:   "sessions",
+	Short: "Print a timeline of BGP session state changes and feed gaps",
+	Long: `Print a timeline of BGP session state changes and feed gaps.
+
+The state changes in the updates files of a local archive between --from
+and --to are listed per peer as it goes down (leaves the Established state)
+or up (reaches it); --all-states lists the other transitions too. They are
+merged with the gaps of the updates feeds, found as by status, and with the
+resets of collectors: --reset-share of the peers seen at a collector going
+down within --reset-window. Downs that are part of a reset are marked, as
+they are more likely caused by the collector than by routing events.
+
+Nothing is downloaded.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		from, err := parseTime(sessionsFrom)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --from: %v\n", err)
+			os.Exit(1)
+		}
+		to, err := parseTime(sessionsTo)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --to: %v\n", err)
+			os.Exit(1)
+		}
+		if to.Before(from) {
+			fmt.Fprintln(os.Stderr, "Error: --to is before --from")
+			os.Exit(1)
+		}
+		if sessionsFormat != "text" && sessionsFormat != "json" {
+			fmt.Fprintf(os.Stderr, "Error: invalid format: %s\n", sessionsFormat)
+			os.Exit(1)
+		}
+		l, err := downloader.ParseLayout(layout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		timeline := sessions.New(sessions.Options{
+			AllStates:   sessionsAllStates,
+			ResetWindow: sessionsResetWindow,
+			ResetShare:  sessionsResetShare,
+		})
+
+		report, err := downloader.Status(downloader.StatusOptions{
+			Root:       outputDir,
+			Layout:     l,
+			Source:     sessionsSource,
+			Collectors: sessionsCollectors,
+			Types:      []downloader.DumpType{downloader.DumpUpdates},
+			Start:      from.Truncate(24 * time.Hour),
+			End:        to.Truncate(24 * time.Hour),
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error checking %s: %v\n", outputDir, err)
+			os.Exit(1)
+		}
+		for _, st := range report {
+			// Status checks whole days; keep the missing files that
+			// cover part of the window
+			for _, g := range st.Gaps {
+				first, last := g.From, g.To
+				for !first.After(last) && !first.Add(st.Cadence).After(from) {
+					first = first.Add(st.Cadence)
+				}
+				for !last.Before(first) && last.After(to) {
+					last = last.Add(-st.Cadence)
+				}
+				if last.Before(first) {
+					continue
+				}
+				files := int(last.Sub(first)/st.Cadence) + 1
+				timeline.AddGap(st.Source, st.Collector, first, last.Add(st.Cadence), files)
+			}
+		}
+
+		files, err := downloader.LocalFiles(outputDir, l, sessionsSource, []string{outputDir})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		var updates []downloader.LocalFile
+		for _, f := range files {
+			if f.DumpType != downloader.DumpUpdates || (sessionsSource != "" && f.Source != sessionsSource) ||
+				(len(sessionsCollectors) > 0 && !slices.Contains(sessionsCollectors, f.Collector)) {
+				continue
+			}
+			// An updates file covers one cadence from its dump time
+			if f.Time.After(to) || !f.Time.Add(downloader.Cadence(f.Source, f.DumpType)).After(from) {
+				continue
+			}
+			updates = append(updates, f)
+		}
+
+		s := downloader.OpenFiles(updates, slog.Default())
+		defer s.Close()
+		for s.Next() {
+			rec := s.Record()
+			ts := rec.Header().Timestamp
+			if ts.After(to) {
+				break
+			}
+			if !ts.Before(from) {
+				timeline.Add(rec.Source, rec.Collector, rec.Record)
+			}
+		}
+		if err := s.Err(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		out := bufio.NewWriter(os.Stdout)
+		enc := json.NewEncoder(out)
+		for _, e := range timeline.Events() {
+			if sessionsFormat == "json" {
+				enc.Encode(e)
+			} else {
+				fmt.Fprintln(out, e)
+			}
+		}
+		if err := out.Flush(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(sessionsCmd)
+
+	sessionsCmd.Flags().StringVarP(&outputDir, "output", "o", ".", "Directory holding the downloaded files")
+	sessionsCmd.Flags().StringVar(&layout, "layout", "default", "Layout of the directory (preset or template)")
+	sessionsCmd.Flags().StringVarP(&sessionsSource, "source", "S", "", "Only read this source; assumed when the layout does not encode it (ripe, routeviews)")
+	sessionsCmd.Flags().StringSliceVarP(&sessionsCollectors, "collector", "c", nil, "Collectors to read (default: all found)")
+	sessionsCmd.Flags().StringVar(&sessionsFrom, "from", "", "Start time, e.g. 2021-10-04T15:00Z (UTC unless a zone is given) (required)")
+	sessionsCmd.Flags().StringVar(&sessionsTo, "to", "", "End time, e.g. 2021-10-04T22:00Z (UTC unless a zone is given) (required)")
+	sessionsCmd.Flags().StringVarP(&sessionsFormat, "format", "f", "text", "Output format (text, json)")
+	sessionsCmd.Flags().BoolVar(&sessionsAllStates, "all-states", false, "Also list the state changes that neither reach nor leave Established")
+	sessionsCmd.Flags().DurationVar(&sessionsResetWindow, "reset-window", time.Minute, "Longest time between the downs of a collector reset")
+	sessionsCmd.Flags().Float64Var(&sessionsResetShare, "reset-share", 0.5, "Share of a collector's peers that must go down together for a reset")
+
+	sessionsCmd.MarkFlagRequired("from")
+	sessionsCmd.MarkFlagRequired("to")
+}
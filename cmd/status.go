@@ -0,0 +1,156 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"bgp_downloader/downloader"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	statusSource     string
+	statusCollectors string
+	statusType       string
+	statusStart      string
+	statusEnd        string
+	statusRemote     bool
+	statusFix        bool
+)
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Report missing, empty and partial files in a local archive",
+	Run: func(cmd *cobra.Command, args []string) {
+		l, err := downloader.ParseLayout(layout)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		types, err := downloader.ParseDumpTypes(statusType)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		opts := downloader.StatusOptions{
+			Root:   outputDir,
+			Layout: l,
+			Source: statusSource,
+			Types:  types,
+			Remote: statusRemote || statusFix,
+		}
+		if statusCollectors != "" {
+			opts.Collectors = strings.Split(statusCollectors, ",")
+		}
+		for _, d := range []struct {
+			flag  string
+			value string
+			dest  *time.Time
+		}{
+			{"--start-date", statusStart, &opts.Start},
+			{"--end-date", statusEnd, &opts.End},
+		} {
+			if d.value == "" {
+				continue
+			}
+			if *d.dest, err = time.Parse("2006-01-02", d.value); err != nil {
+				fmt.Printf("Error: %s: invalid date: %s\n", d.flag, d.value)
+				os.Exit(1)
+			}
+		}
+
+		report, err := downloader.Status(opts)
+		if err != nil {
+			fmt.Printf("Error checking %s: %v\n", outputDir, err)
+			os.Exit(1)
+		}
+		var missing []downloader.RemoteFile
+		var broken []string
+		for _, st := range report {
+			printStatus(st)
+			missing = append(missing, st.Missing...)
+			broken = append(broken, st.Empty...)
+			broken = append(broken, st.Partial...)
+		}
+		if !statusFix {
+			return
+		}
+
+		// Remove the broken files so that they are fetched again
+		for _, p := range broken {
+			if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		if len(missing) == 0 {
+			fmt.Println("Nothing to download")
+			return
+		}
+		fmt.Printf("Downloading %d missing files\n", len(missing))
+		progress, err := newProgress(os.Stdout)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		progress.Start()
+		err = downloader.DownloadFiles(downloader.Options{
+			OutputDir:   outputDir,
+			Concurrency: concurrency,
+			Layout:      l,
+			Logger:      slog.Default(),
+			Progress:    progress,
+			Metrics:     metrics,
+		}, missing)
+		progress.Stop()
+		if err != nil {
+			fmt.Printf("Error downloading BGP data: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// printStatus prints the report of one collector feed
+func printStatus(st downloader.CollectorStatus) {
+	const dateLayout = "2006-01-02 15:04"
+	fmt.Printf("%s %s %s", st.Source, st.Collector, st.Type)
+	if st.Start.IsZero() {
+		fmt.Println(": no files")
+		return
+	}
+	fmt.Printf(" %s .. %s every %s\n", st.Start.Format(dateLayout), st.End.Format(dateLayout), st.Cadence)
+	fmt.Printf("  coverage %.1f%% (%d/%d)\n", st.Coverage(), st.Present, st.Expected)
+	for _, g := range st.Gaps {
+		fmt.Printf("  missing %s\n", g)
+	}
+	for _, p := range st.Empty {
+		fmt.Printf("  empty %s\n", p)
+	}
+	for _, p := range st.Partial {
+		fmt.Printf("  partial %s\n", p)
+	}
+	if st.Upstream > 0 {
+		fmt.Printf("  %d dumps missing upstream\n", st.Upstream)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+
+	statusCmd.Flags().StringVarP(&outputDir, "output", "o", ".", "Directory holding the downloaded files")
+	statusCmd.Flags().StringVar(&layout, "layout", "default", "Layout of the directory (preset or template)")
+	statusCmd.Flags().StringVarP(&statusSource, "source", "S", "", "Only check this source; assumed when the layout does not encode it (ripe, routeviews)")
+	statusCmd.Flags().StringVarP(&statusCollectors, "collector", "c", "", "Comma separated collectors to check (default: all found)")
+	statusCmd.Flags().StringVarP(&statusType, "type", "t", "all", "Data type (rib/bview, updates, all)")
+	statusCmd.Flags().StringVarP(&statusStart, "start-date", "s", "", "Start date (YYYY-MM-DD) (default: day of the oldest file)")
+	statusCmd.Flags().StringVarP(&statusEnd, "end-date", "e", "", "End date (YYYY-MM-DD) (default: day of the newest file)")
+	statusCmd.Flags().BoolVar(&statusRemote, "remote", false, "Compare with the upstream listing instead of the nominal cadence")
+	statusCmd.Flags().BoolVar(&statusFix, "fix", false, "Download the missing files and replace empty or partial ones (implies --remote)")
+	statusCmd.Flags().IntVarP(&concurrency, "concurrency", "n", 10, "Maximum number of concurrent downloads with --fix")
+	statusCmd.Flags().StringVar(&progressMode, "progress", "auto", "Progress display with --fix (auto, tty, plain, none)")
+}
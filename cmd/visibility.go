@@ -0,0 +1,195 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"os"
+	"strconv"
+	"time"
+
+	"bgp_downloader/downloader"
+	"bgp_downloader/visibility"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	visibilityPrefixes   []string
+	visibilitySources    []string
+	visibilityCollectors []string
+	visibilityFrom       string
+	visibilityTo         string
+	visibilityBucket     time.Duration
+)
+
+var visibilityCmd = &cobra.Command{
+	Use:   "visibility",
+	Short: "Track how many peers see prefixes, and from which origins, over time",
+	Long: `Track how many peers see prefixes, and from which origins, over time.
+
+For each collector the RIB dump in effect at --from and the updates files
+up to --to are downloaded and replayed, and the routes of every peer to the
+exact prefixes given with --prefix are sampled every --bucket from --from
+to --to. Collectors without a RIB dump are skipped with a warning.
+
+The samples are written as CSV with the columns time, prefix, peers, origin
+and origin_peers: peers counts the peers routing to the prefix, and each
+origin gets a row with the number of peers whose routes it originates. A
+prefix no peer routes to gets one row with an empty origin. Origins that
+are AS sets list the members separated by ",".`,
+	Run: func(cmd *cobra.Command, args []string) {
+		from, err := parseTime(visibilityFrom)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --from: %v\n", err)
+			os.Exit(1)
+		}
+		to, err := parseTime(visibilityTo)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --to: %v\n", err)
+			os.Exit(1)
+		}
+		if to.Before(from) {
+			fmt.Fprintln(os.Stderr, "Error: --to is before --from")
+			os.Exit(1)
+		}
+		if visibilityBucket <= 0 {
+			fmt.Fprintf(os.Stderr, "Error: invalid bucket: %s\n", visibilityBucket)
+			os.Exit(1)
+		}
+		var prefixes []netip.Prefix
+		for _, s := range visibilityPrefixes {
+			p, err := netip.ParsePrefix(s)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: --prefix: %v\n", err)
+				os.Exit(1)
+			}
+			prefixes = append(prefixes, p)
+		}
+		l, err := downloader.ParseLayout(layout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		feeds, err := downloader.Feeds(visibilitySources, visibilityCollectors)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		files, err := downloadRanges(l, feeds, from, to)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error downloading BGP data: %v\n", err)
+			os.Exit(1)
+		}
+		if len(files) == 0 {
+			fmt.Fprintln(os.Stderr, "Error: no RIB dumps found")
+			os.Exit(1)
+		}
+
+		tracker := visibility.New(prefixes)
+		cw := csv.NewWriter(os.Stdout)
+		cw.Write([]string{"time", "prefix", "peers", "origin", "origin_peers"})
+		next := from
+		// sample writes the samples of the buckets up to before
+		sample := func(before time.Time) {
+			for ; next.Before(before) && !next.After(to); next = next.Add(visibilityBucket) {
+				for _, s := range tracker.Samples(next) {
+					writeSample(cw, s)
+				}
+			}
+		}
+		skipped := 0
+		s := downloader.OpenFiles(files, slog.Default())
+		defer s.Close()
+		for s.Next() {
+			rec := s.Record()
+			ts := rec.Header().Timestamp
+			if ts.After(to) {
+				break
+			}
+			sample(ts)
+			if err := tracker.Apply(rec.Source, rec.Collector, rec.Record); err != nil {
+				skipped++
+			}
+		}
+		if err := s.Err(); err != nil {
+			cw.Flush()
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		sample(to.Add(time.Nanosecond))
+		if skipped > 0 {
+			slog.Warn("skipped undecodable records", "count", skipped)
+		}
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// writeSample writes the rows of a sample
+func writeSample(cw *csv.Writer, s visibility.Sample) {
+	t := s.Time.UTC().Format(time.RFC3339)
+	peers := strconv.Itoa(s.Peers)
+	if len(s.Origins) == 0 {
+		cw.Write([]string{t, s.Prefix.String(), peers, "", "0"})
+		return
+	}
+	for _, o := range s.Origins {
+		cw.Write([]string{t, s.Prefix.String(), peers, o.Origin, strconv.Itoa(o.Peers)})
+	}
+}
+
+// downloadRanges downloads the RIB dump of each feed in effect at from and
+// its updates files up to to, and returns the local files. Feeds without a
+// dump are skipped.
+func downloadRanges(l downloader.Layout, feeds []downloader.Feed, from, to time.Time) ([]downloader.LocalFile, error) {
+	var files []downloader.RemoteFile
+	for _, feed := range feeds {
+		snap, err := downloader.FindRange(feed.Source, feed.Collector, from, to)
+		if err != nil {
+			slog.Warn("skipping collector", "source", feed.Source, "collector", feed.Collector, "error", err)
+			continue
+		}
+		files = append(files, snap.Files()...)
+	}
+
+	progress, err := newProgress(os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	progress.Start()
+	defer progress.Stop()
+
+	return downloader.DownloadLocal(downloader.Options{
+		OutputDir:   outputDir,
+		Concurrency: concurrency,
+		Layout:      l,
+		Logger:      slog.Default(),
+		Progress:    progress,
+		Metrics:     metrics,
+	}, files)
+}
+
+func init() {
+	rootCmd.AddCommand(visibilityCmd)
+
+	visibilityCmd.Flags().StringSliceVar(&visibilityPrefixes, "prefix", nil, "Prefixes to track, e.g. 192.0.2.0/24 (required)")
+	visibilityCmd.Flags().StringVar(&visibilityFrom, "from", "", "Start time, e.g. 2021-10-04T15:00Z (UTC unless a zone is given) (required)")
+	visibilityCmd.Flags().StringVar(&visibilityTo, "to", "", "End time, e.g. 2021-10-04T22:00Z (UTC unless a zone is given) (required)")
+	visibilityCmd.Flags().DurationVar(&visibilityBucket, "bucket", 5*time.Minute, "Time between samples")
+	visibilityCmd.Flags().StringSliceVarP(&visibilitySources, "source", "S", []string{"ripe", "routeviews"}, "Sources (ripe, routeviews)")
+	visibilityCmd.Flags().StringSliceVarP(&visibilityCollectors, "collector", "c", []string{"all"}, "Collector names, or all")
+	visibilityCmd.Flags().StringVarP(&outputDir, "output", "o", ".", "Download directory")
+	visibilityCmd.Flags().StringVar(&layout, "layout", "default", "Layout of the download directory (preset or template)")
+	visibilityCmd.Flags().IntVarP(&concurrency, "concurrency", "n", 10, "Maximum number of concurrent downloads")
+	visibilityCmd.Flags().StringVar(&progressMode, "progress", "auto", "Progress display on stderr (auto, tty, plain, none)")
+
+	visibilityCmd.MarkFlagRequired("prefix")
+	visibilityCmd.MarkFlagRequired("from")
+	visibilityCmd.MarkFlagRequired("to")
+}
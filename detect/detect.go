@@ -0,0 +1,220 @@
+// Package detect flags possible hijacks in a stream of BGP updates:
+// prefixes announced by an origin AS other than their known origins (MOAS
+// conflicts), new more-specifics of a prefix of another origin, and such
+// conflicts that end shortly after they began.
+package detect
+
+import (
+	"net/netip"
+	"slices"
+	"sort"
+	"time"
+
+	"bgp_downloader/mrt"
+	"bgp_downloader/pfx2as"
+)
+
+// Options selects what a Detector reports.
+type Options struct {
+	// MOAS reports announcements of known prefixes by other origins.
+	MOAS bool
+	// Subprefix reports announcements of unknown prefixes by an origin
+	// other than those of the closest covering known prefix.
+	Subprefix bool
+	// ShortLived is the longest a conflict may last to be reported again
+	// when it ends; zero reports no ends.
+	ShortLived time.Duration
+}
+
+// Detector tracks the origins of prefixes and the conflicts with them. The
+// zero value is not usable; create one with New.
+type Detector struct {
+	opts      Options
+	known     map[netip.Prefix][]string
+	learned   map[netip.Prefix]bool // known from the updates, not the baseline
+	conflicts map[netip.Prefix][]*conflict
+}
+
+// conflict is an origin announced in conflict with the known origins
+type conflict struct {
+	start    Event
+	carriers map[carrier]bool
+}
+
+// carrier is a route of a peer
+type carrier struct {
+	source, collector string
+	peer              netip.Addr
+	pathID            uint32
+}
+
+// New returns a Detector with no known origins.
+func New(opts Options) *Detector {
+	return &Detector{
+		opts:      opts,
+		known:     make(map[netip.Prefix][]string),
+		learned:   make(map[netip.Prefix]bool),
+		conflicts: make(map[netip.Prefix][]*conflict),
+	}
+}
+
+// LoadBaseline takes the origins of the prefixes of a prefix-to-AS table
+// seen by at least minPeers peers as the known origins.
+//
+// The first origin announced for a prefix missing from the baseline
+// becomes its known origin, so conflicts that began before the updates are
+// missed or reported the wrong way round; events against such learned
+// origins are marked as Learned.
+func (d *Detector) LoadBaseline(t *pfx2as.Table, minPeers int) {
+	for _, e := range t.Entries(minPeers) {
+		origins := make([]string, len(e.Origins))
+		for i, o := range e.Origins {
+			origins[i] = mrt.FormatOrigin(o)
+		}
+		d.known[e.Prefix] = origins
+	}
+}
+
+// Process updates the state with an element of a collector's updates and
+// returns the events it caused. Elements must be given in time order.
+func (d *Detector) Process(source, collector string, e mrt.Element) []Event {
+	switch e.Type {
+	case mrt.ElemState:
+		if e.OldState == mrt.StateEstablished && e.NewState != mrt.StateEstablished {
+			return d.sessionDown(source, collector, e.PeerIP, e.Time)
+		}
+		return nil
+	case mrt.ElemWithdraw:
+		return d.drop(e.Prefix, carrier{source, collector, e.PeerIP, e.PathID}, "", e.Time)
+	case mrt.ElemAnnounce:
+	default:
+		return nil
+	}
+
+	c := carrier{source, collector, e.PeerIP, e.PathID}
+	origins := e.Attributes.ASPath.Origins()
+	if len(origins) == 0 {
+		return d.drop(e.Prefix, c, "", e.Time)
+	}
+	origin := mrt.FormatOrigin(origins)
+	// The announcement replaces the previous route of the peer
+	events := d.drop(e.Prefix, c, origin, e.Time)
+
+	ev := Event{
+		Time:      e.Time,
+		Prefix:    e.Prefix,
+		Origin:    origin,
+		Source:    source,
+		Collector: collector,
+		PeerIP:    e.PeerIP,
+		PeerAS:    e.PeerAS,
+	}
+	if known, ok := d.known[e.Prefix]; ok {
+		if d.opts.MOAS && !slices.Contains(known, origin) {
+			ev.Kind, ev.Expected, ev.ASPath = MOAS, known, e.Attributes.ASPath.String()
+			ev.Learned = d.learned[e.Prefix]
+			events = d.conflict(ev, c, events)
+		}
+		return events
+	}
+	if d.opts.Subprefix {
+		if covering, known, ok := d.covering(e.Prefix); ok && !slices.Contains(known, origin) {
+			ev.Kind, ev.Expected, ev.Covering = Subprefix, known, covering
+			ev.Learned = d.learned[covering]
+			ev.ASPath = e.Attributes.ASPath.String()
+			return d.conflict(ev, c, events)
+		}
+	}
+	d.known[e.Prefix] = []string{origin}
+	d.learned[e.Prefix] = true
+	return events
+}
+
+// conflict adds a carrier to the conflict of the event, starting the
+// conflict if it is new
+func (d *Detector) conflict(ev Event, c carrier, events []Event) []Event {
+	for _, cf := range d.conflicts[ev.Prefix] {
+		if cf.start.Origin == ev.Origin {
+			cf.carriers[c] = true
+			return events
+		}
+	}
+	d.conflicts[ev.Prefix] = append(d.conflicts[ev.Prefix], &conflict{
+		start:    ev,
+		carriers: map[carrier]bool{c: true},
+	})
+	return append(events, ev)
+}
+
+// drop removes a carrier from the conflicts of a prefix, except for the
+// conflict of the origin keep, and ends the conflicts left without one
+func (d *Detector) drop(prefix netip.Prefix, c carrier, keep string, at time.Time) []Event {
+	var events []Event
+	conflicts := d.conflicts[prefix]
+	for i := 0; i < len(conflicts); i++ {
+		cf := conflicts[i]
+		if cf.start.Origin == keep || !cf.carriers[c] {
+			continue
+		}
+		delete(cf.carriers, c)
+		if len(cf.carriers) > 0 {
+			continue
+		}
+		conflicts = append(conflicts[:i], conflicts[i+1:]...)
+		i--
+		if ev, ok := d.end(cf, at); ok {
+			events = append(events, ev)
+		}
+	}
+	if len(conflicts) == 0 {
+		delete(d.conflicts, prefix)
+	} else {
+		d.conflicts[prefix] = conflicts
+	}
+	return events
+}
+
+// sessionDown drops the routes of a peer whose session went down
+func (d *Detector) sessionDown(source, collector string, peer netip.Addr, at time.Time) []Event {
+	type route struct {
+		prefix netip.Prefix
+		c      carrier
+	}
+	var routes []route
+	for prefix, conflicts := range d.conflicts {
+		for _, cf := range conflicts {
+			for c := range cf.carriers {
+				if c.source == source && c.collector == collector && c.peer == peer {
+					routes = append(routes, route{prefix, c})
+				}
+			}
+		}
+	}
+	sort.Slice(routes, func(i, j int) bool { return mrt.ComparePrefix(routes[i].prefix, routes[j].prefix) < 0 })
+	var events []Event
+	for _, r := range routes {
+		events = append(events, d.drop(r.prefix, r.c, "", at)...)
+	}
+	return events
+}
+
+// end returns the event reporting the end of a short-lived conflict
+func (d *Detector) end(cf *conflict, at time.Time) (Event, bool) {
+	ev := cf.start
+	ev.End = true
+	ev.Time = at
+	ev.Duration = at.Sub(cf.start.Time)
+	return ev, d.opts.ShortLived > 0 && ev.Duration <= d.opts.ShortLived
+}
+
+// covering returns the longest known prefix covering a prefix. The default
+// route is not considered, as it covers every prefix.
+func (d *Detector) covering(p netip.Prefix) (netip.Prefix, []string, bool) {
+	for bits := p.Bits() - 1; bits > 0; bits-- {
+		q, _ := p.Addr().Prefix(bits)
+		if known, ok := d.known[q]; ok {
+			return q, known, true
+		}
+	}
+	return netip.Prefix{}, nil, false
+}
@@ -0,0 +1,192 @@
+package detect
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"bgp_downloader/internal/mrttest"
+	"bgp_downloader/mrt"
+	"bgp_downloader/pfx2as"
+)
+
+func announce(sec int64, peer, prefix string, path ...uint32) mrt.Element {
+	return mrt.Element{
+		Type:       mrt.ElemAnnounce,
+		Time:       time.Unix(sec, 0),
+		PeerIP:     netip.MustParseAddr(peer),
+		PeerAS:     path[0],
+		Prefix:     netip.MustParsePrefix(prefix),
+		Attributes: &mrt.Attributes{ASPath: mrt.ASPath{{Type: mrt.ASSequence, ASNs: path}}},
+	}
+}
+
+func withdraw(sec int64, peer, prefix string) mrt.Element {
+	return mrt.Element{Type: mrt.ElemWithdraw, Time: time.Unix(sec, 0), PeerIP: netip.MustParseAddr(peer), Prefix: netip.MustParsePrefix(prefix)}
+}
+
+func down(sec int64, peer string) mrt.Element {
+	return mrt.Element{
+		Type:     mrt.ElemState,
+		Time:     time.Unix(sec, 0),
+		PeerIP:   netip.MustParseAddr(peer),
+		OldState: mrt.StateEstablished,
+		NewState: mrt.StateIdle,
+	}
+}
+
+// baseline returns a table with a prefix originated by 64600
+func baseline(t *testing.T) *pfx2as.Table {
+	dump := mrttest.WriteFile(t,
+		mrttest.PeerIndex(900, mrttest.Peer{IP: "198.51.100.1", AS: 64500}),
+		mrttest.RIB(900, 0, "203.0.113.0/24", mrttest.Route{Attrs: mrttest.Attrs(mrttest.ASPath4(64500, 64600))}),
+	)
+	tbl := pfx2as.New()
+	if err := tbl.ReadFile(dump); err != nil {
+		t.Fatal(err)
+	}
+	return tbl
+}
+
+// summary formats the fields of an event the tests check
+func summary(e Event) string {
+	s := fmt.Sprintf("%s %s origin %s expected %s", e.Kind, e.Prefix, e.Origin, strings.Join(e.Expected, "_"))
+	if e.Covering.IsValid() {
+		s += " covering " + e.Covering.String()
+	}
+	if e.Learned {
+		s += " learned"
+	}
+	if e.End {
+		s += " end " + e.Duration.String()
+	}
+	return s
+}
+
+func run(d *Detector, elems []mrt.Element) []string {
+	var events []string
+	for _, e := range elems {
+		for _, ev := range d.Process("ripe", "rrc00", e) {
+			events = append(events, summary(ev))
+		}
+	}
+	return events
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestMOAS(t *testing.T) {
+	elems := []mrt.Element{
+		announce(1000, "198.51.100.1", "203.0.113.0/24", 64500, 64666),
+		// A second carrier of the conflict starts nothing
+		announce(1010, "198.51.100.2", "203.0.113.0/24", 64501, 64666),
+		announce(1020, "198.51.100.1", "203.0.113.0/24", 64500, 64600),
+		// A second conflicting origin, which ends first
+		announce(1030, "198.51.100.1", "203.0.113.0/24", 64500, 64602, 64601),
+		withdraw(1040, "198.51.100.1", "203.0.113.0/24"),
+		// The last carrier goes back to the known origin
+		announce(1200, "198.51.100.2", "203.0.113.0/24", 64501, 64600),
+	}
+
+	d := New(Options{MOAS: true, ShortLived: time.Hour})
+	d.LoadBaseline(baseline(t), 1)
+	want := []string{
+		"moas 203.0.113.0/24 origin 64666 expected 64600",
+		"moas 203.0.113.0/24 origin 64601 expected 64600",
+		"moas 203.0.113.0/24 origin 64601 expected 64600 end 10s",
+		"moas 203.0.113.0/24 origin 64666 expected 64600 end 3m20s",
+	}
+	if got := run(d, elems); !equal(got, want) {
+		t.Errorf("got events\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// Conflicts lasting longer than ShortLived are not reported when they end
+	d = New(Options{MOAS: true, ShortLived: time.Minute})
+	d.LoadBaseline(baseline(t), 1)
+	want = want[:3]
+	if got := run(d, elems); !equal(got, want) {
+		t.Errorf("got events\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestSubprefix(t *testing.T) {
+	elems := []mrt.Element{
+		announce(1000, "198.51.100.1", "203.0.113.128/25", 64500, 64666),
+		// A more-specific from the known origin is no conflict
+		announce(1010, "198.51.100.1", "203.0.113.0/25", 64500, 64600),
+		// Nor is a new prefix not covered by a known one
+		announce(1020, "198.51.100.1", "198.18.0.0/15", 64500, 64666),
+		down(1100, "198.51.100.1"),
+	}
+
+	d := New(Options{Subprefix: true, ShortLived: time.Hour})
+	d.LoadBaseline(baseline(t), 1)
+	want := []string{
+		"subprefix 203.0.113.128/25 origin 64666 expected 64600 covering 203.0.113.0/24",
+		"subprefix 203.0.113.128/25 origin 64666 expected 64600 covering 203.0.113.0/24 end 1m40s",
+	}
+	if got := run(d, elems); !equal(got, want) {
+		t.Errorf("got events\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// MOAS alone reports none of them
+	d = New(Options{MOAS: true, ShortLived: time.Hour})
+	d.LoadBaseline(baseline(t), 1)
+	if got := run(d, elems); len(got) != 0 {
+		t.Errorf("got events %v", got)
+	}
+}
+
+func TestLearnedOrigins(t *testing.T) {
+	d := New(Options{MOAS: true, Subprefix: true})
+	d.LoadBaseline(baseline(t), 1)
+	var events []Event
+	for _, e := range []mrt.Element{
+		announce(1000, "198.51.100.1", "192.0.2.0/24", 64500, 64601),
+		announce(1010, "198.51.100.2", "192.0.2.0/24", 64501, 64602),
+		announce(1020, "198.51.100.2", "192.0.2.128/25", 64501, 64603),
+		// Conflicts with the baseline are not learned
+		announce(1030, "198.51.100.2", "203.0.113.0/24", 64501, 64604),
+	} {
+		events = append(events, d.Process("ripe", "rrc00", e)...)
+	}
+	var got []string
+	for _, ev := range events {
+		got = append(got, summary(ev))
+	}
+	want := []string{
+		"moas 192.0.2.0/24 origin 64602 expected 64601 learned",
+		"subprefix 192.0.2.128/25 origin 64603 expected 64601 covering 192.0.2.0/24 learned",
+		"moas 203.0.113.0/24 origin 64604 expected 64600",
+	}
+	if !equal(got, want) {
+		t.Errorf("got events\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	if s := events[0].String(); !strings.Contains(s, " expected 64601 (learned) ") {
+		t.Errorf("got %q", s)
+	}
+	data, err := json.Marshal(events[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"learned":true`) {
+		t.Errorf("got %s", data)
+	}
+	if data, _ := json.Marshal(events[2]); strings.Contains(string(data), "learned") {
+		t.Errorf("got %s", data)
+	}
+}
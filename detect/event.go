@@ -0,0 +1,126 @@
+package detect
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// Kind is the kind of a conflict.
+type Kind uint8
+
+const (
+	// MOAS is a known prefix announced by another origin.
+	MOAS Kind = iota + 1
+	// Subprefix is a new more-specific of a prefix of another origin.
+	Subprefix
+)
+
+// String returns "moas" or "subprefix".
+func (k Kind) String() string {
+	switch k {
+	case MOAS:
+		return "moas"
+	case Subprefix:
+		return "subprefix"
+	}
+	return "unknown"
+}
+
+// Event reports the start of a conflict, or the end of a short-lived one.
+// The peer fields describe the announcement that started the conflict.
+type Event struct {
+	Kind Kind
+	Time time.Time
+	// End is set when the conflict ended: no peer carries the origin any
+	// longer. Duration is then how long the conflict lasted.
+	End      bool
+	Duration time.Duration
+	Prefix   netip.Prefix
+	// Origin is the conflicting origin AS, or the members of an AS set
+	// separated by commas.
+	Origin string
+	// Expected are the known origins of Prefix, or of Covering for
+	// subprefix conflicts.
+	Expected []string
+	Covering netip.Prefix
+	// Learned is set when Expected is not from the baseline but the origin
+	// of the first announcement of the prefix in the updates.
+	Learned bool
+
+	Source    string
+	Collector string
+	PeerIP    netip.Addr
+	PeerAS    uint32
+	ASPath    string
+}
+
+// String formats the event as a line of text.
+func (e Event) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s %s origin %s", e.Time.UTC().Format(time.RFC3339), e.Kind, e.Prefix, e.Origin)
+	if e.Covering.IsValid() {
+		fmt.Fprintf(&b, " covering %s", e.Covering)
+	}
+	fmt.Fprintf(&b, " expected %s", strings.Join(e.Expected, "_"))
+	if e.Learned {
+		b.WriteString(" (learned)")
+	}
+	if e.End {
+		fmt.Fprintf(&b, " ended after %s", e.Duration)
+		return b.String()
+	}
+	fmt.Fprintf(&b, " from %s AS%d", e.PeerIP, e.PeerAS)
+	if e.Collector != "" {
+		fmt.Fprintf(&b, " at %s %s", e.Source, e.Collector)
+	}
+	fmt.Fprintf(&b, " path %s", e.ASPath)
+	return b.String()
+}
+
+// eventJSON is the JSON encoding of an Event
+type eventJSON struct {
+	Type      string   `json:"type"`
+	Event     string   `json:"event"`
+	Time      float64  `json:"time"`
+	Duration  float64  `json:"duration,omitempty"`
+	Prefix    string   `json:"prefix"`
+	Origin    string   `json:"origin"`
+	Expected  []string `json:"expected"`
+	Covering  string   `json:"covering,omitempty"`
+	Learned   bool     `json:"learned,omitempty"`
+	Source    string   `json:"source,omitempty"`
+	Collector string   `json:"collector,omitempty"`
+	PeerIP    string   `json:"peer_ip"`
+	PeerAS    uint32   `json:"peer_as"`
+	ASPath    string   `json:"as_path"`
+}
+
+// MarshalJSON encodes the event as a flat JSON object. The event field is
+// "start" or "end"; times and durations are in seconds.
+func (e Event) MarshalJSON() ([]byte, error) {
+	j := eventJSON{
+		Type:      e.Kind.String(),
+		Event:     "start",
+		Time:      float64(e.Time.UnixMicro()) / 1e6,
+		Prefix:    e.Prefix.String(),
+		Origin:    e.Origin,
+		Expected:  e.Expected,
+		Learned:   e.Learned,
+		Source:    e.Source,
+		Collector: e.Collector,
+		PeerIP:    e.PeerIP.String(),
+		PeerAS:    e.PeerAS,
+		ASPath:    e.ASPath,
+	}
+	if e.End {
+		j.Event = "end"
+		j.Duration = e.Duration.Seconds()
+	}
+	if e.Covering.IsValid() {
+		j.Covering = e.Covering.String()
+	}
+	return json.Marshal(j)
+}
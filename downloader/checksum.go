@@ -0,0 +1,225 @@
+package downloader
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ChecksumFile is the name of the per-directory checksum list, in the
+// format of sha256sum(1).
+const ChecksumFile = "SHA256SUMS"
+
+// WriteChecksums adds or replaces the lines of the files recorded in the
+// manifest in the checksum file of each directory in dirs (relative to the
+// output directory, slash separated). Lines of files the manifest does not
+// know, such as files copied in, are left as they are.
+func WriteChecksums(m *Manifest, dirs []string) error {
+	byDir := make(map[string]map[string]string)
+	for _, e := range m.Entries() {
+		dir := path.Dir(e.Path)
+		if byDir[dir] == nil {
+			byDir[dir] = make(map[string]string)
+		}
+		byDir[dir][path.Base(e.Path)] = e.SHA256
+	}
+
+	seen := make(map[string]bool)
+	for _, dir := range dirs {
+		if seen[dir] || len(byDir[dir]) == 0 {
+			continue
+		}
+		seen[dir] = true
+		if err := updateChecksums(m.Abs(dir), byDir[dir], nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateChecksums edits the checksum file of dir: the lines of the names
+// in sums are replaced or added, and those of the names in drop removed.
+// Other lines are left as they are. A checksum file left without lines is
+// removed.
+func updateChecksums(dir string, sums map[string]string, drop map[string]bool) error {
+	sumPath := filepath.Join(dir, ChecksumFile)
+	data, err := os.ReadFile(sumPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	var b strings.Builder
+	changed := false
+	written := make(map[string]bool)
+	for n, line := range strings.SplitAfter(string(data), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		s, ok := parseChecksumLine(line)
+		if !ok {
+			return fmt.Errorf("%s:%d: malformed checksum line", sumPath, n+1)
+		}
+		if sum, ok := sums[s.name]; ok {
+			if sum != s.sum {
+				line, changed = sum+"  "+s.name+"\n", true
+			}
+			written[s.name] = true
+		} else if drop[s.name] {
+			changed = true
+			continue
+		}
+		b.WriteString(line)
+		if !strings.HasSuffix(line, "\n") {
+			b.WriteByte('\n')
+		}
+	}
+	var names []string
+	for name := range sums {
+		if !written[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s  %s\n", sums[name], name)
+		changed = true
+	}
+
+	switch {
+	case !changed:
+		return nil
+	case b.Len() == 0:
+		return os.Remove(sumPath)
+	}
+	if err := writeFileAtomic(sumPath, []byte(b.String())); err != nil {
+		return fmt.Errorf("failed to write %s: %v", sumPath, err)
+	}
+	return nil
+}
+
+// ManifestDirs returns every directory holding files recorded in the
+// manifest.
+func ManifestDirs(m *Manifest) []string {
+	seen := make(map[string]bool)
+	var dirs []string
+	for _, e := range m.Entries() {
+		dir := path.Dir(e.Path)
+		if !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+// ChecksumResult is the outcome of checking a single file.
+type ChecksumResult struct {
+	Path   string // file system path
+	Status VerifyStatus
+	Err    error // set when the file could not be read
+}
+
+// VerifyChecksums checks every file listed in the checksum files below
+// root and records the outcome in the manifest of root, if it knows the
+// file. fn, if not nil, is called for each file checked.
+func VerifyChecksums(root string, fn func(ChecksumResult)) (map[VerifyStatus]int, error) {
+	m, err := OpenManifest(root)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[VerifyStatus]int)
+	err = filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() || fi.Name() != ChecksumFile {
+			return nil
+		}
+		sums, err := readChecksums(p)
+		if err != nil {
+			return err
+		}
+		dir := filepath.Dir(p)
+		for _, s := range sums {
+			res := verifyFile(filepath.Join(dir, s.name), s.sum)
+			counts[res.Status]++
+			if rel, err := m.Rel(res.Path); err == nil {
+				if e, ok := m.Get(rel); ok {
+					e.Status = res.Status
+					e.VerifiedAt = time.Now().UTC()
+					m.Put(e)
+				}
+			}
+			if fn != nil {
+				fn(res)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return counts, err
+	}
+	return counts, m.Save()
+}
+
+// checksumLine is one line of a checksum file
+type checksumLine struct {
+	sum  string
+	name string
+}
+
+// readChecksums parses a checksum file in the format of sha256sum(1)
+func readChecksums(p string) ([]checksumLine, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []checksumLine
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		s, ok := parseChecksumLine(scanner.Text())
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: malformed checksum line", p, n)
+		}
+		lines = append(lines, s)
+	}
+	return lines, scanner.Err()
+}
+
+// parseChecksumLine parses a line of a checksum file
+func parseChecksumLine(line string) (checksumLine, bool) {
+	sum, name, ok := strings.Cut(strings.TrimSpace(line), " ")
+	if !ok || len(sum) != 64 {
+		return checksumLine{}, false
+	}
+	// sha256sum marks binary mode with '*' in front of the name
+	name = strings.TrimPrefix(strings.TrimLeft(name, " "), "*")
+	return checksumLine{sum: strings.ToLower(sum), name: name}, true
+}
+
+// verifyFile hashes the file at p and compares it with sum
+func verifyFile(p, sum string) ChecksumResult {
+	_, actual, err := hashFile(p)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return ChecksumResult{Path: p, Status: StatusMissing}
+	case err != nil:
+		return ChecksumResult{Path: p, Status: StatusMismatch, Err: err}
+	case actual != sum:
+		return ChecksumResult{Path: p, Status: StatusMismatch}
+	}
+	return ChecksumResult{Path: p, Status: StatusOK}
+}
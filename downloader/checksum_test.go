@@ -0,0 +1,51 @@
+package downloader
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func sumOf(c byte) string { return strings.Repeat(string(c), 64) }
+
+func TestUpdateChecksums(t *testing.T) {
+	dir := t.TempDir()
+	sumPath := filepath.Join(dir, ChecksumFile)
+	old := sumOf('a') + "  copied.gz\n" + sumOf('b') + " *old.gz\n" + sumOf('c') + "  gone.gz"
+	if err := os.WriteFile(sumPath, []byte(old), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := updateChecksums(dir,
+		map[string]string{"old.gz": sumOf('d'), "new.gz": sumOf('e')},
+		map[string]bool{"gone.gz": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(sumPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The line of the file the caller does not know is kept as it was
+	want := sumOf('a') + "  copied.gz\n" + sumOf('d') + "  old.gz\n" + sumOf('e') + "  new.gz\n"
+	if string(data) != want {
+		t.Errorf("got\n%s\nwant\n%s", data, want)
+	}
+
+	err = updateChecksums(dir, nil, map[string]bool{"copied.gz": true, "old.gz": true, "new.gz": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(sumPath); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("got %v for an emptied checksum file", err)
+	}
+	// Nothing to drop in a directory without a checksum file
+	if err := updateChecksums(dir, nil, map[string]bool{"x.gz": true}); err != nil {
+		t.Error(err)
+	}
+	if _, err := os.Stat(sumPath); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("got %v, want no checksum file", err)
+	}
+}
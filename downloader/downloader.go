@@ -2,38 +2,149 @@ package downloader
 
 import (
 	"fmt"
+	"log/slog"
 	"os"
+	"path"
+	"path/filepath"
+	"sync"
 	"time"
 )
 
+// Options holds the parameters of a download run
+type Options struct {
+	Source      string
+	Collector   string
+	Types       []DumpType
+	StartDate   string
+	EndDate     string
+	OutputDir   string
+	Concurrency int
+	// Layout decides where files are stored below OutputDir.
+	// The zero value selects DefaultLayout.
+	Layout Layout
+	// Logger receives progress and error events.
+	// A nil Logger selects slog.Default().
+	Logger *slog.Logger
+	// Progress, if set, is updated as files are listed and downloaded.
+	// The caller starts and stops it.
+	Progress *Progress
+	// Metrics, if set, is updated with download statistics.
+	Metrics *Metrics
+}
+
 // DownloadBGPData is the main function to download BGP data
 // It determines the source and calls the appropriate downloader
 func DownloadBGPData(source, collector, dataType, startDate, endDate, outputDir string, maxConcurrency int) error {
-	// For now, we only support RIPE
-	// In the future, we can add support for RouteViews here
-	if source == "ripe" {
-		return downloadRipeData(collector, dataType, startDate, endDate, outputDir, maxConcurrency)
+	types, err := ParseDumpTypes(dataType)
+	if err != nil {
+		return err
+	}
+	return Download(Options{
+		Source:      source,
+		Collector:   collector,
+		Types:       types,
+		StartDate:   startDate,
+		EndDate:     endDate,
+		OutputDir:   outputDir,
+		Concurrency: maxConcurrency,
+	})
+}
+
+// Download downloads BGP data as described by opts
+func Download(opts Options) error {
+	if len(opts.Types) == 0 {
+		opts.Types = []DumpType{DumpRIB}
+	}
+	s := newSession(opts)
+
+	if opts.Source == "ripe" {
+		return s.downloadRipeData()
+	}
+	if opts.Source == "routeviews" {
+		return s.downloadRouteViewsData()
+	}
+	return fmt.Errorf("invalid source: %s", opts.Source)
+}
+
+// session carries the state shared by all downloads of a single run
+type session struct {
+	Options
+	log        *slog.Logger
+	manifest   *Manifest
+	stopSaving func()
+
+	mu     sync.Mutex
+	recent map[string]map[string]string // checksums of the files recorded, by directory and name
+}
+
+func newSession(opts Options) *session {
+	if opts.Layout.IsZero() {
+		opts.Layout = DefaultLayout
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
 	}
-	if source == "routeviews" {
-		return downloadRouteViewsData(collector, dataType, startDate, endDate, outputDir, maxConcurrency)
+	return &session{
+		Options: opts,
+		log:     opts.Logger.With("source", opts.Source),
+		recent:  make(map[string]map[string]string),
 	}
-	return fmt.Errorf("invalid source: %s", source)
+}
+
+// record adds a file to the manifest and remembers its checksum for the
+// checksum file of its directory
+func (s *session) record(e ManifestEntry) {
+	s.manifest.Put(e)
+	dir := path.Dir(e.Path)
+	s.mu.Lock()
+	if s.recent[dir] == nil {
+		s.recent[dir] = make(map[string]string)
+	}
+	s.recent[dir][path.Base(e.Path)] = e.SHA256
+	s.mu.Unlock()
+}
+
+// writeChecksums adds or replaces the lines of the files recorded since
+// the last call in the checksum files of their directories
+func (s *session) writeChecksums() error {
+	s.mu.Lock()
+	recent := s.recent
+	s.recent = make(map[string]map[string]string)
+	s.mu.Unlock()
+	for dir, sums := range recent {
+		if err := updateChecksums(s.manifest.Abs(dir), sums, nil); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // downloadRipeData is the internal implementation for downloading RIPE data
-func downloadRipeData(collector, dataType, startDate, endDate, outputDir string, maxConcurrency int) error {
+func (s *session) downloadRipeData() error {
+	return s.downloadRange("ripe", s.listRipeDay)
+}
+
+func (s *session) downloadRouteViewsData() error {
+	return s.downloadRange("routeviews", s.listRouteViewsDay)
+}
+
+// downloadRange validates the options, lists every day of the requested
+// range and downloads the files listed, with at most Concurrency days in
+// flight. Listing comes first so that the progress display knows the total
+// from the start.
+func (s *session) downloadRange(source string, list func(date time.Time) ([]RemoteFile, error)) error {
 	// Validate collector
-	if !isValidCollector("ripe", collector) {
-		return fmt.Errorf("invalid collector: %s", collector)
+	if !isValidCollector(source, s.Collector) {
+		return fmt.Errorf("invalid collector: %s", s.Collector)
 	}
 
 	// Parse dates
-	start, err := time.Parse("2006-01-02", startDate)
+	start, err := time.Parse("2006-01-02", s.StartDate)
 	if err != nil {
 		return fmt.Errorf("invalid start date: %v", err)
 	}
 
-	end, err := time.Parse("2006-01-02", endDate)
+	end, err := time.Parse("2006-01-02", s.EndDate)
 	if err != nil {
 		return fmt.Errorf("invalid end date: %v", err)
 	}
@@ -43,12 +154,37 @@ func downloadRipeData(collector, dataType, startDate, endDate, outputDir string,
 		return fmt.Errorf("start date cannot be after end date")
 	}
 
-	// Create output directory if it doesn't exist
-	if err := createOutputDir(outputDir); err != nil {
+	if err := s.begin(); err != nil {
+		return err
+	}
+	defer s.end()
+
+	var mu sync.Mutex
+	byDay := make(map[time.Time][]RemoteFile)
+	err = s.forEachDay(start, end, func(date time.Time) error {
+		files, err := list(date)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		byDay[date] = files
+		mu.Unlock()
+		s.Progress.addFiles(len(files))
+		return nil
+	})
+	if err != nil {
 		return err
 	}
+	return s.forEachDay(start, end, func(date time.Time) error {
+		return s.fetchFiles(byDay[date])
+	})
+}
 
+// forEachDay runs fn for each day from start to end, with at most
+// Concurrency days in flight, and returns the first error
+func (s *session) forEachDay(start, end time.Time, fn func(date time.Time) error) error {
 	// Use default concurrency if not specified or invalid
+	maxConcurrency := s.Concurrency
 	if maxConcurrency <= 0 {
 		maxConcurrency = 10
 	}
@@ -62,174 +198,251 @@ func downloadRipeData(collector, dataType, startDate, endDate, outputDir string,
 	// Use a done channel to signal when all goroutines are finished
 	done := make(chan struct{})
 
-	// Counter for active goroutines
-	var activeGoroutines int
+	// Wait group tracking active goroutines
+	var wg sync.WaitGroup
 
-	// Download data for each day in the range
 	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
-		activeGoroutines++
+		wg.Add(1)
 		go func(date time.Time) {
+			defer wg.Done()
+
 			// Acquire semaphore
 			semaphore <- struct{}{}
 
 			// Release semaphore when done
 			defer func() { <-semaphore }()
 
-			// Perform the download
-			if err := downloadDailyRipeData(collector, dataType, date, outputDir); err != nil {
+			if err := fn(date); err != nil {
 				// Send error to channel, but only if no error has been sent yet
 				select {
 				case errChan <- err:
 				default:
 				}
 			}
-
-			// Decrement active goroutines counter
-			activeGoroutines--
-
-			// If no more active goroutines, signal done
-			if activeGoroutines == 0 {
-				done <- struct{}{}
-			}
 		}(d)
 	}
 
-	// Wait for either all downloads to complete or an error to occur
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	// Wait for either all days to complete or an error to occur
 	select {
 	case <-done:
-		return nil
+		// An error may have been sent just before the last goroutine finished
+		select {
+		case err := <-errChan:
+			return err
+		default:
+			return nil
+		}
 	case err := <-errChan:
 		return err
 	}
 }
 
-func downloadRouteViewsData(collector, dataType, startDate, endDate, outputDir string, maxConcurrency int) error {
-	// Validate collector
-	if !isValidCollector("routeviews", collector) {
-		return fmt.Errorf("invalid collector: %s", collector)
+// begin prepares the output directory and loads the manifest
+func (s *session) begin() error {
+	// Create output directory if it doesn't exist
+	if err := createOutputDir(s.OutputDir); err != nil {
+		return err
 	}
 
-	// Parse dates
-	start, err := time.Parse("2006-01-02", startDate)
+	// Load the manifest and keep it saved while the run progresses
+	var err error
+	s.manifest, err = OpenManifest(s.OutputDir)
 	if err != nil {
-		return fmt.Errorf("invalid start date: %v", err)
+		return err
 	}
+	s.stopSaving = s.saveManifestPeriodically(time.Minute)
+	return nil
+}
 
-	end, err := time.Parse("2006-01-02", endDate)
-	if err != nil {
-		return fmt.Errorf("invalid end date: %v", err)
+// end writes the checksum files and the manifest
+func (s *session) end() {
+	s.stopSaving()
+	if err := s.writeChecksums(); err != nil {
+		s.log.Error("failed to write checksums", "error", err)
 	}
-
-	// Validate date range
-	if start.After(end) {
-		return fmt.Errorf("start date cannot be after end date")
+	if err := s.manifest.Save(); err != nil {
+		s.log.Error("failed to save manifest", "error", err)
 	}
+}
 
-	// Create output directory if it doesn't exist
-	if err := createOutputDir(outputDir); err != nil {
-		return err
+// saveManifestPeriodically saves the manifest every interval until the
+// returned function is called
+func (s *session) saveManifestPeriodically(interval time.Duration) func() {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := s.manifest.Save(); err != nil {
+					s.log.Error("failed to save manifest", "error", err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
 	}
+}
 
-	// Use default concurrency if not specified or invalid
-	if maxConcurrency <= 0 {
-		maxConcurrency = 10
-	}
+// collectors lists the collectors of each source
+var collectors = map[string][]string{
+	"ripe": {
+		"rrc00", "rrc01", "rrc02", "rrc03", "rrc04", "rrc05", "rrc06", "rrc07", "rrc08", "rrc09",
+		"rrc10", "rrc11", "rrc12", "rrc13", "rrc14", "rrc15", "rrc16", "rrc17", "rrc18", "rrc19",
+		"rrc20", "rrc21", "rrc22", "rrc23", "rrc24", "rrc25", "rrc26",
+	},
+	"routeviews": {
+		"chicago", "isc", "eqix", "rv", "rv2", "rv3", "rv4", "rv6",
+		"linx", "napafrica", "sg", "sydney", "saopaulo", "ams",
+	},
+}
 
-	// Create a channel to control concurrency
-	semaphore := make(chan struct{}, maxConcurrency)
+// Collectors returns the collectors of a source.
+func Collectors(source string) []string {
+	return append([]string(nil), collectors[source]...)
+}
 
-	// Create a channel to collect errors from goroutines
-	errChan := make(chan error, 1)
+// isValidCollector checks if the collector belongs to the source
+func isValidCollector(source string, collector string) bool {
+	for _, c := range collectors[source] {
+		if c == collector {
+			return true
+		}
+	}
+	return false
+}
 
-	// Use a done channel to signal when all goroutines are finished
-	done := make(chan struct{})
+// createOutputDir creates the output directory if it doesn't exist
+func createOutputDir(outputDir string) error {
+	return os.MkdirAll(outputDir, 0755)
+}
 
-	// Counter for active goroutines
-	var activeGoroutines int
+// RemoteFile is a file of an upstream archive.
+type RemoteFile struct {
+	FileInfo
+	URL string
+}
 
-	// Download data for each day in the range
-	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
-		activeGoroutines++
-		go func(date time.Time) {
-			// Acquire semaphore
-			semaphore <- struct{}{}
+// ListFiles returns the files of the given types that the archive of the
+// collector holds for the given day.
+func ListFiles(source, collector string, types []DumpType, day time.Time) ([]RemoteFile, error) {
+	if !isValidCollector(source, collector) {
+		return nil, fmt.Errorf("invalid collector: %s", collector)
+	}
+	return newSession(Options{Source: source, Collector: collector, Types: types}).listDay(day)
+}
 
-			// Release semaphore when done
-			defer func() { <-semaphore }()
+// listDay returns the files of the configured types that the archive of
+// the collector holds for the given day
+func (s *session) listDay(day time.Time) ([]RemoteFile, error) {
+	switch s.Source {
+	case "ripe":
+		return s.listRipeDay(day)
+	case "routeviews":
+		return s.listRouteViewsDay(day)
+	}
+	return nil, fmt.Errorf("invalid source: %s", s.Source)
+}
 
-			// Perform the download
-			if err := downloadDailyRouteViewsData(collector, dataType, date, outputDir); err != nil {
-				// Send error to channel, but only if no error has been sent yet
-				select {
-				case errChan <- err:
-				default:
-				}
-			}
+// DownloadFiles downloads the given files into opts.OutputDir, using the
+// layout, logging, progress and metrics settings of opts. The collector,
+// type and date options are ignored.
+func DownloadFiles(opts Options, files []RemoteFile) error {
+	s := newSession(opts)
+	if err := s.begin(); err != nil {
+		return err
+	}
+	defer s.end()
 
-			// Decrement active goroutines counter
-			activeGoroutines--
+	maxConcurrency := s.Concurrency
+	if maxConcurrency <= 0 {
+		maxConcurrency = 10
+	}
+	s.Progress.addFiles(len(files))
 
-			// If no more active goroutines, signal done
-			if activeGoroutines == 0 {
-				done <- struct{}{}
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	semaphore := make(chan struct{}, maxConcurrency)
+	for _, f := range files {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(f RemoteFile) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			if err := s.fetchFile(f); err != nil {
+				errOnce.Do(func() { firstErr = err })
 			}
-		}(d)
+		}(f)
 	}
+	wg.Wait()
+	return firstErr
+}
 
-	// Wait for either all downloads to complete or an error to occur
-	select {
-	case <-done:
-		return nil
-	case err := <-errChan:
-		return err
+// DownloadLocal downloads files of any source with DownloadFiles, one
+// source after the other, and returns where each file was stored, in the
+// order of the sources' first files. opts.Source is ignored.
+func DownloadLocal(opts Options, files []RemoteFile) ([]LocalFile, error) {
+	if opts.Layout.IsZero() {
+		opts.Layout = DefaultLayout
+	}
+	bySource := make(map[string][]RemoteFile)
+	var sources []string
+	for _, f := range files {
+		if _, ok := bySource[f.Source]; !ok {
+			sources = append(sources, f.Source)
+		}
+		bySource[f.Source] = append(bySource[f.Source], f)
 	}
-}
 
-// isValidCollector checks if the collector is valid for RIPE
-func isValidCollector(source string, collector string) bool {
-	switch source {
-	case "ripe":
-		validCollectors := map[string]bool{
-			"rrc00": true, "rrc01": true, "rrc02": true, "rrc03": true, "rrc04": true,
-			"rrc05": true, "rrc06": true, "rrc07": true, "rrc08": true, "rrc09": true,
-			"rrc10": true, "rrc11": true, "rrc12": true, "rrc13": true, "rrc14": true,
-			"rrc15": true, "rrc16": true, "rrc17": true, "rrc18": true, "rrc19": true,
-			"rrc20": true, "rrc21": true, "rrc22": true, "rrc23": true, "rrc24": true,
-			"rrc25": true, "rrc26": true,
+	var local []LocalFile
+	for _, src := range sources {
+		opts.Source = src
+		if err := DownloadFiles(opts, bySource[src]); err != nil {
+			return nil, err
 		}
-		return validCollectors[collector]
-	case "routeviews":
-		validCollectors := map[string]bool{
-			"chicago":   true,
-			"isc":       true,
-			"eqix":      true,
-			"rv":        true,
-			"rv2":       true,
-			"rv3":       true,
-			"rv4":       true,
-			"rv6":       true,
-			"linx":      true,
-			"napafrica": true,
-			"sg":        true,
-			"sydney":    true,
-			"saopaulo":  true,
-			"ams":       true,
+		for _, f := range bySource[src] {
+			local = append(local, LocalFile{FileInfo: f.FileInfo, Path: filepath.Join(opts.OutputDir, opts.Layout.Path(f.FileInfo))})
 		}
-		return validCollectors[collector]
 	}
-	return false
+	return local, nil
 }
 
-// createOutputDir creates the output directory if it doesn't exist
-func createOutputDir(outputDir string) error {
-	return os.MkdirAll(outputDir, 0755)
+// fetchFiles downloads files one after the other
+func (s *session) fetchFiles(files []RemoteFile) error {
+	for _, f := range files {
+		if err := s.fetchFile(f); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// downloadDailyRipeData downloads data for a specific day from RIPE
-func downloadDailyRipeData(collector, dataType string, date time.Time, outputDir string) error {
-	return downloadDailyData(collector, dataType, date, outputDir)
-}
+// fetchFile downloads a single file to the place given by the layout
+func (s *session) fetchFile(f RemoteFile) error {
+	outputPath := filepath.Join(s.OutputDir, s.Layout.Path(f.FileInfo))
+
+	// Create the subdirectory if it doesn't exist
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+		return fmt.Errorf("failed to create subdirectory: %v", err)
+	}
 
-func downloadDailyRouteViewsData(collector, dataType string, date time.Time, outputDir string) error {
-	return downloadDailyRVData(collector, dataType, date, outputDir)
+	if err := s.downloadFile(f.FileInfo, f.URL, outputPath); err != nil {
+		return fmt.Errorf("failed to download %s: %v", f.Name, err)
+	}
+	return nil
 }
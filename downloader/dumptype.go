@@ -0,0 +1,103 @@
+package downloader
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DumpType is the kind of MRT dump, independent of the naming used by a
+// particular source.
+type DumpType int
+
+const (
+	DumpUnknown DumpType = iota
+	// DumpRIB is a full table snapshot (RIPE "bview", RouteViews "rib").
+	DumpRIB
+	// DumpUpdates is a file of BGP update messages.
+	DumpUpdates
+)
+
+// AllDumpTypes lists every known dump type.
+var AllDumpTypes = []DumpType{DumpRIB, DumpUpdates}
+
+// dumpTypeAliases maps every accepted spelling to its dump type.
+var dumpTypeAliases = map[string]DumpType{
+	"rib":     DumpRIB,
+	"ribs":    DumpRIB,
+	"bview":   DumpRIB,
+	"bviews":  DumpRIB,
+	"update":  DumpUpdates,
+	"updates": DumpUpdates,
+}
+
+// ParseDumpType parses a single dump type. RIPE and RouteViews spellings
+// are accepted for every source.
+func ParseDumpType(s string) (DumpType, error) {
+	if t, ok := dumpTypeAliases[strings.ToLower(strings.TrimSpace(s))]; ok {
+		return t, nil
+	}
+	return DumpUnknown, fmt.Errorf("invalid data type: %s", s)
+}
+
+// ParseDumpTypes parses a comma separated list of dump types, where "all"
+// selects every type.
+func ParseDumpTypes(s string) ([]DumpType, error) {
+	if strings.ToLower(strings.TrimSpace(s)) == "all" {
+		return AllDumpTypes, nil
+	}
+	var types []DumpType
+	for _, part := range strings.Split(s, ",") {
+		t, err := ParseDumpType(part)
+		if err != nil {
+			return nil, err
+		}
+		if !hasDumpType(types, t) {
+			types = append(types, t)
+		}
+	}
+	return types, nil
+}
+
+// DumpTypeOf classifies an archive file by its name.
+func DumpTypeOf(fileName string) DumpType {
+	prefix, _, _ := strings.Cut(fileName, ".")
+	if t, ok := dumpTypeAliases[prefix]; ok {
+		return t
+	}
+	return DumpUnknown
+}
+
+func hasDumpType(types []DumpType, t DumpType) bool {
+	for _, v := range types {
+		if v == t {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the canonical name of the dump type.
+func (t DumpType) String() string {
+	switch t {
+	case DumpRIB:
+		return "rib"
+	case DumpUpdates:
+		return "updates"
+	}
+	return "unknown"
+}
+
+// FilePrefix returns the name the source uses for the dump type in its file
+// names.
+func (t DumpType) FilePrefix(source string) string {
+	switch t {
+	case DumpRIB:
+		if source == "ripe" {
+			return "bview"
+		}
+		return "rib"
+	case DumpUpdates:
+		return "updates"
+	}
+	return ""
+}
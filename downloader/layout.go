@@ -0,0 +1,548 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FileInfo describes a single archive file independently of where it is
+// stored on disk.
+type FileInfo struct {
+	Source    string    // ripe or routeviews
+	Collector string    // collector name as used on the command line (rrc00, rv2, ...)
+	Type      string    // type token from the file name (bview, rib, updates)
+	DumpType  DumpType  // normalized dump type
+	Time      time.Time // dump time encoded in the file name
+	Name      string    // original file name in the upstream archive
+}
+
+// fileNameRe matches archive file names such as bview.20140301.0000.gz or
+// rib.20140301.0000.bz2.
+var fileNameRe = regexp.MustCompile(`^(bview|rib|updates)\.(\d{8})\.(\d{4})\.(gz|bz2)$`)
+
+// ParseFileName extracts the dump type and time from an archive file name.
+func ParseFileName(source, collector, name string) (FileInfo, error) {
+	m := fileNameRe.FindStringSubmatch(name)
+	if m == nil {
+		return FileInfo{}, fmt.Errorf("unrecognized file name: %s", name)
+	}
+	t, err := time.Parse("20060102 1504", m[2]+" "+m[3])
+	if err != nil {
+		return FileInfo{}, fmt.Errorf("invalid time in file name %s: %v", name, err)
+	}
+	return FileInfo{
+		Source:    source,
+		Collector: collector,
+		Type:      m[1],
+		DumpType:  DumpTypeOf(name),
+		Time:      t,
+		Name:      name,
+	}, nil
+}
+
+// Ext returns the compression extension of the file (gz or bz2).
+func (f FileInfo) Ext() string {
+	return strings.TrimPrefix(filepath.Ext(f.Name), ".")
+}
+
+// typeDir returns the per-type directory name used by the default layout.
+func (f FileInfo) typeDir() string {
+	switch f.DumpType {
+	case DumpRIB:
+		if f.Source == "ripe" {
+			return "bview"
+		}
+		return "ribs"
+	case DumpUpdates:
+		return "updates"
+	}
+	return "unknown"
+}
+
+// archiveType returns the per-type directory name used by the upstream
+// archive, which only RouteViews has.
+func (f FileInfo) archiveType() string {
+	if f.Source != "routeviews" {
+		return ""
+	}
+	switch f.DumpType {
+	case DumpRIB:
+		return "RIBS"
+	case DumpUpdates:
+		return "UPDATES"
+	}
+	return ""
+}
+
+// archivePath returns the collector's directory in the upstream archive.
+func (f FileInfo) archivePath() string {
+	if f.Source == "routeviews" {
+		if p, ok := routeviewsMap[f.Collector]; ok {
+			return p
+		}
+	}
+	return f.Collector
+}
+
+// Layout is a path template describing where downloaded files are stored
+// relative to the output directory.
+//
+// Templates are made of literal text and the following placeholders:
+//
+//	{source}      ripe or routeviews
+//	{collector}   collector name (rrc00, rv2, ...)
+//	{archive}     collector directory in the upstream archive (rrc00, route-views.sg/bgpdata, ...)
+//	{type}        type token from the file name (bview, rib, updates)
+//	{typedir}     per-type directory of the default layout (bview, ribs, updates)
+//	{archivetype} per-type directory of the upstream archive (RIBS, UPDATES, empty for RIPE)
+//	{yyyy} {mm} {dd} {hh} {hhmm} {yyyymmdd}
+//	{ext}         gz or bz2
+//	{file}        original file name
+type Layout struct {
+	name string
+	tmpl string
+	re   *regexp.Regexp
+	vars []string
+}
+
+// Built-in layout presets.
+var (
+	// DefaultLayout is the layout used by earlier versions of the tool.
+	DefaultLayout = mustLayout("default", "{source}/{typedir}/{collector}/{yyyy}.{mm}/{file}")
+	// RISMirrorLayout mirrors the directory structure of data.ris.ripe.net.
+	RISMirrorLayout = mustLayout("ris-mirror", "{archive}/{yyyy}.{mm}/{file}")
+	// RouteViewsMirrorLayout mirrors the directory structure of archive.routeviews.org.
+	RouteViewsMirrorLayout = mustLayout("routeviews-mirror", "{archive}/{yyyy}.{mm}/{archivetype}/{file}")
+	// FlatLayout stores every file in a single directory.
+	FlatLayout = mustLayout("flat", "{source}.{collector}.{file}")
+)
+
+var layoutPresets = map[string]Layout{
+	DefaultLayout.name:          DefaultLayout,
+	RISMirrorLayout.name:        RISMirrorLayout,
+	RouteViewsMirrorLayout.name: RouteViewsMirrorLayout,
+	FlatLayout.name:             FlatLayout,
+}
+
+// LayoutPresets returns the names of the built-in layouts.
+func LayoutPresets() []string {
+	var names []string
+	for name := range layoutPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// placeholderRe matches a single template placeholder.
+var placeholderRe = regexp.MustCompile(`\{([a-z]+)\}`)
+
+// placeholderPatterns holds the regular expression used to match each
+// placeholder when mapping an existing path back to a FileInfo.
+var placeholderPatterns = map[string]string{
+	"source":      `ripe|routeviews`,
+	"collector":   `[A-Za-z0-9_-]+`,
+	"archive":     "", // filled in by archivePattern
+	"type":        `bview|rib|updates`,
+	"typedir":     `bview|ribs|updates|unknown`,
+	"archivetype": `RIBS|UPDATES`,
+	"yyyy":        `\d{4}`,
+	"mm":          `\d{2}`,
+	"dd":          `\d{2}`,
+	"hh":          `\d{2}`,
+	"hhmm":        `\d{4}`,
+	"yyyymmdd":    `\d{8}`,
+	"ext":         `gz|bz2`,
+	"file":        `[^/]+`,
+}
+
+// archivePattern matches any upstream collector directory.
+func archivePattern() string {
+	alts := []string{`rrc\d{2}`}
+	for _, p := range routeviewsMap {
+		alts = append(alts, regexp.QuoteMeta(p))
+	}
+	// Longest first so that route-views2.saopaulo/bgpdata wins over route-views2.
+	sort.Slice(alts, func(i, j int) bool { return len(alts[i]) > len(alts[j]) })
+	return strings.Join(alts, "|")
+}
+
+// ParseLayout returns the preset with the given name, or compiles s as a
+// layout template.
+func ParseLayout(s string) (Layout, error) {
+	if l, ok := layoutPresets[s]; ok {
+		return l, nil
+	}
+	return newLayout("", s)
+}
+
+func mustLayout(name, tmpl string) Layout {
+	l, err := newLayout(name, tmpl)
+	if err != nil {
+		panic(err)
+	}
+	return l
+}
+
+func newLayout(name, tmpl string) (Layout, error) {
+	if tmpl == "" {
+		return Layout{}, fmt.Errorf("empty layout template")
+	}
+	if filepath.IsAbs(tmpl) || strings.HasPrefix(tmpl, "..") {
+		return Layout{}, fmt.Errorf("layout must be relative to the output directory: %s", tmpl)
+	}
+
+	var (
+		pattern strings.Builder
+		vars    []string
+		last    int
+	)
+	pattern.WriteString("^")
+	for _, loc := range placeholderRe.FindAllStringSubmatchIndex(tmpl, -1) {
+		pattern.WriteString(regexp.QuoteMeta(tmpl[last:loc[0]]))
+		last = loc[1]
+
+		v := tmpl[loc[2]:loc[3]]
+		p, ok := placeholderPatterns[v]
+		if !ok {
+			return Layout{}, fmt.Errorf("unknown placeholder {%s} in layout %s", v, tmpl)
+		}
+		if v == "archive" {
+			p = archivePattern()
+		}
+		vars = append(vars, v)
+
+		// {archivetype} is empty for RIPE, in which case the path separator
+		// following it disappears too.
+		if v == "archivetype" && strings.HasPrefix(tmpl[last:], "/") {
+			pattern.WriteString("(?:(" + p + ")/)?")
+			last++
+			continue
+		}
+		pattern.WriteString("(" + p + ")")
+	}
+	if strings.Contains(tmpl[last:], "{") {
+		return Layout{}, fmt.Errorf("malformed placeholder in layout %s", tmpl)
+	}
+	pattern.WriteString(regexp.QuoteMeta(tmpl[last:]))
+	pattern.WriteString("$")
+
+	// Every dump needs a path of its own, or downloads would skip the
+	// files that map to the path of an earlier one, and Match must be able
+	// to recover the collector and the file name from it
+	if !containsAny(vars, "collector", "archive") {
+		return Layout{}, fmt.Errorf("layout %s does not identify the collector; include {collector} or {archive}", tmpl)
+	}
+	if !containsAny(vars, "file") &&
+		!(containsAll(vars, "type", "hhmm", "ext") &&
+			(containsAny(vars, "yyyymmdd") || containsAll(vars, "yyyy", "mm", "dd"))) {
+		return Layout{}, fmt.Errorf("layout %s does not identify individual dumps; include {file}, or {type}, the date, {hhmm} and {ext}", tmpl)
+	}
+
+	re, err := regexp.Compile(pattern.String())
+	if err != nil {
+		return Layout{}, fmt.Errorf("invalid layout %s: %v", tmpl, err)
+	}
+	return Layout{name: name, tmpl: tmpl, re: re, vars: vars}, nil
+}
+
+func containsAny(list []string, values ...string) bool {
+	for _, s := range list {
+		for _, v := range values {
+			if s == v {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func containsAll(list []string, values ...string) bool {
+	for _, v := range values {
+		if !containsAny(list, v) {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns the preset name, or the template for custom layouts.
+func (l Layout) String() string {
+	if l.name != "" {
+		return l.name
+	}
+	return l.tmpl
+}
+
+// Template returns the layout template.
+func (l Layout) Template() string {
+	return l.tmpl
+}
+
+// IsZero reports whether l is the zero Layout.
+func (l Layout) IsZero() bool {
+	return l.re == nil
+}
+
+// Path returns the path of the file relative to the output directory.
+func (l Layout) Path(f FileInfo) string {
+	p := placeholderRe.ReplaceAllStringFunc(l.tmpl, func(ph string) string {
+		return f.value(ph[1 : len(ph)-1])
+	})
+	return filepath.Clean(filepath.FromSlash(p))
+}
+
+func (f FileInfo) value(v string) string {
+	switch v {
+	case "source":
+		return f.Source
+	case "collector":
+		return f.Collector
+	case "archive":
+		return f.archivePath()
+	case "type":
+		return f.Type
+	case "typedir":
+		return f.typeDir()
+	case "archivetype":
+		return f.archiveType()
+	case "yyyy":
+		return f.Time.Format("2006")
+	case "mm":
+		return f.Time.Format("01")
+	case "dd":
+		return f.Time.Format("02")
+	case "hh":
+		return f.Time.Format("15")
+	case "hhmm":
+		return f.Time.Format("1504")
+	case "yyyymmdd":
+		return f.Time.Format("20060102")
+	case "ext":
+		return f.Ext()
+	case "file":
+		return f.Name
+	}
+	return ""
+}
+
+// Match maps a path relative to the output directory back to the file it
+// holds. source is used when the layout does not encode the source.
+func (l Layout) Match(rel, source string) (FileInfo, bool) {
+	m := l.re.FindStringSubmatch(filepath.ToSlash(rel))
+	if m == nil {
+		return FileInfo{}, false
+	}
+	values := make(map[string]string)
+	for i, v := range l.vars {
+		if prev, ok := values[v]; ok && prev != m[i+1] {
+			return FileInfo{}, false
+		}
+		values[v] = m[i+1]
+	}
+
+	if s, ok := values["source"]; ok {
+		source = s
+	}
+	collector := values["collector"]
+	if a, ok := values["archive"]; ok {
+		if strings.HasPrefix(a, "rrc") {
+			source = "ripe"
+		} else {
+			source = "routeviews"
+		}
+		if collector == "" {
+			collector = collectorForArchive(a)
+		}
+	}
+	if source == "" || collector == "" {
+		return FileInfo{}, false
+	}
+
+	name := values["file"]
+	if name == "" {
+		day := values["yyyymmdd"]
+		if day == "" {
+			day = values["yyyy"] + values["mm"] + values["dd"]
+		}
+		hhmm := values["hhmm"]
+		if hhmm == "" && values["hh"] != "" {
+			hhmm = values["hh"] + "00"
+		}
+		name = fmt.Sprintf("%s.%s.%s.%s", values["type"], day, hhmm, values["ext"])
+	}
+
+	info, err := ParseFileName(source, collector, name)
+	if err != nil {
+		return FileInfo{}, false
+	}
+	return info, true
+}
+
+// collectorForArchive maps an upstream collector directory back to the
+// collector name.
+func collectorForArchive(archive string) string {
+	for name, p := range routeviewsMap {
+		if p == archive {
+			return name
+		}
+	}
+	return archive
+}
+
+// Move is a single file rename planned by PlanRelayout.
+type Move struct {
+	From string
+	To   string
+}
+
+// WalkLayout calls fn for every file below root stored according to l.
+// source is used for layouts that do not encode the source.
+func WalkLayout(root string, l Layout, source string, fn func(path string, info FileInfo) error) error {
+	return filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		info, ok := l.Match(rel, source)
+		if !ok {
+			return nil
+		}
+		return fn(path, info)
+	})
+}
+
+// PlanRelayout walks root and returns the renames needed to move every file
+// stored according to from into the location given by to. source is used
+// for layouts that do not encode the source. Files that do not match from
+// are left alone.
+func PlanRelayout(root string, from, to Layout, source string) ([]Move, error) {
+	var moves []Move
+	err := WalkLayout(root, from, source, func(path string, info FileInfo) error {
+		dest := filepath.Join(root, to.Path(info))
+		if dest != path {
+			moves = append(moves, Move{From: path, To: dest})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return moves, nil
+}
+
+// ApplyRelayout performs the renames returned by PlanRelayout, updates the
+// manifest and checksum files and removes directories left empty below
+// root.
+func ApplyRelayout(root string, moves []Move) error {
+	manifest, err := OpenManifest(root)
+	if err != nil {
+		return err
+	}
+	defer manifest.Save()
+
+	// Check every target first so that a conflict leaves the tree as it was
+	targets := make(map[string]bool, len(moves))
+	for _, m := range moves {
+		if _, err := os.Stat(m.To); err == nil || targets[m.To] {
+			return fmt.Errorf("refusing to overwrite %s", m.To)
+		}
+		targets[m.To] = true
+	}
+
+	// The checksum lines of the moved files follow them, whether or not
+	// the manifest knows the files
+	listed := make(map[string]map[string]string) // checksum files read, by directory
+	sums := make(map[string]map[string]string)   // lines to add, by directory
+	drop := make(map[string]map[string]bool)     // lines to remove, by directory
+	sumDirs := make(map[string]bool)
+	dirs := make(map[string]bool)
+	var moveErr error
+	for _, m := range moves {
+		fromDir, toDir := filepath.Dir(m.From), filepath.Dir(m.To)
+		if listed[fromDir] == nil {
+			listed[fromDir] = make(map[string]string)
+			lines, err := readChecksums(filepath.Join(fromDir, ChecksumFile))
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				moveErr = err
+				break
+			}
+			for _, l := range lines {
+				listed[fromDir][l.name] = l.sum
+			}
+		}
+
+		if err := os.MkdirAll(toDir, 0755); err != nil {
+			moveErr = fmt.Errorf("failed to create directory: %v", err)
+			break
+		}
+		if err := os.Rename(m.From, m.To); err != nil {
+			moveErr = err
+			break
+		}
+		from, _ := manifest.Rel(m.From)
+		to, _ := manifest.Rel(m.To)
+		sum, ok := listed[fromDir][filepath.Base(m.From)]
+		if e, known := manifest.Get(from); !ok && known {
+			sum, ok = e.SHA256, true
+		}
+		manifest.Rename(from, to)
+		dirs[fromDir] = true
+
+		if drop[fromDir] == nil {
+			drop[fromDir] = make(map[string]bool)
+		}
+		drop[fromDir][filepath.Base(m.From)] = true
+		if ok {
+			if sums[toDir] == nil {
+				sums[toDir] = make(map[string]string)
+			}
+			sums[toDir][filepath.Base(m.To)] = sum
+			sumDirs[toDir] = true
+		}
+	}
+	// Files moved before a failure keep their checksums too
+	for dir := range drop {
+		sumDirs[dir] = true
+	}
+	for dir := range sumDirs {
+		if err := updateChecksums(dir, sums[dir], drop[dir]); err != nil && moveErr == nil {
+			moveErr = err
+		}
+	}
+	if moveErr != nil {
+		return moveErr
+	}
+
+	for dir := range dirs {
+		removeEmptyDirs(root, dir)
+	}
+	return manifest.Save()
+}
+
+// removeEmptyDirs removes dir and its parents below root as long as they
+// are empty
+func removeEmptyDirs(root, dir string) {
+	// Remove only fails for non-empty directories, which stops the climb.
+	for d := dir; ; d = filepath.Dir(d) {
+		rel, err := filepath.Rel(root, d)
+		if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+			return
+		}
+		if os.Remove(d) != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package downloader
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLayoutRoundTrip(t *testing.T) {
+	var files []FileInfo
+	for _, f := range []struct{ source, collector, name string }{
+		{"ripe", "rrc00", "bview.20240101.0800.gz"},
+		{"ripe", "rrc01", "updates.20240229.2355.gz"},
+		{"routeviews", "rv2", "rib.20240101.0000.bz2"},
+		{"routeviews", "eqix", "updates.20241231.2345.bz2"},
+		{"routeviews", "rv", "rib.20240101.0000.bz2"},
+	} {
+		info, err := ParseFileName(f.source, f.collector, f.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, info)
+	}
+
+	layouts := []string{
+		"{source}/{collector}/{yyyy}/{mm}/{dd}/{type}.{yyyymmdd}.{hhmm}.{ext}",
+		"{archive}/{type}.{yyyy}{mm}{dd}.{hhmm}.{ext}",
+		"{collector}/{yyyymmdd}/{file}",
+	}
+	layouts = append(layouts, LayoutPresets()...)
+	for _, name := range layouts {
+		l, err := ParseLayout(name)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		paths := make(map[string]bool)
+		for _, f := range files {
+			p := l.Path(f)
+			if paths[p] {
+				t.Errorf("%s: two files map to %s", name, p)
+			}
+			paths[p] = true
+			source := ""
+			if !strings.Contains(l.Template(), "{source}") && !strings.Contains(l.Template(), "{archive}") {
+				source = f.Source
+			}
+			got, ok := l.Match(p, source)
+			if !ok || got != f {
+				t.Errorf("%s: %s matched %+v, %v; want %+v", name, p, got, ok, f)
+			}
+		}
+	}
+}
+
+func TestLayoutRejected(t *testing.T) {
+	for _, tmpl := range []string{
+		"",
+		"/abs/{file}",
+		"../{collector}/{file}",
+		"{collector}/{unknown}/{file}",
+		"{collector}/{file",
+		// The collector cannot be recovered
+		"{file}",
+		"{yyyy}/{file}",
+		"{source}/{type}.{yyyymmdd}.{hhmm}.{ext}",
+		// The file name cannot be recovered
+		"{typedir}/{source}/{collector}/{yyyymmdd}.{hhmm}.{ext}",
+		"{source}/{collector}/{type}.{yyyymmdd}.{hhmm}",
+		"{source}/{collector}/{type}.{yyyymmdd}.{hh}.{ext}",
+		"{source}/{collector}/{type}.{yyyy}{mm}.{hhmm}.{ext}",
+	} {
+		if _, err := ParseLayout(tmpl); err == nil {
+			t.Errorf("%q: accepted", tmpl)
+		}
+	}
+}
+
+func TestApplyRelayoutConflict(t *testing.T) {
+	root := t.TempDir()
+	write := func(rel string) string {
+		p := filepath.Join(root, rel)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(rel), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	a := write("ripe/bview/rrc00/2024.01/bview.20240101.0000.gz")
+	b := write("ripe/bview/rrc00/2024.01/bview.20240101.0800.gz")
+	taken := write("flat/ripe.rrc00.bview.20240101.0800.gz")
+	moves := []Move{
+		{From: a, To: filepath.Join(root, "flat/ripe.rrc00.bview.20240101.0000.gz")},
+		{From: b, To: taken},
+	}
+	if err := ApplyRelayout(root, moves); err == nil {
+		t.Fatal("got no error for an existing target")
+	}
+	// The move before the conflict was not made either
+	for _, p := range []string{a, b, taken} {
+		if _, err := os.Stat(p); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err := os.Stat(moves[0].To); err == nil {
+		t.Errorf("%s was moved", a)
+	}
+}
+
+func TestApplyRelayoutChecksums(t *testing.T) {
+	root := t.TempDir()
+	from := filepath.Join(root, "ripe/bview/rrc00/2024.01")
+	if err := os.MkdirAll(from, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	// Neither file is in the manifest; only one is in the checksum file
+	for _, name := range []string{"bview.20240101.0000.gz", "bview.20240101.0800.gz"} {
+		if err := os.WriteFile(filepath.Join(from, name), []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sums := sumOf('a') + "  bview.20240101.0000.gz\n"
+	if err := os.WriteFile(filepath.Join(from, ChecksumFile), []byte(sums), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	moves, err := PlanRelayout(root, DefaultLayout, FlatLayout, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(moves) != 2 {
+		t.Fatalf("got %d moves", len(moves))
+	}
+	if err := ApplyRelayout(root, moves); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(root, ChecksumFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := sumOf('a') + "  ripe.rrc00.bview.20240101.0000.gz\n"; string(data) != want {
+		t.Errorf("got checksums %q, want %q", data, want)
+	}
+	if _, err := os.Stat(filepath.Join(root, "ripe")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("got %v for the emptied directories", err)
+	}
+}
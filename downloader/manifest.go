@@ -0,0 +1,270 @@
+package downloader
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ManifestFile is the name of the manifest kept at the root of the output
+// directory.
+const ManifestFile = ".bgp-downloader-manifest.json"
+
+// manifestVersion is the version of the manifest file format
+const manifestVersion = 1
+
+// VerifyStatus is the outcome of the last integrity check of a file.
+type VerifyStatus string
+
+const (
+	// StatusUnverified means the file has not been checked since it was recorded.
+	StatusUnverified VerifyStatus = "unverified"
+	// StatusOK means the file matched its recorded checksum.
+	StatusOK VerifyStatus = "ok"
+	// StatusMismatch means the file no longer matches its recorded checksum.
+	StatusMismatch VerifyStatus = "mismatch"
+	// StatusMissing means the file was not found on disk.
+	StatusMissing VerifyStatus = "missing"
+)
+
+// ManifestEntry records a single file of the local archive.
+type ManifestEntry struct {
+	Path         string       `json:"path"` // relative to the output directory, slash separated
+	Source       string       `json:"source"`
+	Collector    string       `json:"collector"`
+	Type         string       `json:"type"`
+	DumpTime     time.Time    `json:"dump_time"`
+	URL          string       `json:"url,omitempty"`
+	Size         int64        `json:"size"`
+	SHA256       string       `json:"sha256"`
+	RemoteMTime  time.Time    `json:"remote_mtime"`
+	DownloadedAt time.Time    `json:"downloaded_at"`
+	Status       VerifyStatus `json:"status"`
+	VerifiedAt   time.Time    `json:"verified_at"`
+}
+
+// Info returns the archive file described by the entry.
+func (e ManifestEntry) Info() FileInfo {
+	name := filepath.Base(filepath.FromSlash(e.Path))
+	if info, err := ParseFileName(e.Source, e.Collector, name); err == nil {
+		return info
+	}
+	t, _ := ParseDumpType(e.Type)
+	return FileInfo{Source: e.Source, Collector: e.Collector, DumpType: t, Time: e.DumpTime, Name: name}
+}
+
+// Manifest is the index of the files in an output directory. It is safe
+// for concurrent use.
+type Manifest struct {
+	root string
+
+	mu      sync.Mutex
+	entries map[string]*ManifestEntry
+	dirty   bool
+}
+
+type manifestJSON struct {
+	Version int              `json:"version"`
+	Files   []*ManifestEntry `json:"files"`
+}
+
+// OpenManifest loads the manifest of the output directory root. A missing
+// manifest yields an empty one.
+func OpenManifest(root string) (*Manifest, error) {
+	m := &Manifest{root: root, entries: make(map[string]*ManifestEntry)}
+
+	data, err := os.ReadFile(filepath.Join(root, ManifestFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var doc manifestJSON
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid manifest %s: %v", filepath.Join(root, ManifestFile), err)
+	}
+	if doc.Version != manifestVersion {
+		return nil, fmt.Errorf("unsupported manifest version %d", doc.Version)
+	}
+	for _, e := range doc.Files {
+		m.entries[e.Path] = e
+	}
+	return m, nil
+}
+
+// Root returns the output directory the manifest belongs to.
+func (m *Manifest) Root() string {
+	return m.root
+}
+
+// Rel converts a path below the output directory to a manifest key.
+func (m *Manifest) Rel(path string) (string, error) {
+	rel, err := filepath.Rel(m.root, path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// Abs converts a manifest key to a file system path.
+func (m *Manifest) Abs(rel string) string {
+	return filepath.Join(m.root, filepath.FromSlash(rel))
+}
+
+// Get returns the entry recorded for the relative path.
+func (m *Manifest) Get(rel string) (ManifestEntry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[rel]
+	if !ok {
+		return ManifestEntry{}, false
+	}
+	return *e, true
+}
+
+// Put records or replaces an entry.
+func (m *Manifest) Put(e ManifestEntry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[e.Path] = &e
+	m.dirty = true
+}
+
+// Delete removes the entry for the relative path.
+func (m *Manifest) Delete(rel string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.entries[rel]; ok {
+		delete(m.entries, rel)
+		m.dirty = true
+	}
+}
+
+// Rename moves the entry for from to the relative path to.
+func (m *Manifest) Rename(from, to string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[from]
+	if !ok {
+		return
+	}
+	delete(m.entries, from)
+	e.Path = to
+	m.entries[to] = e
+	m.dirty = true
+}
+
+// Entries returns all entries sorted by path.
+func (m *Manifest) Entries() []ManifestEntry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entries := make([]ManifestEntry, 0, len(m.entries))
+	for _, e := range m.entries {
+		entries = append(entries, *e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	return entries
+}
+
+// Save writes the manifest if it changed since it was loaded or saved.
+func (m *Manifest) Save() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.dirty {
+		return nil
+	}
+
+	doc := manifestJSON{Version: manifestVersion}
+	for _, e := range m.entries {
+		doc.Files = append(doc.Files, e)
+	}
+	sort.Slice(doc.Files, func(i, j int) bool { return doc.Files[i].Path < doc.Files[j].Path })
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so an interrupted save never leaves a
+	// truncated manifest behind
+	path := filepath.Join(m.root, ManifestFile)
+	if err := writeFileAtomic(path, data); err != nil {
+		return fmt.Errorf("failed to save manifest: %v", err)
+	}
+	m.dirty = false
+	return nil
+}
+
+// RebuildManifest records every file below the output directory of m that
+// is stored according to l and missing from the manifest, and drops
+// entries whose file no longer exists. It returns the number of files
+// added and removed.
+func RebuildManifest(m *Manifest, l Layout, source string) (added, removed int, err error) {
+	for _, e := range m.Entries() {
+		if _, err := os.Stat(m.Abs(e.Path)); errors.Is(err, os.ErrNotExist) {
+			m.Delete(e.Path)
+			removed++
+		}
+	}
+
+	err = WalkLayout(m.root, l, source, func(path string, info FileInfo) error {
+		rel, err := m.Rel(path)
+		if err != nil {
+			return err
+		}
+		if _, ok := m.Get(rel); ok {
+			return nil
+		}
+		size, sum, err := hashFile(path)
+		if err != nil {
+			return err
+		}
+		m.Put(ManifestEntry{
+			Path:      rel,
+			Source:    info.Source,
+			Collector: info.Collector,
+			Type:      info.DumpType.String(),
+			DumpTime:  info.Time,
+			Size:      size,
+			SHA256:    sum,
+			Status:    StatusUnverified,
+		})
+		added++
+		return nil
+	})
+	return added, removed, err
+}
+
+// writeFileAtomic replaces path with data
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// hashFile returns the size and hex encoded SHA-256 of the file at path
+func hashFile(path string) (int64, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return 0, "", err
+	}
+	return n, hex.EncodeToString(h.Sum(nil)), nil
+}
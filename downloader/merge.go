@@ -0,0 +1,186 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"bgp_downloader/mrt"
+)
+
+// LocalFile is a downloaded archive file.
+type LocalFile struct {
+	FileInfo
+	Path string
+}
+
+// Feed returns the feed of the file. A file nothing is known about is a
+// feed of its own, named by its path.
+func (f LocalFile) Feed() Feed {
+	if f.Collector == "" {
+		return Feed{f.Source, f.Path}
+	}
+	return Feed{f.Source, f.Collector}
+}
+
+// LocalFiles resolves paths to archive files. Files are described from
+// their location below root according to l, falling back to their name;
+// directories are searched for files matching l. source is used when the
+// layout does not encode the source.
+func LocalFiles(root string, l Layout, source string, paths []string) ([]LocalFile, error) {
+	if l.IsZero() {
+		l = DefaultLayout
+	}
+	describe := func(path string) (FileInfo, bool) {
+		if rel, err := filepath.Rel(root, path); err == nil {
+			if info, ok := l.Match(rel, source); ok {
+				return info, true
+			}
+		}
+		return FileInfo{}, false
+	}
+
+	var files []LocalFile
+	for _, p := range paths {
+		fi, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !fi.IsDir() {
+			info, ok := describe(p)
+			if !ok {
+				name := filepath.Base(p)
+				if info, err = ParseFileName(source, "", name); err != nil {
+					info = FileInfo{Source: source, Name: name}
+				}
+			}
+			files = append(files, LocalFile{FileInfo: info, Path: p})
+			continue
+		}
+		err = filepath.Walk(p, func(path string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if fi.IsDir() {
+				return nil
+			}
+			if info, ok := describe(path); ok {
+				files = append(files, LocalFile{FileInfo: info, Path: path})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// OpenFiles returns a stream over the records of local files, in timestamp
+// order across files. Files of the same feed and dump type are read one
+// after the other in dump time order, so only one of them is open at a
+// time. Undecodable records are skipped with a warning to logger, or to
+// the default logger if it is nil.
+func OpenFiles(files []LocalFile, logger *slog.Logger) *Stream {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	type group struct {
+		feed Feed
+		typ  DumpType
+	}
+	groups := make(map[group][]LocalFile)
+	var order []group
+	for _, f := range files {
+		g := group{f.Feed(), f.DumpType}
+		if _, ok := groups[g]; !ok {
+			order = append(order, g)
+		}
+		groups[g] = append(groups[g], f)
+	}
+
+	var sources []recordSource
+	for _, g := range order {
+		files := groups[g]
+		sort.SliceStable(files, func(i, j int) bool { return files[i].Time.Before(files[j].Time) })
+		log := logger.With("source", g.feed.Source, "collector", g.feed.Collector)
+		sources = append(sources, &localSource{log: log, files: files})
+	}
+	return &Stream{merge{sources: sources}}
+}
+
+// Merge interleaves the records of several streams by timestamp.
+func Merge(streams ...*Stream) *Stream {
+	sources := make([]recordSource, len(streams))
+	for i, s := range streams {
+		sources[i] = s
+	}
+	return &Stream{merge{sources: sources}}
+}
+
+// next and close let a stream take part in another merge
+func (m *merge) next() (Record, error) {
+	if m.Next() {
+		return m.cur, nil
+	}
+	if m.err != nil {
+		return Record{}, m.err
+	}
+	return Record{}, io.EOF
+}
+
+func (m *merge) close() error {
+	return m.Close()
+}
+
+// localSource reads local files one after the other
+type localSource struct {
+	log    *slog.Logger
+	files  []LocalFile
+	reader *mrt.Reader
+	file   LocalFile
+}
+
+func (l *localSource) next() (Record, error) {
+	for {
+		if l.reader == nil {
+			if len(l.files) == 0 {
+				return Record{}, io.EOF
+			}
+			l.file = l.files[0]
+			l.files = l.files[1:]
+			r, err := mrt.Open(l.file.Path)
+			if err != nil {
+				return Record{}, err
+			}
+			l.reader = r
+		}
+
+		rec, err := l.reader.Next()
+		var de *mrt.DecodeError
+		switch {
+		case err == nil:
+			return Record{Record: rec, Feed: Feed{l.file.Source, l.file.Collector}, File: l.file.Path}, nil
+		case errors.As(err, &de):
+			l.log.Warn("skipped record", "file", l.file.Path, "error", err)
+		case errors.Is(err, io.EOF):
+			l.close()
+		default:
+			l.close()
+			return Record{}, fmt.Errorf("%s: %v", l.file.Path, err)
+		}
+	}
+}
+
+func (l *localSource) close() error {
+	if l.reader == nil {
+		return nil
+	}
+	err := l.reader.Close()
+	l.reader = nil
+	return err
+}
@@ -0,0 +1,127 @@
+package downloader
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics holds the Prometheus collectors updated by the downloader. A nil
+// *Metrics does nothing.
+type Metrics struct {
+	filesDownloaded  *prometheus.CounterVec
+	bytesDownloaded  *prometheus.CounterVec
+	downloadDuration *prometheus.HistogramVec
+	retries          *prometheus.CounterVec
+	failures         *prometheus.CounterVec
+	listingDuration  *prometheus.HistogramVec
+	listingLookups   *prometheus.CounterVec
+	lastDump         *prometheus.GaugeVec
+
+	mu       sync.Mutex
+	lastSeen map[[3]string]time.Time
+}
+
+// NewMetrics creates the downloader metrics and registers them with reg.
+func NewMetrics(reg prometheus.Registerer) *Metrics {
+	m := &Metrics{
+		filesDownloaded: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "bgp_downloader_files_downloaded_total",
+			Help: "Number of files downloaded.",
+		}, []string{"source", "collector", "type"}),
+		bytesDownloaded: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "bgp_downloader_bytes_downloaded_total",
+			Help: "Number of bytes written to downloaded files.",
+		}, []string{"source", "collector", "type"}),
+		downloadDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "bgp_downloader_download_duration_seconds",
+			Help:    "Time taken by successful downloads, including retries.",
+			Buckets: prometheus.ExponentialBuckets(0.1, 2, 14),
+		}, []string{"source", "collector", "type"}),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "bgp_downloader_retries_total",
+			Help: "Number of download attempts that were retried.",
+		}, []string{"source", "collector"}),
+		failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "bgp_downloader_failures_total",
+			Help: "Number of downloads that failed after all retries, by HTTP status code or error class.",
+		}, []string{"source", "collector", "code"}),
+		listingDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "bgp_downloader_listing_fetch_duration_seconds",
+			Help:    "Time taken to fetch archive directory listings.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"source"}),
+		listingLookups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "bgp_downloader_listing_cache_lookups_total",
+			Help: "Number of directory listing lookups, by cache result (hit, miss).",
+		}, []string{"source", "result"}),
+		lastDump: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "bgp_downloader_last_dump_timestamp_seconds",
+			Help: "Dump time of the most recent file downloaded successfully.",
+		}, []string{"source", "collector", "type"}),
+		lastSeen: make(map[[3]string]time.Time),
+	}
+	reg.MustRegister(
+		m.filesDownloaded,
+		m.bytesDownloaded,
+		m.downloadDuration,
+		m.retries,
+		m.failures,
+		m.listingDuration,
+		m.listingLookups,
+		m.lastDump,
+	)
+	return m
+}
+
+// listingFetched records a directory listing lookup
+func (m *Metrics) listingFetched(source string, cached bool, d time.Duration) {
+	if m == nil {
+		return
+	}
+	if cached {
+		m.listingLookups.WithLabelValues(source, "hit").Inc()
+		return
+	}
+	m.listingLookups.WithLabelValues(source, "miss").Inc()
+	m.listingDuration.WithLabelValues(source).Observe(d.Seconds())
+}
+
+// downloaded records a successful download
+func (m *Metrics) downloaded(info FileInfo, bytes int64, d time.Duration) {
+	if m == nil {
+		return
+	}
+	labels := []string{info.Source, info.Collector, info.DumpType.String()}
+	m.filesDownloaded.WithLabelValues(labels...).Inc()
+	m.bytesDownloaded.WithLabelValues(labels...).Add(float64(bytes))
+	m.downloadDuration.WithLabelValues(labels...).Observe(d.Seconds())
+
+	if info.Time.IsZero() {
+		return
+	}
+	key := [3]string{labels[0], labels[1], labels[2]}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if info.Time.After(m.lastSeen[key]) {
+		m.lastSeen[key] = info.Time
+		m.lastDump.WithLabelValues(labels...).Set(float64(info.Time.Unix()))
+	}
+}
+
+// retried records a failed attempt that will be retried
+func (m *Metrics) retried(info FileInfo) {
+	if m == nil {
+		return
+	}
+	m.retries.WithLabelValues(info.Source, info.Collector).Inc()
+}
+
+// failed records a download that gave up
+func (m *Metrics) failed(info FileInfo, err error) {
+	if m == nil {
+		return
+	}
+	m.failures.WithLabelValues(info.Source, info.Collector, errorCode(err)).Inc()
+}
@@ -0,0 +1,346 @@
+package downloader
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// progressRefresh is how often the terminal view is redrawn
+	progressRefresh = 500 * time.Millisecond
+	// progressSummaryInterval is how often a summary line is printed when
+	// the output is not a terminal
+	progressSummaryInterval = 30 * time.Second
+	// progressMaxInFlight limits the number of per-file lines in the
+	// terminal view
+	progressMaxInFlight = 10
+	// progressRateWindow is the period over which throughput is measured
+	progressRateWindow = 10 * time.Second
+)
+
+// Progress tracks the files of a download run and reports throughput and
+// the estimated time remaining. On a terminal it keeps a live view of the
+// files in flight; otherwise it prints a summary line periodically.
+//
+// All methods are safe for concurrent use, and a nil *Progress does
+// nothing.
+type Progress struct {
+	out io.Writer
+	tty bool
+
+	mu        sync.Mutex
+	started   time.Time
+	total     int
+	completed int
+	skipped   int
+	failed    int
+	bytes     int64 // bytes received, including files still in flight
+	doneBytes int64 // size of completed downloads
+	inFlight  map[*transfer]bool
+	samples   []rateSample
+	drawn     int // lines of the terminal view currently on screen
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// rateSample records the byte counter at a point in time
+type rateSample struct {
+	at    time.Time
+	bytes int64
+}
+
+// transfer is the progress of a single file download
+type transfer struct {
+	p       *Progress
+	name    string
+	size    int64 // from Content-Length, -1 if unknown
+	bytes   int64
+	started time.Time
+}
+
+// NewProgress returns a Progress writing to out. The terminal view is used
+// when tty is true.
+func NewProgress(out io.Writer, tty bool) *Progress {
+	return &Progress{
+		out:      out,
+		tty:      tty,
+		inFlight: make(map[*transfer]bool),
+	}
+}
+
+// IsTerminal reports whether f is connected to a terminal.
+func IsTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// Start begins periodic reporting.
+func (p *Progress) Start() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.started = time.Now()
+	p.stop = make(chan struct{})
+	p.done = make(chan struct{})
+	p.mu.Unlock()
+
+	interval := progressSummaryInterval
+	if p.tty {
+		interval = progressRefresh
+	}
+	go func() {
+		defer close(p.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.render()
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends periodic reporting and prints a final summary.
+func (p *Progress) Stop() {
+	if p == nil || p.stop == nil {
+		return
+	}
+	close(p.stop)
+	<-p.done
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clear()
+	fmt.Fprintln(p.out, p.summary(time.Now()))
+}
+
+// Wrap returns a writer that writes to w without corrupting the terminal
+// view. Loggers sharing the terminal with the progress view should write
+// through it.
+func (p *Progress) Wrap(w io.Writer) io.Writer {
+	if p == nil || !p.tty {
+		return w
+	}
+	return progressWriter{p: p, w: w}
+}
+
+type progressWriter struct {
+	p *Progress
+	w io.Writer
+}
+
+func (pw progressWriter) Write(b []byte) (int, error) {
+	pw.p.mu.Lock()
+	defer pw.p.mu.Unlock()
+	pw.p.clear()
+	return pw.w.Write(b)
+}
+
+// addFiles adds n files to the expected total
+func (p *Progress) addFiles(n int) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.total += n
+	p.mu.Unlock()
+}
+
+// skip marks a file as already present
+func (p *Progress) skip() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.skipped++
+	p.mu.Unlock()
+}
+
+// begin registers a file download
+func (p *Progress) begin(name string) *transfer {
+	if p == nil {
+		return nil
+	}
+	t := &transfer{p: p, name: name, size: -1, started: time.Now()}
+	p.mu.Lock()
+	p.inFlight[t] = true
+	p.mu.Unlock()
+	return t
+}
+
+// setSize records the expected size of the file, -1 if unknown
+func (t *transfer) setSize(size int64) {
+	if t == nil {
+		return
+	}
+	t.p.mu.Lock()
+	t.size = size
+	t.p.mu.Unlock()
+}
+
+// Write counts bytes received for the file.
+func (t *transfer) Write(b []byte) (int, error) {
+	t.p.mu.Lock()
+	t.bytes += int64(len(b))
+	t.p.bytes += int64(len(b))
+	t.p.mu.Unlock()
+	return len(b), nil
+}
+
+// reset discards the bytes received by a failed attempt
+func (t *transfer) reset() {
+	if t == nil {
+		return
+	}
+	t.p.mu.Lock()
+	t.p.bytes -= t.bytes
+	t.bytes = 0
+	t.p.mu.Unlock()
+}
+
+// finish removes the file from the in-flight set
+func (t *transfer) finish(err error) {
+	if t == nil {
+		return
+	}
+	p := t.p
+	p.mu.Lock()
+	delete(p.inFlight, t)
+	if err != nil {
+		p.failed++
+		p.bytes -= t.bytes
+	} else {
+		p.completed++
+		p.doneBytes += t.bytes
+	}
+	p.mu.Unlock()
+}
+
+// render draws the terminal view or prints a summary line
+func (p *Progress) render() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	p.samples = append(p.samples, rateSample{at: now, bytes: p.bytes})
+	for len(p.samples) > 2 && now.Sub(p.samples[1].at) >= progressRateWindow {
+		p.samples = p.samples[1:]
+	}
+
+	if !p.tty {
+		fmt.Fprintln(p.out, p.summary(now))
+		return
+	}
+
+	lines := []string{p.summary(now)}
+	transfers := make([]*transfer, 0, len(p.inFlight))
+	for t := range p.inFlight {
+		transfers = append(transfers, t)
+	}
+	sort.Slice(transfers, func(i, j int) bool { return transfers[i].started.Before(transfers[j].started) })
+	for i, t := range transfers {
+		if i == progressMaxInFlight {
+			lines = append(lines, fmt.Sprintf("  ... and %d more", len(transfers)-i))
+			break
+		}
+		lines = append(lines, "  "+t.line())
+	}
+
+	p.clear()
+	fmt.Fprint(p.out, strings.Join(lines, "\n")+"\n")
+	p.drawn = len(lines)
+}
+
+// clear erases the terminal view; p.mu must be held
+func (p *Progress) clear() {
+	if !p.tty {
+		return
+	}
+	for ; p.drawn > 0; p.drawn-- {
+		fmt.Fprint(p.out, "\033[1A\033[2K")
+	}
+}
+
+// summary formats the aggregate state; p.mu must be held
+func (p *Progress) summary(now time.Time) string {
+	elapsed := now.Sub(p.started)
+	rate := p.rate()
+	s := fmt.Sprintf("%d/%d files, %d in flight, %d skipped, %d failed, %s, %s/s, elapsed %s",
+		p.completed+p.skipped+p.failed, p.total, len(p.inFlight), p.skipped, p.failed,
+		formatBytes(p.bytes), formatBytes(int64(rate)), elapsed.Round(time.Second))
+	if eta, ok := p.eta(rate); ok {
+		s += ", ETA " + eta.Round(time.Second).String()
+	}
+	return s
+}
+
+// rate returns the recent throughput in bytes per second; p.mu must be held
+func (p *Progress) rate() float64 {
+	if len(p.samples) < 2 {
+		if d := time.Since(p.started).Seconds(); d > 0 {
+			return float64(p.bytes) / d
+		}
+		return 0
+	}
+	first, last := p.samples[0], p.samples[len(p.samples)-1]
+	d := last.at.Sub(first.at).Seconds()
+	if d <= 0 {
+		return 0
+	}
+	return float64(last.bytes-first.bytes) / d
+}
+
+// eta estimates the remaining time from the average size of the files
+// completed so far; p.mu must be held
+func (p *Progress) eta(rate float64) (time.Duration, bool) {
+	if p.completed == 0 || rate <= 0 {
+		return 0, false
+	}
+	avg := p.doneBytes / int64(p.completed)
+
+	var remaining int64
+	waiting := p.total - p.completed - p.skipped - p.failed - len(p.inFlight)
+	if waiting > 0 {
+		remaining += int64(waiting) * avg
+	}
+	for t := range p.inFlight {
+		if t.size >= 0 {
+			remaining += t.size - t.bytes
+		} else if avg > t.bytes {
+			remaining += avg - t.bytes
+		}
+	}
+	return time.Duration(float64(remaining) / rate * float64(time.Second)), true
+}
+
+// line formats the progress of a single file; t.p.mu must be held
+func (t *transfer) line() string {
+	if t.size > 0 {
+		return fmt.Sprintf("%s %s/%s (%d%%)", t.name, formatBytes(t.bytes), formatBytes(t.size), t.bytes*100/t.size)
+	}
+	return fmt.Sprintf("%s %s", t.name, formatBytes(t.bytes))
+}
+
+// formatBytes formats n using binary units
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,167 @@
+package downloader
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PruneOptions describes a retention policy for a local archive.
+type PruneOptions struct {
+	// Root is the output directory to prune.
+	Root string
+	// Layout is the layout of Root; the zero value selects DefaultLayout.
+	Layout Layout
+	// Source is used when the layout does not encode the source.
+	Source string
+	// KeepUpdates is how long updates files are kept; zero keeps them all.
+	KeepUpdates time.Duration
+	// KeepRIBs is how long RIB dumps are kept; zero keeps them all.
+	KeepRIBs time.Duration
+	// KeepRIBsEvery thins the RIB dumps that are kept to the first one of
+	// every interval per collector; zero keeps every dump.
+	KeepRIBsEvery time.Duration
+	// Now is the reference time for the retention periods; the zero value
+	// selects the current time.
+	Now time.Time
+	// DryRun reports the files that would be removed without removing them.
+	DryRun bool
+}
+
+// PrunedFile is a file removed, or to be removed, by Prune.
+type PrunedFile struct {
+	Path   string
+	Info   FileInfo
+	Size   int64
+	Reason string // "expired" or "thinned"
+}
+
+// Prune removes the files of opts.Root that fall outside the retention
+// policy, updates the manifest and checksum files accordingly and removes
+// the directories left empty.
+func Prune(opts PruneOptions) ([]PrunedFile, error) {
+	if opts.Layout.IsZero() {
+		opts.Layout = DefaultLayout
+	}
+	if opts.Now.IsZero() {
+		opts.Now = time.Now()
+	}
+
+	type candidate struct {
+		path string
+		info FileInfo
+		size int64
+	}
+	var files []candidate
+	err := WalkLayout(opts.Root, opts.Layout, opts.Source, func(p string, info FileInfo) error {
+		fi, err := os.Stat(p)
+		if err != nil {
+			return err
+		}
+		files = append(files, candidate{path: p, info: info, size: fi.Size()})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].info.Time.Before(files[j].info.Time) })
+
+	var pruned []PrunedFile
+	kept := make(map[string]bool) // RIB buckets that already have a dump
+	for _, f := range files {
+		reason := ""
+		switch f.info.DumpType {
+		case DumpUpdates:
+			if opts.KeepUpdates > 0 && f.info.Time.Before(opts.Now.Add(-opts.KeepUpdates)) {
+				reason = "expired"
+			}
+		case DumpRIB:
+			if opts.KeepRIBs > 0 && f.info.Time.Before(opts.Now.Add(-opts.KeepRIBs)) {
+				reason = "expired"
+			} else if opts.KeepRIBsEvery > 0 {
+				bucket := fmt.Sprintf("%s/%s/%d", f.info.Source, f.info.Collector,
+					f.info.Time.Truncate(opts.KeepRIBsEvery).Unix())
+				if kept[bucket] {
+					reason = "thinned"
+				}
+				kept[bucket] = true
+			}
+		}
+		if reason != "" {
+			pruned = append(pruned, PrunedFile{Path: f.path, Info: f.info, Size: f.size, Reason: reason})
+		}
+	}
+	if opts.DryRun || len(pruned) == 0 {
+		return pruned, nil
+	}
+
+	m, err := OpenManifest(opts.Root)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		removed []PrunedFile
+		dirs    []string
+		rmErr   error
+	)
+	names := make(map[string]map[string]bool) // removed file names by directory
+	for _, f := range pruned {
+		if rmErr = os.Remove(f.Path); rmErr != nil {
+			break
+		}
+		removed = append(removed, f)
+		if rel, err := m.Rel(f.Path); err == nil {
+			m.Delete(rel)
+		}
+		dir := filepath.Dir(f.Path)
+		if names[dir] == nil {
+			names[dir] = make(map[string]bool)
+			dirs = append(dirs, dir)
+		}
+		names[dir][filepath.Base(f.Path)] = true
+	}
+	// The checksum files may list files the manifest does not know, so only
+	// the lines of the removed files are dropped
+	for _, dir := range dirs {
+		if err := updateChecksums(dir, nil, names[dir]); err != nil {
+			return removed, err
+		}
+	}
+	if err := m.Save(); err != nil {
+		return removed, err
+	}
+	for _, dir := range dirs {
+		removeEmptyDirs(opts.Root, dir)
+	}
+	return removed, rmErr
+}
+
+// ParseRetention parses a retention period. In addition to the units of
+// time.ParseDuration it accepts d (days), w (weeks) and y (365 days).
+func ParseRetention(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "0" {
+		return 0, nil
+	}
+	units := map[byte]time.Duration{
+		'd': 24 * time.Hour,
+		'w': 7 * 24 * time.Hour,
+		'y': 365 * 24 * time.Hour,
+	}
+	if unit, ok := units[s[len(s)-1]]; ok {
+		n, err := strconv.ParseFloat(s[:len(s)-1], 64)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid retention period: %s", s)
+		}
+		return time.Duration(n * float64(unit)), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid retention period: %s", s)
+	}
+	return d, nil
+}
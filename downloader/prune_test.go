@@ -0,0 +1,52 @@
+package downloader
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPruneRemovesEmptyDirs(t *testing.T) {
+	root := t.TempDir()
+	var paths []string
+	for _, name := range []string{"updates.20240101.0000.gz", "updates.20240101.0005.gz", "updates.20240301.0000.gz"} {
+		info, err := ParseFileName("ripe", "rrc00", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := filepath.Join(root, DefaultLayout.Path(info))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := updateChecksums(filepath.Dir(p), map[string]string{name: sumOf(name[17])}, nil); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, p)
+	}
+
+	removed, err := Prune(PruneOptions{
+		Root:        root,
+		KeepUpdates: 30 * 24 * time.Hour,
+		Now:         time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 2 {
+		t.Fatalf("got %d files removed", len(removed))
+	}
+	if _, err := os.Stat(filepath.Dir(paths[0])); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("got %v for the emptied directory", err)
+	}
+	if _, err := os.Stat(paths[2]); err != nil {
+		t.Error(err)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(paths[2]), ChecksumFile)); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,119 @@
+package downloader
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// ribLookback is how far back FindRIB searches for a RIB dump
+const ribLookback = 7 * 24 * time.Hour
+
+// Snapshot is the RIB dump in effect at a point in time, optionally with
+// the updates files that bring it forward to that time.
+type Snapshot struct {
+	At      time.Time
+	RIB     RemoteFile
+	Updates []RemoteFile
+}
+
+// Files returns the RIB dump followed by the updates files.
+func (s Snapshot) Files() []RemoteFile {
+	return append([]RemoteFile{s.RIB}, s.Updates...)
+}
+
+// FindRIB locates the latest RIB dump of the collector taken at or before
+// at. If withUpdates is set, the updates files from the dump time up to at
+// are included as well.
+func FindRIB(source, collector string, at time.Time, withUpdates bool) (Snapshot, error) {
+	at = at.UTC()
+	snap := Snapshot{At: at}
+
+	// Walk back one day at a time until a dump is found
+	found := false
+	oldest := at.Add(-ribLookback).Truncate(24 * time.Hour)
+	for day := at.Truncate(24 * time.Hour); !found && !day.Before(oldest); day = day.AddDate(0, 0, -1) {
+		files, err := ListFiles(source, collector, []DumpType{DumpRIB}, day)
+		if err != nil {
+			return snap, err
+		}
+		for _, f := range files {
+			if f.DumpType == DumpRIB && !f.Time.After(at) && (!found || f.Time.After(snap.RIB.Time)) {
+				snap.RIB = f
+				found = true
+			}
+		}
+	}
+	if !found {
+		return snap, fmt.Errorf("no RIB dump of %s found between %s and %s",
+			collector, oldest.Format(time.RFC3339), at.Format(time.RFC3339))
+	}
+	if !withUpdates {
+		return snap, nil
+	}
+	updates, err := findUpdates(source, collector, snap.RIB.Time, at)
+	snap.Updates = updates
+	return snap, err
+}
+
+// FindRange locates the RIB dump of the collector in effect at from with
+// FindRIB, and the updates files from the dump time up to to.
+func FindRange(source, collector string, from, to time.Time) (Snapshot, error) {
+	snap, err := FindRIB(source, collector, from, false)
+	if err != nil {
+		return snap, err
+	}
+	snap.At = to.UTC()
+	snap.Updates, err = findUpdates(source, collector, snap.RIB.Time, snap.At)
+	return snap, err
+}
+
+// findUpdates lists the updates files of the collector from since up to
+// until, in time order
+func findUpdates(source, collector string, since, until time.Time) ([]RemoteFile, error) {
+	var updates []RemoteFile
+	for day := since.Truncate(24 * time.Hour); !day.After(until); day = day.AddDate(0, 0, 1) {
+		files, err := ListFiles(source, collector, []DumpType{DumpUpdates}, day)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			if f.DumpType == DumpUpdates && !f.Time.Before(since) && !f.Time.After(until) {
+				updates = append(updates, f)
+			}
+		}
+	}
+	sort.Slice(updates, func(i, j int) bool { return updates[i].Time.Before(updates[j].Time) })
+	return updates, nil
+}
+
+// DownloadRIB locates the snapshot of opts.Collector at the given time with
+// FindRIB and downloads its files as DownloadFiles does.
+func DownloadRIB(opts Options, at time.Time, withUpdates bool) (Snapshot, error) {
+	snap, err := FindRIB(opts.Source, opts.Collector, at, withUpdates)
+	if err != nil {
+		return snap, err
+	}
+	return snap, DownloadFiles(opts, snap.Files())
+}
+
+// FirstRIB locates the first RIB dump the collector took on the given day.
+func FirstRIB(source, collector string, day time.Time) (RemoteFile, error) {
+	day = day.UTC().Truncate(24 * time.Hour)
+	files, err := ListFiles(source, collector, []DumpType{DumpRIB}, day)
+	if err != nil {
+		return RemoteFile{}, err
+	}
+	var first RemoteFile
+	found := false
+	for _, f := range files {
+		if f.DumpType == DumpRIB && (!found || f.Time.Before(first.Time)) {
+			first = f
+			found = true
+		}
+	}
+	if !found {
+		return first, fmt.Errorf("no RIB dump of %s on %s", collector, day.Format("2006-01-02"))
+	}
+	return first, nil
+}
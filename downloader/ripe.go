@@ -1,13 +1,18 @@
 package downloader
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"os"
-	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -16,131 +21,108 @@ const (
 )
 
 // fileCache stores the file list for a specific monthURL to avoid duplicate requests
-var fileCache = make(map[string][]string)
+var (
+	fileCache   = make(map[string][]string)
+	fileCacheMu sync.Mutex
+)
+
+// Retry parameters shared by downloads and streams
+const (
+	maxRetries      = 5
+	firstRetryDelay = 1 * time.Second
+)
 
-func downloadDailyData(collector, dataType string, date time.Time, outputDir string) error {
+// ripeFileRe finds links to RIPE archive files in a directory listing
+var ripeFileRe = regexp.MustCompile(`href="([^"]+\.gz)`)
+
+// listRipeDay returns the RIPE archive files of the configured types for
+// the given day
+func (s *session) listRipeDay(date time.Time) ([]RemoteFile, error) {
 	// Format date components
 	yyyyMM := date.Format("2006.01")
-	_ = date.Format("20060102") // This is required by the specification but not used in this simplified version
 
 	// Create the base URL for the day
-	monthURL := fmt.Sprintf("%s/%s/%s", baseURL, collector, yyyyMM)
+	monthURL := fmt.Sprintf("%s/%s/%s", baseURL, s.Collector, yyyyMM)
 
 	// Get the list of files for the day
-	files, err := GetMonthlyFileList(monthURL, date)
+	files, err := s.fetchFileList(monthURL, date, ripeFileRe)
 	if err != nil {
-		return fmt.Errorf("failed to get file list for %s: %v", date.Format("2006-01-02"), err)
+		return nil, fmt.Errorf("failed to get file list for %s: %v", date.Format("2006-01-02"), err)
 	}
 
 	// Filter files based on data type
-	var filteredFiles []string
-	switch dataType {
-	case "bview":
-		for _, file := range files {
-			if strings.Contains(file, "bview") {
-				filteredFiles = append(filteredFiles, file)
-			}
-		}
-	case "updates":
-		for _, file := range files {
-			if strings.Contains(file, "updates") {
-				filteredFiles = append(filteredFiles, file)
-			}
-		}
-	case "all":
-		filteredFiles = files
-	default:
-		return fmt.Errorf("invalid data type: %s", dataType)
-	}
-
-	// Download each file
-	for _, file := range filteredFiles {
-		fileURL := fmt.Sprintf("%s/%s", monthURL, file)
-
-		// Create subdirectory structure: ./collector/yyyy.mm/type
-		var subDir string
-		if strings.Contains(file, "bview") {
-			subDir = filepath.Join(outputDir, "ripe", "bview", collector, yyyyMM)
-		} else if strings.Contains(file, "updates") {
-			subDir = filepath.Join(outputDir, "ripe", "updates", collector, yyyyMM)
-		} else {
-			subDir = filepath.Join(outputDir, "ripe", "unknown", collector, yyyyMM)
-		}
-
-		// Create the subdirectory if it doesn't exist
-		if err := os.MkdirAll(subDir, 0755); err != nil {
-			return fmt.Errorf("failed to create subdirectory: %v", err)
+	var filteredFiles []RemoteFile
+	for _, file := range files {
+		if !hasDumpType(s.Types, DumpTypeOf(file)) {
+			continue
 		}
-
-		// Create the full output path
-		outputPath := filepath.Join(subDir, file)
-
-		if err := downloadFile(fileURL, outputPath); err != nil {
-			return fmt.Errorf("failed to download %s: %v", file, err)
+		info, err := ParseFileName("ripe", s.Collector, file)
+		if err != nil {
+			info = FileInfo{Source: "ripe", Collector: s.Collector, Time: date, Name: file}
 		}
-
-		fmt.Printf("Downloaded: %s to %s\n", file, subDir)
+		filteredFiles = append(filteredFiles, RemoteFile{
+			FileInfo: info,
+			URL:      fmt.Sprintf("%s/%s", monthURL, file),
+		})
 	}
 
-	return nil
+	return filteredFiles, nil
 }
 
+// GetMonthlyFileList returns the RIPE archive files of the given day listed
+// at monthURL
 func GetMonthlyFileList(monthURL string, date time.Time) ([]string, error) {
-	// Extract monthURL from dayURL
-	// urlParts := strings.Split(dayURL, "/")
-	// if len(urlParts) < 5 {
-	// 	return nil, fmt.Errorf("invalid URL format: %s", dayURL)
-	// }
-	// monthURL := strings.Join(urlParts[:len(urlParts)-1], "/")
-
-	// Check if we have cached results for this monthURL
-	if cachedFiles, exists := fileCache[monthURL]; exists {
-		// Filter cached files based on the specified date
-		dateStr := date.Format("20060102") // Format date as YYYYMMDD
-		var filteredFiles []string
-		for _, file := range cachedFiles {
-			// Check if the file name contains the date string
-			if strings.Contains(file, dateStr) {
-				filteredFiles = append(filteredFiles, file)
-			}
+	return newSession(Options{Source: "ripe"}).fetchFileList(monthURL, date, ripeFileRe)
+}
+
+// fetchFileList fetches the directory listing at listURL, caches the file
+// names matched by re and returns those belonging to the given day
+func (s *session) fetchFileList(listURL string, date time.Time, re *regexp.Regexp) ([]string, error) {
+	// Check if we have cached results for this listURL
+	fileCacheMu.Lock()
+	files, exists := fileCache[listURL]
+	fileCacheMu.Unlock()
+
+	if exists {
+		s.log.Debug("listing cache hit", "collector", s.Collector, "url", listURL)
+		s.Metrics.listingFetched(s.Source, true, 0)
+	} else {
+		started := time.Now()
+
+		// Make an HTTP GET request to listURL
+		resp, err := http.Get(listURL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch URL %s: %v", listURL, err)
 		}
-		return filteredFiles, nil
-	}
+		defer resp.Body.Close()
 
-	// Make an HTTP GET request to dayURL
-	resp, err := http.Get(monthURL)
-	if err != nil {
-		return nil, fmt.Errorf("failed to fetch URL %s: %v", monthURL, err)
-	}
-	defer resp.Body.Close()
+		// Check server response
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("bad status for %s: %s", listURL, resp.Status)
+		}
 
-	// Check server response
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("bad status for %s: %s", monthURL, resp.Status)
-	}
+		// Read the response body
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read response body from %s: %v", listURL, err)
+		}
 
-	// Read the response body
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response body from %s: %v", monthURL, err)
-	}
+		// Parse the HTML response to extract file links
+		for _, match := range re.FindAllStringSubmatch(string(body), -1) {
+			if len(match) > 1 {
+				files = append(files, match[1])
+			}
+		}
 
-	// Parse the HTML response to extract .gz file links
-	// Using a simple regex to find href attributes ending with .gz
-	re := regexp.MustCompile(`href="([^"]+\.gz)`)
-	matches := re.FindAllStringSubmatch(string(body), -1)
+		// Cache the file list for this listURL
+		fileCacheMu.Lock()
+		fileCache[listURL] = files
+		fileCacheMu.Unlock()
 
-	// Extract file names from the matches
-	var files []string
-	for _, match := range matches {
-		if len(match) > 1 {
-			files = append(files, match[1])
-		}
+		s.log.Info("listing fetched", "collector", s.Collector, "url", listURL, "files", len(files), "duration", time.Since(started))
+		s.Metrics.listingFetched(s.Source, false, time.Since(started))
 	}
 
-	// Cache the file list for this monthURL
-	fileCache[monthURL] = files
-
 	// Filter files based on the specified date
 	dateStr := date.Format("20060102") // Format date as YYYYMMDD
 	var filteredFiles []string
@@ -154,64 +136,227 @@ func GetMonthlyFileList(monthURL string, date time.Time) ([]string, error) {
 	return filteredFiles, nil
 }
 
-func downloadFile(url, outputPath string) error {
-	// Check if file already exists
-	if _, err := os.Stat(outputPath); err == nil {
-		return nil // File already exists, skip download
+func (s *session) downloadFile(info FileInfo, url, outputPath string) error {
+	log := s.log.With("collector", info.Collector, "file", info.Name)
+
+	rel, err := s.manifest.Rel(outputPath)
+	if err != nil {
+		return err
 	}
 
-	// Create the file
-	out, err := os.Create(outputPath)
+	// Check if file already exists and matches the manifest
+	if fi, err := os.Stat(outputPath); err == nil {
+		entry, recorded := s.manifest.Get(rel)
+		switch {
+		case !recorded:
+			// Adopt files downloaded before the manifest existed, unless
+			// they are incomplete
+			remote, err := remoteSize(url)
+			if err != nil {
+				log.Warn("cannot check size of unrecorded file, downloading again", "path", outputPath, "error", err)
+				break
+			}
+			if remote >= 0 && remote != fi.Size() {
+				log.Warn("size of unrecorded file differs from archive, downloading again", "path", outputPath,
+					"bytes", fi.Size(), "expected", remote)
+				break
+			}
+			if err := s.adoptFile(info, url, rel, outputPath); err != nil {
+				return err
+			}
+			log.Debug("download skipped", "reason", "exists", "path", outputPath)
+			s.Progress.skip()
+			return nil
+		case entry.Size == fi.Size():
+			log.Debug("download skipped", "reason", "exists", "path", outputPath)
+			s.Progress.skip()
+			return nil
+		default:
+			log.Warn("size differs from manifest, downloading again", "path", outputPath,
+				"bytes", fi.Size(), "expected", entry.Size)
+		}
+	}
+
+	// Download into a temporary file so that an interrupted download never
+	// looks complete
+	partPath := outputPath + ".part"
+	out, err := os.Create(partPath)
 	if err != nil {
 		return err
 	}
 	defer out.Close()
 
-	//Retry parameters
-	const maxRetries = 5
-	retryDelay := 1 * time.Second
+	retryDelay := firstRetryDelay
 
-	// Retry loop
-	for i:=0; i <maxRetries;i++ {
-		resp, err := http.Get(url)
-		if err != nil {
-			if i == maxRetries {
-				return err // Return the error if we've exhausted retries
-			}
-			fmt.Printf("Download attempt %d failed: %v. Retrying in %v...\n", i+1, err, retryDelay)
-			time.Sleep(retryDelay)
-			retryDelay *= 2 // Exponential backoff
-			continue
-		}
-		defer resp.Body.Close()
+	log.Debug("download started", "url", url, "path", outputPath)
+	t := s.Progress.begin(info.Name)
+	firstAttempt := time.Now()
 
-		// Check server response
-		if resp.StatusCode != http.StatusOK {
-			if i == maxRetries {
-				return fmt.Errorf("bad status: %s", resp.Status)
+	// Retry loop
+	for attempt := 1; ; attempt++ {
+		started := time.Now()
+		res, err := fetchInto(out, url, t)
+		if err == nil {
+			// The part file is complete; failing to move it into place is
+			// not worth another download
+			if err = out.Close(); err == nil {
+				err = os.Rename(partPath, outputPath)
 			}
-			fmt.Printf("Download attempt %d failed with status %s. Retrying in %v...\n", i+1, resp.Status, retryDelay)
-			time.Sleep(retryDelay)
-			retryDelay *= 2 // Exponential backoff
-			continue
-		}
-
-		// Write the body to file
-		_, err = io.Copy(out, resp.Body)
-		if err != nil {
-			if i == maxRetries {
+			if err != nil {
+				log.Error("download failed", "url", url, "attempt", attempt, "error", err)
+				t.finish(err)
+				s.Metrics.failed(info, err)
+				os.Remove(partPath)
 				return err
 			}
-			fmt.Printf("Failed to write to file on attempt %d: %v. Retrying in %v...\n", i+1, err, retryDelay)
-			time.Sleep(retryDelay)
-			retryDelay *= 2 // Exponential backoff
-			continue
+			log.Info("download finished", "path", outputPath, "bytes", res.bytes,
+				"duration", time.Since(started), "attempt", attempt)
+			t.finish(nil)
+			s.Metrics.downloaded(info, res.bytes, time.Since(firstAttempt))
+			s.record(ManifestEntry{
+				Path:         rel,
+				Source:       info.Source,
+				Collector:    info.Collector,
+				Type:         info.DumpType.String(),
+				DumpTime:     info.Time,
+				URL:          url,
+				Size:         res.bytes,
+				SHA256:       res.sha256,
+				RemoteMTime:  res.modified,
+				DownloadedAt: time.Now().UTC(),
+				Status:       StatusUnverified,
+			})
+			return nil
 		}
 
-		// Success
-		fmt.Printf("Successfully downloaded %s\n", outputPath)
-		return nil
+		if attempt == maxRetries || isClientError(err) {
+			log.Error("download failed", "url", url, "attempt", attempt, "error", err)
+			t.finish(err)
+			s.Metrics.failed(info, err)
+			out.Close()
+			os.Remove(partPath)
+			return err
+		}
+		log.Warn("download retry", "url", url, "attempt", attempt, "error", err, "delay", retryDelay)
+		t.reset()
+		s.Metrics.retried(info)
+		time.Sleep(retryDelay)
+		retryDelay *= 2 // Exponential backoff
 	}
+}
 
+// adoptFile records a file that exists on disk but not in the manifest
+func (s *session) adoptFile(info FileInfo, url, rel, path string) error {
+	size, sum, err := hashFile(path)
+	if err != nil {
+		return err
+	}
+	s.record(ManifestEntry{
+		Path:      rel,
+		Source:    info.Source,
+		Collector: info.Collector,
+		Type:      info.DumpType.String(),
+		DumpTime:  info.Time,
+		URL:       url,
+		Size:      size,
+		SHA256:    sum,
+		Status:    StatusUnverified,
+	})
 	return nil
 }
+
+// remoteSize returns the size of the file at url reported by the server,
+// or -1 if it does not report one
+func remoteSize(url string) (int64, error) {
+	resp, err := http.Head(url)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, &statusError{code: resp.StatusCode, status: resp.Status}
+	}
+	return resp.ContentLength, nil
+}
+
+// fetchResult describes a completed transfer
+type fetchResult struct {
+	bytes    int64
+	sha256   string
+	modified time.Time // Last-Modified reported by the server, zero if absent
+}
+
+// fetchInto truncates out and writes the body of url into it, hashing it
+// and counting the bytes received in t
+func fetchInto(out *os.File, url string, t *transfer) (fetchResult, error) {
+	var res fetchResult
+	if err := out.Truncate(0); err != nil {
+		return res, err
+	}
+	if _, err := out.Seek(0, io.SeekStart); err != nil {
+		return res, err
+	}
+
+	resp, err := http.Get(url)
+	if err != nil {
+		return res, err
+	}
+	defer resp.Body.Close()
+
+	// Check server response
+	if resp.StatusCode != http.StatusOK {
+		return res, &statusError{code: resp.StatusCode, status: resp.Status}
+	}
+	if lm, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		res.modified = lm.UTC()
+	}
+
+	// Write the body to file
+	h := sha256.New()
+	dst := io.MultiWriter(out, h)
+	if t != nil {
+		t.setSize(resp.ContentLength)
+		dst = io.MultiWriter(out, h, t)
+	}
+	res.bytes, err = io.Copy(dst, resp.Body)
+	res.sha256 = hex.EncodeToString(h.Sum(nil))
+	return res, err
+}
+
+// statusError is returned when the server answers with a status other than
+// 200 OK
+type statusError struct {
+	code   int
+	status string
+}
+
+func (e *statusError) Error() string {
+	return "bad status: " + e.status
+}
+
+// clientError reports whether the status is a 4xx error that asking again
+// will not fix, such as 404 Not Found
+func (e *statusError) clientError() bool {
+	return e.code >= 400 && e.code < 500 &&
+		e.code != http.StatusRequestTimeout && e.code != http.StatusTooManyRequests
+}
+
+// isClientError reports whether err is a client error status, on which
+// downloads and streams give up at once
+func isClientError(err error) bool {
+	var se *statusError
+	return errors.As(err, &se) && se.clientError()
+}
+
+// errorCode classifies err for the failures metric
+func errorCode(err error) string {
+	var se *statusError
+	if errors.As(err, &se) {
+		return strconv.Itoa(se.code)
+	}
+	var ne net.Error
+	if errors.As(err, &ne) {
+		return "network"
+	}
+	return "other"
+}
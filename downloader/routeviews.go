@@ -2,12 +2,7 @@ package downloader
 
 import (
 	"fmt"
-	"io"
-	"net/http"
-	"os"
-	"path/filepath"
 	"regexp"
-	"strings"
 	"time"
 )
 
@@ -32,134 +27,43 @@ var routeviewsMap = map[string]string{
 	"ams":       "amsix.ams/bgpdata",
 }
 
-// fileCache stores the file list for a specific monthURL to avoid duplicate requests
-var routeViewsFileCache = make(map[string][]string)
+// routeViewsFileRe finds links to RouteViews archive files in a directory listing
+var routeViewsFileRe = regexp.MustCompile(`href="([^"]+\.bz2)`)
 
-func downloadDailyRVData(collector, dataType string, date time.Time, outputDir string) error {
+// listRouteViewsDay returns the RouteViews archive files of the configured
+// types for the given day
+func (s *session) listRouteViewsDay(date time.Time) ([]RemoteFile, error) {
 	// Format date components
 	yyyyMM := date.Format("2006.01")
-	_ = date.Format("20060102") // This is required by the specification but not used in this simplified version
 
 	// Create the base URL for the day
-	dayURL := fmt.Sprintf("%s/%s/%s", routeViewsBaseURL, routeviewsMap[collector], yyyyMM)
-	rib_url := fmt.Sprintf("%s/%s", dayURL, "RIBS")
-	updates_url := fmt.Sprintf("%s/%s", dayURL, "UPDATES")
-
-	rib_files, err := GetRouteViewsDailyFileList(rib_url, date)
-	if err != nil {
-		return fmt.Errorf("failed to get file list for %s: %v", date.Format("2006-01-02"), err)
-	}
-
-	updates_files, err := GetRouteViewsDailyFileList(updates_url, date)
-	if err != nil {
-		return fmt.Errorf("failed to get file list for %s: %v", date.Format("2006-01-02"), err)
-	}
-
-	var filteredFiles []string
-	switch dataType {
-	case "rib":
-		filteredFiles = append(filteredFiles, rib_files...)
-	case "updates":
-		filteredFiles = append(filteredFiles, updates_files...)
-	case "all":
-		filteredFiles = append(rib_files, updates_files...)
-	default:
-		return fmt.Errorf("invalid data type: %s", dataType)
-	}
-
-	dataType = strings.ToUpper(dataType)
-
-	// Download each file
-	for _, file := range filteredFiles {
-
-		fileURL := fmt.Sprintf("%s/%s/%s", dayURL, dataType, file)
-
-		// Create subdirectory structure: ./collector/yyyy.mm/type
-		var subDir string
-		if strings.Contains(file, "rib") {
-			subDir = filepath.Join(outputDir, "routeviews", "ribs", collector, yyyyMM)
-		} else if strings.Contains(file, "updates") {
-			subDir = filepath.Join(outputDir, "routeviews", "updates", collector, yyyyMM)
-		} else {
-			subDir = filepath.Join(outputDir, "routeviews", "unknown", collector, yyyyMM)
-		}
-
-		// Create the subdirectory if it doesn't exist
-		if err := os.MkdirAll(subDir, 0755); err != nil {
-			return fmt.Errorf("failed to create subdirectory: %v", err)
+	dayURL := fmt.Sprintf("%s/%s/%s", routeViewsBaseURL, routeviewsMap[s.Collector], yyyyMM)
+
+	// RouteViews keeps RIBs and updates in separate directories
+	var filteredFiles []RemoteFile
+	for _, t := range s.Types {
+		typeDir := FileInfo{Source: "routeviews", DumpType: t}.archiveType()
+		files, err := s.fetchFileList(fmt.Sprintf("%s/%s", dayURL, typeDir), date, routeViewsFileRe)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get file list for %s: %v", date.Format("2006-01-02"), err)
 		}
-
-		// Create the full output path
-		outputPath := filepath.Join(subDir, file)
-		fmt.Println(fileURL)
-		if err := downloadFile(fileURL, outputPath); err != nil {
-			return fmt.Errorf("failed to download %s: %v", file, err)
+		for _, file := range files {
+			info, err := ParseFileName("routeviews", s.Collector, file)
+			if err != nil {
+				info = FileInfo{Source: "routeviews", Collector: s.Collector, DumpType: t, Time: date, Name: file}
+			}
+			filteredFiles = append(filteredFiles, RemoteFile{
+				FileInfo: info,
+				URL:      fmt.Sprintf("%s/%s/%s", dayURL, typeDir, file),
+			})
 		}
-
-		fmt.Printf("Downloaded: %s to %s\n", file, subDir)
 	}
 
-	return nil
+	return filteredFiles, nil
 }
 
+// GetRouteViewsDailyFileList returns the RouteViews archive files of the
+// given day listed at monthURL
 func GetRouteViewsDailyFileList(monthURL string, date time.Time) ([]string, error) {
-
-	if cachedFiles, exists := fileCache[monthURL]; exists {
-		// Filter cached files based on the specified date
-		dateStr := date.Format("20060102") // Format date as YYYYMMDD
-		var filteredFiles []string
-		for _, file := range cachedFiles {
-			// Check if the file name contains the date string
-			if strings.Contains(file, dateStr) {
-				filteredFiles = append(filteredFiles, file)
-			}
-		}
-		return filteredFiles, nil
-	}
-
-	// Make an HTTP GET request to dayURL
-	resp, err := http.Get(monthURL)
-	if err != nil {
-		return nil, fmt.Errorf("failed to fetch URL %s: %v", monthURL, err)
-	}
-	defer resp.Body.Close()
-
-	// Check server response
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("bad status for %s: %s", monthURL, resp.Status)
-	}
-
-	// Read the response body
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response body from %s: %v", monthURL, err)
-	}
-
-	// Parse the HTML response to extract .gz file links
-	// Using a simple regex to find href attributes ending with .gz
-	re := regexp.MustCompile(`href="([^"]+\.bz2)`)
-	matches := re.FindAllStringSubmatch(string(body), -1)
-
-	// Extract file names from the matches
-	var files []string
-	for _, match := range matches {
-		if len(match) > 1 {
-			files = append(files, match[1])
-		}
-	}
-
-	// Cache the file list for this monthURL
-	fileCache[monthURL] = files
-
-	// Filter files based on the specified date
-	dateStr := date.Format("20060102") // Format date as YYYYMMDD
-	var filteredFiles []string
-	for _, file := range files {
-		// Check if the file name contains the date string
-		if strings.Contains(file, dateStr) {
-			filteredFiles = append(filteredFiles, file)
-		}
-	}
-
-	return filteredFiles, nil
+	return newSession(Options{Source: "routeviews"}).fetchFileList(monthURL, date, routeViewsFileRe)
 }
@@ -0,0 +1,322 @@
+package downloader
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Cadence returns the interval at which the source publishes dumps of the
+// given type.
+func Cadence(source string, t DumpType) time.Duration {
+	switch {
+	case source == "ripe" && t == DumpRIB:
+		return 8 * time.Hour
+	case source == "ripe" && t == DumpUpdates:
+		return 5 * time.Minute
+	case source == "routeviews" && t == DumpRIB:
+		return 2 * time.Hour
+	case source == "routeviews" && t == DumpUpdates:
+		return 15 * time.Minute
+	}
+	return 0
+}
+
+// StatusOptions selects the part of a local archive to inspect.
+type StatusOptions struct {
+	// Root is the output directory to scan.
+	Root string
+	// Layout is the layout of Root; the zero value selects DefaultLayout.
+	Layout Layout
+	// Source restricts the report to one source, and is assumed when the
+	// layout does not encode it.
+	Source string
+	// Collectors restricts the report to the given collectors. When empty,
+	// every collector found below Root is reported.
+	Collectors []string
+	// Types restricts the report to the given dump types; empty means all.
+	Types []DumpType
+	// Start and End bound the period checked, inclusive. A zero Start or
+	// End is replaced by the day of the oldest or newest local file.
+	Start, End time.Time
+	// Remote compares the local files with the upstream listing instead of
+	// the nominal cadence.
+	Remote bool
+}
+
+// CollectorStatus is the state of the local copy of one collector feed.
+type CollectorStatus struct {
+	Source    string
+	Collector string
+	Type      DumpType
+	Cadence   time.Duration
+	Start     time.Time
+	End       time.Time
+
+	// Expected is the number of dumps the period should hold, Present the
+	// number found locally.
+	Expected int
+	Present  int
+	// Gaps lists the missing dumps as contiguous intervals.
+	Gaps []Gap
+	// Missing holds the files that are available upstream but not locally.
+	// It is only filled in remote mode.
+	Missing []RemoteFile
+	// Upstream counts the dumps of the nominal cadence that the upstream
+	// archive does not have either. It is only filled in remote mode.
+	Upstream int
+	// Empty and Partial list zero-byte files and files that are incomplete
+	// or differ in size from the manifest.
+	Empty   []string
+	Partial []string
+}
+
+// Gap is a run of consecutive missing dumps.
+type Gap struct {
+	From  time.Time // first missing dump
+	To    time.Time // last missing dump
+	Files int
+}
+
+// Coverage returns the percentage of expected dumps present locally.
+func (c CollectorStatus) Coverage() float64 {
+	if c.Expected == 0 {
+		return 100
+	}
+	return float64(c.Present) * 100 / float64(c.Expected)
+}
+
+// statusKey identifies a collector feed
+type statusKey struct {
+	source    string
+	collector string
+	typ       DumpType
+}
+
+// localFile is a file found while scanning the archive
+type localFile struct {
+	path string
+	size int64
+}
+
+// Status scans a local archive and reports, per collector and dump type,
+// the dumps that are missing, empty or incomplete.
+func Status(opts StatusOptions) ([]CollectorStatus, error) {
+	if opts.Layout.IsZero() {
+		opts.Layout = DefaultLayout
+	}
+	types := opts.Types
+	if len(types) == 0 {
+		types = AllDumpTypes
+	}
+	wantCollector := func(c string) bool {
+		if len(opts.Collectors) == 0 {
+			return true
+		}
+		for _, w := range opts.Collectors {
+			if w == c {
+				return true
+			}
+		}
+		return false
+	}
+
+	m, err := OpenManifest(opts.Root)
+	if err != nil {
+		return nil, err
+	}
+
+	// Index the local files, including interrupted downloads
+	local := make(map[statusKey]map[time.Time]localFile)
+	var partial []struct {
+		key  statusKey
+		path string
+	}
+	err = filepath.Walk(opts.Root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(opts.Root, p)
+		if err != nil {
+			return err
+		}
+		isPart := strings.HasSuffix(rel, ".part")
+		info, ok := opts.Layout.Match(strings.TrimSuffix(rel, ".part"), opts.Source)
+		if !ok || (opts.Source != "" && info.Source != opts.Source) ||
+			!wantCollector(info.Collector) || !hasDumpType(types, info.DumpType) {
+			return nil
+		}
+		key := statusKey{info.Source, info.Collector, info.DumpType}
+		if isPart {
+			partial = append(partial, struct {
+				key  statusKey
+				path string
+			}{key, p})
+			return nil
+		}
+		if local[key] == nil {
+			local[key] = make(map[time.Time]localFile)
+		}
+		local[key][info.Time] = localFile{path: p, size: fi.Size()}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Collectors named explicitly are reported even without local files
+	if opts.Source != "" {
+		for _, c := range opts.Collectors {
+			for _, t := range types {
+				key := statusKey{opts.Source, c, t}
+				if local[key] == nil {
+					local[key] = make(map[time.Time]localFile)
+				}
+			}
+		}
+	}
+
+	var report []CollectorStatus
+	for key, files := range local {
+		st, err := collectorStatus(opts, m, key, files)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range partial {
+			if p.key == key {
+				st.Partial = append(st.Partial, p.path)
+			}
+		}
+		sort.Strings(st.Partial)
+		report = append(report, st)
+	}
+	sort.Slice(report, func(i, j int) bool {
+		a, b := report[i], report[j]
+		if a.Source != b.Source {
+			return a.Source < b.Source
+		}
+		if a.Collector != b.Collector {
+			return a.Collector < b.Collector
+		}
+		return a.Type < b.Type
+	})
+	return report, nil
+}
+
+// collectorStatus compares the local files of one feed with the dumps
+// expected for the period
+func collectorStatus(opts StatusOptions, m *Manifest, key statusKey, files map[time.Time]localFile) (CollectorStatus, error) {
+	st := CollectorStatus{
+		Source:    key.source,
+		Collector: key.collector,
+		Type:      key.typ,
+		Cadence:   Cadence(key.source, key.typ),
+		Start:     opts.Start,
+		End:       opts.End,
+	}
+
+	// Default the period to the days covered by the local files
+	for t := range files {
+		if opts.Start.IsZero() && (st.Start.IsZero() || t.Before(st.Start)) {
+			st.Start = t
+		}
+		if opts.End.IsZero() && t.After(st.End) {
+			st.End = t
+		}
+	}
+	if st.Start.IsZero() || st.End.IsZero() || st.Cadence == 0 {
+		return st, nil
+	}
+	st.Start = st.Start.Truncate(24 * time.Hour)
+	st.End = st.End.Truncate(24 * time.Hour).Add(24*time.Hour - time.Nanosecond)
+	if now := time.Now(); st.End.After(now) {
+		st.End = now
+	}
+
+	// Check the files that are present
+	bad := make(map[time.Time]bool)
+	for t, f := range files {
+		if f.size == 0 {
+			st.Empty = append(st.Empty, f.path)
+			bad[t] = true
+			continue
+		}
+		if rel, err := m.Rel(f.path); err == nil {
+			if e, ok := m.Get(rel); ok && e.Size != f.size {
+				st.Partial = append(st.Partial, f.path)
+				bad[t] = true
+			}
+		}
+	}
+	sort.Strings(st.Empty)
+	sort.Strings(st.Partial)
+
+	// Work out which dumps should exist
+	var expected []time.Time
+	remote := make(map[time.Time]RemoteFile)
+	if opts.Remote {
+		for day := st.Start; !day.After(st.End); day = day.AddDate(0, 0, 1) {
+			listed, err := ListFiles(key.source, key.collector, []DumpType{key.typ}, day)
+			if err != nil {
+				return st, err
+			}
+			for _, f := range listed {
+				if !f.Time.Before(st.Start) && !f.Time.After(st.End) {
+					remote[f.Time] = f
+				}
+			}
+		}
+		for t := range remote {
+			expected = append(expected, t)
+		}
+		sort.Slice(expected, func(i, j int) bool { return expected[i].Before(expected[j]) })
+		for t := st.Start; !t.After(st.End); t = t.Add(st.Cadence) {
+			if _, ok := remote[t]; !ok {
+				if _, ok := files[t]; !ok {
+					st.Upstream++
+				}
+			}
+		}
+	} else {
+		for t := st.Start; !t.After(st.End); t = t.Add(st.Cadence) {
+			expected = append(expected, t)
+		}
+	}
+
+	// Collect the missing dumps into gaps
+	st.Expected = len(expected)
+	var gap *Gap
+	for _, t := range expected {
+		if _, ok := files[t]; ok && !bad[t] {
+			st.Present++
+			gap = nil
+			continue
+		}
+		if r, ok := remote[t]; ok {
+			st.Missing = append(st.Missing, r)
+		}
+		if gap != nil {
+			gap.To = t
+			gap.Files++
+			continue
+		}
+		st.Gaps = append(st.Gaps, Gap{From: t, To: t, Files: 1})
+		gap = &st.Gaps[len(st.Gaps)-1]
+	}
+	return st, nil
+}
+
+// String formats the gap for reports.
+func (g Gap) String() string {
+	const layout = "2006-01-02 15:04"
+	if g.Files == 1 {
+		return fmt.Sprintf("%s (1 file)", g.From.Format(layout))
+	}
+	return fmt.Sprintf("%s .. %s (%d files)", g.From.Format(layout), g.To.Format(layout), g.Files)
+}
@@ -0,0 +1,408 @@
+package downloader
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"time"
+
+	"bgp_downloader/mrt"
+)
+
+// Feed is a collector of a source.
+type Feed struct {
+	Source    string
+	Collector string
+}
+
+// Feeds returns the feeds of the named collectors of the sources. The name
+// "all" selects every collector of the sources; other names are paired
+// with the source they belong to.
+func Feeds(sources, names []string) ([]Feed, error) {
+	var feeds []Feed
+	seen := make(map[Feed]bool)
+	add := func(f Feed) {
+		if !seen[f] {
+			seen[f] = true
+			feeds = append(feeds, f)
+		}
+	}
+	for _, src := range sources {
+		if _, ok := collectors[src]; !ok {
+			return nil, fmt.Errorf("invalid source: %s", src)
+		}
+	}
+	for _, name := range names {
+		found := false
+		for _, src := range sources {
+			for _, c := range collectors[src] {
+				if name == "all" || name == c {
+					add(Feed{Source: src, Collector: c})
+					found = true
+				}
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("invalid collector: %s", name)
+		}
+	}
+	return feeds, nil
+}
+
+// Record is an MRT record tagged with the feed and file it was read from.
+type Record struct {
+	mrt.Record
+	Feed
+	File string
+}
+
+// StreamOptions selects the data read by a Stream.
+type StreamOptions struct {
+	Feeds []Feed
+	// Types defaults to RIB dumps, as for Download.
+	Types []DumpType
+	// Start and End select the files whose dump time falls in the period,
+	// inclusive.
+	Start, End time.Time
+	Logger     *slog.Logger
+	Metrics    *Metrics
+}
+
+// Stream is an iterator over MRT records in timestamp order. NewStream
+// reads them straight from the archives, OpenFiles from downloaded files.
+// Use it as
+//
+//	for s.Next() {
+//		rec := s.Record()
+//		...
+//	}
+//	if err := s.Err(); err != nil {
+//		...
+//	}
+type Stream struct {
+	merge
+}
+
+// NewStream lists the files selected by opts and returns a stream over
+// their records. The files are fetched as the stream is read, one at a
+// time per feed and dump type.
+func NewStream(ctx context.Context, opts StreamOptions) (*Stream, error) {
+	if len(opts.Types) == 0 {
+		opts.Types = []DumpType{DumpRIB}
+	}
+	if opts.End.Before(opts.Start) {
+		return nil, fmt.Errorf("start time cannot be after end time")
+	}
+
+	var sources []recordSource
+	for _, feed := range opts.Feeds {
+		if !isValidCollector(feed.Source, feed.Collector) {
+			return nil, fmt.Errorf("invalid collector: %s", feed.Collector)
+		}
+		s := newSession(Options{
+			Source:    feed.Source,
+			Collector: feed.Collector,
+			Types:     opts.Types,
+			Logger:    opts.Logger,
+			Metrics:   opts.Metrics,
+		})
+		byType := make(map[DumpType][]RemoteFile)
+		for day := opts.Start.UTC().Truncate(24 * time.Hour); !day.After(opts.End); day = day.AddDate(0, 0, 1) {
+			listed, err := s.listDay(day)
+			if err != nil {
+				return nil, err
+			}
+			for _, f := range listed {
+				if !f.Time.Before(opts.Start) && !f.Time.After(opts.End) {
+					byType[f.DumpType] = append(byType[f.DumpType], f)
+				}
+			}
+		}
+		for _, t := range opts.Types {
+			files := byType[t]
+			sort.Slice(files, func(i, j int) bool { return files[i].Time.Before(files[j].Time) })
+			if len(files) > 0 {
+				sources = append(sources, &remoteSource{ctx: ctx, s: s, feed: feed, files: files})
+			}
+		}
+	}
+	return &Stream{merge{sources: sources}}, nil
+}
+
+// recordSource yields the records of a sequence of files in order
+type recordSource interface {
+	next() (Record, error)
+	close() error
+}
+
+// remoteSource reads remote files one after the other
+type remoteSource struct {
+	ctx   context.Context
+	s     *session
+	feed  Feed
+	files []RemoteFile
+
+	body   *remoteReader
+	reader *mrt.Reader
+	file   string
+}
+
+func (r *remoteSource) next() (Record, error) {
+	for {
+		if r.reader == nil {
+			if len(r.files) == 0 {
+				return Record{}, io.EOF
+			}
+			f := r.files[0]
+			r.files = r.files[1:]
+			r.body = &remoteReader{ctx: r.ctx, s: r.s, f: f, delay: firstRetryDelay}
+			dec, err := mrt.Decompress(r.body)
+			if err != nil {
+				r.body.Close()
+				return Record{}, fmt.Errorf("%s: %v", f.URL, err)
+			}
+			r.reader = mrt.NewReader(dec)
+			r.file = f.Name
+			r.s.log.Debug("stream opened", "collector", f.Collector, "file", f.Name)
+		}
+
+		rec, err := r.reader.Next()
+		var de *mrt.DecodeError
+		switch {
+		case err == nil:
+			return Record{Record: rec, Feed: r.feed, File: r.file}, nil
+		case errors.As(err, &de):
+			r.s.log.Warn("skipped record", "collector", r.feed.Collector, "file", r.file, "error", err)
+		case errors.Is(err, io.EOF):
+			r.close()
+		default:
+			r.close()
+			return Record{}, fmt.Errorf("%s: %v", r.file, err)
+		}
+	}
+}
+
+func (r *remoteSource) close() error {
+	r.reader = nil
+	if r.body == nil {
+		return nil
+	}
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
+
+// remoteReader reads a remote file, resuming the transfer where it broke
+// off after errors
+type remoteReader struct {
+	ctx     context.Context
+	s       *session
+	f       RemoteFile
+	body    io.ReadCloser
+	offset  int64
+	attempt int
+	delay   time.Duration
+}
+
+func (r *remoteReader) Read(p []byte) (int, error) {
+	for {
+		if r.body == nil {
+			if err := r.open(); err != nil {
+				if r.retry(err) {
+					continue
+				}
+				return 0, err
+			}
+		}
+		n, err := r.body.Read(p)
+		r.offset += int64(n)
+		if n > 0 {
+			// The transfer is making progress: give later errors the full
+			// number of attempts again
+			r.attempt, r.delay = 0, firstRetryDelay
+		}
+		if err == nil || errors.Is(err, io.EOF) {
+			return n, err
+		}
+		r.body.Close()
+		r.body = nil
+		if n > 0 {
+			// Hand out what was read; the error is retried on the next call
+			return n, nil
+		}
+		if !r.retry(err) {
+			return 0, err
+		}
+	}
+}
+
+// open requests the file from the current offset
+func (r *remoteReader) open() error {
+	req, err := http.NewRequestWithContext(r.ctx, http.MethodGet, r.f.URL, nil)
+	if err != nil {
+		return err
+	}
+	if r.offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", r.offset))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && r.offset > 0:
+	case resp.StatusCode == http.StatusOK:
+		// The server ignored the range; skip what was already read
+		if _, err := io.CopyN(io.Discard, resp.Body, r.offset); err != nil {
+			resp.Body.Close()
+			return err
+		}
+	default:
+		resp.Body.Close()
+		return &statusError{code: resp.StatusCode, status: resp.Status}
+	}
+	r.body = resp.Body
+	return nil
+}
+
+// retry waits before the next attempt, or reports false when the error is
+// final
+func (r *remoteReader) retry(err error) bool {
+	log := r.s.log.With("collector", r.f.Collector, "file", r.f.Name)
+	r.attempt++
+	if r.ctx.Err() != nil {
+		return false
+	}
+	if r.attempt == maxRetries || isClientError(err) {
+		log.Error("stream failed", "url", r.f.URL, "attempt", r.attempt, "error", err)
+		r.s.Metrics.failed(r.f.FileInfo, err)
+		return false
+	}
+	log.Warn("stream retry", "url", r.f.URL, "attempt", r.attempt, "offset", r.offset, "error", err, "delay", r.delay)
+	r.s.Metrics.retried(r.f.FileInfo)
+	select {
+	case <-time.After(r.delay):
+	case <-r.ctx.Done():
+		return false
+	}
+	r.delay *= 2 // Exponential backoff
+	return true
+}
+
+func (r *remoteReader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
+
+// merge interleaves the records of several sources by timestamp
+type merge struct {
+	sources []recordSource
+	started bool
+	heap    recordHeap
+	cur     Record
+	err     error
+}
+
+// Next advances to the next record and reports whether there is one.
+func (m *merge) Next() bool {
+	if m.err != nil {
+		return false
+	}
+	if !m.started {
+		m.started = true
+		for i, src := range m.sources {
+			if !m.fill(i, src) {
+				return false
+			}
+		}
+		heap.Init(&m.heap)
+	} else if len(m.heap) > 0 {
+		// Replace the record handed out last with the next of its source
+		top := m.heap[0]
+		rec, err := m.sources[top.source].next()
+		switch {
+		case err == nil:
+			m.heap[0].rec = rec
+			heap.Fix(&m.heap, 0)
+		case errors.Is(err, io.EOF):
+			heap.Pop(&m.heap)
+		default:
+			m.err = err
+			return false
+		}
+	}
+	if len(m.heap) == 0 {
+		return false
+	}
+	m.cur = m.heap[0].rec
+	return true
+}
+
+// fill reads the first record of a source into the heap
+func (m *merge) fill(i int, src recordSource) bool {
+	rec, err := src.next()
+	switch {
+	case err == nil:
+		m.heap = append(m.heap, heapItem{rec: rec, source: i})
+	case !errors.Is(err, io.EOF):
+		m.err = err
+		return false
+	}
+	return true
+}
+
+// Record returns the current record.
+func (m *merge) Record() Record {
+	return m.cur
+}
+
+// Err returns the error that ended the iteration, if any.
+func (m *merge) Err() error {
+	return m.err
+}
+
+// Close releases the files and connections held by the iterator.
+func (m *merge) Close() error {
+	var first error
+	for _, src := range m.sources {
+		if err := src.close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// heapItem is the pending record of a source
+type heapItem struct {
+	rec    Record
+	source int
+}
+
+// recordHeap orders pending records by timestamp, then source
+type recordHeap []heapItem
+
+func (h recordHeap) Len() int { return len(h) }
+func (h recordHeap) Less(i, j int) bool {
+	ti, tj := h[i].rec.Header().Timestamp, h[j].rec.Header().Timestamp
+	if !ti.Equal(tj) {
+		return ti.Before(tj)
+	}
+	return h[i].source < h[j].source
+}
+func (h recordHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *recordHeap) Push(x interface{}) { *h = append(*h, x.(heapItem)) }
+func (h *recordHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
@@ -0,0 +1,318 @@
+// Package filter selects the elements of MRT records by prefix, AS, peer,
+// community, element type and address family. Checks that need little
+// decoding run first, so that records which cannot match are skipped
+// before their attributes are decoded.
+package filter
+
+import (
+	"fmt"
+	"net/netip"
+	"regexp"
+
+	"bgp_downloader/mrt"
+)
+
+// PrefixMatch tells how Filter.Prefixes are compared with the prefix of an
+// element.
+type PrefixMatch int
+
+const (
+	// MatchExact matches the prefix itself.
+	MatchExact PrefixMatch = iota
+	// MatchMoreSpecific matches the prefix and the prefixes it covers.
+	MatchMoreSpecific
+	// MatchLessSpecific matches the prefix and the prefixes covering it.
+	MatchLessSpecific
+	// MatchAny matches both more and less specific prefixes.
+	MatchAny
+)
+
+// Filter selects elements. Every non-empty criterion must be met; a list
+// is met when any of its values matches. The zero Filter matches
+// everything.
+//
+// The attribute criteria OriginASNs, ASPath and Communities are only met
+// by elements with attributes, that is RIB entries and announcements.
+type Filter struct {
+	Prefixes    []netip.Prefix
+	PrefixMatch PrefixMatch
+	OriginASNs  []uint32
+	ASPath      *regexp.Regexp
+	PeerASNs    []uint32
+	PeerIPs     []netip.Addr
+	Communities []Community
+	Types       []mrt.ElemType
+	// AFI restricts the prefixes of elements to one family; state changes
+	// are not affected. Zero allows both.
+	AFI mrt.AFI
+}
+
+// Elements returns the elements of rec that match the filter, as
+// mrt.Elements would return them.
+func (f *Filter) Elements(rec mrt.Record) ([]mrt.Element, error) {
+	switch r := rec.(type) {
+	case *mrt.RIB:
+		entries, attrs, err := f.ribEntries(r)
+		elems := make([]mrt.Element, len(entries))
+		for i, e := range entries {
+			elems[i] = mrt.Element{
+				Type:       mrt.ElemRIB,
+				Time:       r.Header().Timestamp,
+				PeerIP:     e.Peer.IP,
+				PeerAS:     e.Peer.AS,
+				Prefix:     r.Prefix,
+				PathID:     e.PathID,
+				Originated: e.Originated,
+				Attributes: attrs[i],
+			}
+		}
+		return elems, err
+
+	case *mrt.BGP4MPMessage:
+		if !f.hasType(mrt.ElemAnnounce) && !f.hasType(mrt.ElemWithdraw) {
+			return nil, nil
+		}
+		if !f.matchPeer(r.PeerIP, r.PeerAS) {
+			return nil, nil
+		}
+		if ok, err := f.matchUpdatePrefixes(r); !ok {
+			return nil, err
+		}
+
+	case *mrt.BGP4MPStateChange:
+		if !f.hasType(mrt.ElemState) || !f.matchPeer(r.PeerIP, r.PeerAS) {
+			return nil, nil
+		}
+	}
+
+	elems, err := mrt.Elements(rec)
+	if err != nil {
+		return nil, err
+	}
+	matched := elems[:0]
+	for _, e := range elems {
+		if f.Match(e) {
+			matched = append(matched, e)
+		}
+	}
+	return matched, nil
+}
+
+// Record returns the part of rec that matches the filter, for writing
+// extracts in MRT format: a RIB record with only its matching entries, or a
+// BGP4MP record if any of its elements matches. It returns nil when nothing
+// matches. PEER_INDEX_TABLE records are kept; other records without
+// elements are only kept by the zero Filter.
+func (f *Filter) Record(rec mrt.Record) (mrt.Record, error) {
+	if f.IsZero() {
+		return rec, nil
+	}
+	switch r := rec.(type) {
+	case *mrt.PeerIndexTable:
+		return r, nil
+
+	case *mrt.RIB:
+		entries, _, err := f.ribEntries(r)
+		if err != nil || len(entries) == 0 {
+			return nil, err
+		}
+		if len(entries) == len(r.Entries) {
+			return r, nil
+		}
+		sub := *r
+		sub.Entries = entries
+		return &sub, nil
+
+	case *mrt.BGP4MPMessage, *mrt.BGP4MPStateChange:
+		elems, err := f.Elements(rec)
+		if err != nil || len(elems) == 0 {
+			return nil, err
+		}
+		return rec, nil
+	}
+	return nil, nil
+}
+
+// IsZero reports whether the filter has no criteria.
+func (f *Filter) IsZero() bool {
+	return len(f.Prefixes) == 0 && len(f.OriginASNs) == 0 && f.ASPath == nil &&
+		len(f.PeerASNs) == 0 && len(f.PeerIPs) == 0 && len(f.Communities) == 0 &&
+		len(f.Types) == 0 && f.AFI == 0
+}
+
+// ribEntries returns the matching entries of a RIB record with their
+// decoded attributes
+func (f *Filter) ribEntries(r *mrt.RIB) ([]mrt.RIBEntry, []*mrt.Attributes, error) {
+	if !f.hasType(mrt.ElemRIB) || !f.matchPrefix(r.Prefix) {
+		return nil, nil, nil
+	}
+	var entries []mrt.RIBEntry
+	var attrs []*mrt.Attributes
+	for _, e := range r.Entries {
+		if !f.matchPeer(e.Peer.IP, e.Peer.AS) {
+			continue
+		}
+		a, err := e.Attributes.Decode()
+		if err != nil {
+			return entries, attrs, fmt.Errorf("%s from %s: %v", r.Prefix, e.Peer.IP, err)
+		}
+		if !f.matchAttributes(a) {
+			continue
+		}
+		entries = append(entries, e)
+		attrs = append(attrs, a)
+	}
+	return entries, attrs, nil
+}
+
+// matchUpdatePrefixes reports whether a message may have elements of the
+// prefixes and families of the filter, from its routes alone, before the
+// attributes other than MP_REACH_NLRI and MP_UNREACH_NLRI are decoded
+func (f *Filter) matchUpdatePrefixes(m *mrt.BGP4MPMessage) (bool, error) {
+	if len(f.Prefixes) == 0 && f.AFI == 0 {
+		return true, nil
+	}
+	if m.Type() != mrt.MsgUpdate || m.Local {
+		return false, nil
+	}
+	u, err := m.Update()
+	if err != nil {
+		return false, err
+	}
+	announced, withdrawn, err := u.Prefixes()
+	if err != nil {
+		return false, err
+	}
+	return f.hasType(mrt.ElemAnnounce) && f.matchAnyPrefix(announced) ||
+		f.hasType(mrt.ElemWithdraw) && f.matchAnyPrefix(withdrawn), nil
+}
+
+func (f *Filter) matchAnyPrefix(routes []mrt.NLRI) bool {
+	for _, n := range routes {
+		if f.matchPrefix(n.Prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Match reports whether the element meets the filter.
+func (f *Filter) Match(e mrt.Element) bool {
+	if !f.hasType(e.Type) || !f.matchPeer(e.PeerIP, e.PeerAS) {
+		return false
+	}
+	if e.Type == mrt.ElemState {
+		return len(f.Prefixes) == 0 && !f.hasAttributeCriteria()
+	}
+	if !f.matchPrefix(e.Prefix) {
+		return false
+	}
+	if e.Attributes == nil {
+		return !f.hasAttributeCriteria()
+	}
+	return f.matchAttributes(e.Attributes)
+}
+
+func (f *Filter) hasType(t mrt.ElemType) bool {
+	if len(f.Types) == 0 {
+		return true
+	}
+	for _, want := range f.Types {
+		if want == t {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *Filter) hasAttributeCriteria() bool {
+	return len(f.OriginASNs) > 0 || f.ASPath != nil || len(f.Communities) > 0
+}
+
+func (f *Filter) matchPeer(ip netip.Addr, as uint32) bool {
+	if len(f.PeerASNs) > 0 && !containsASN(f.PeerASNs, as) {
+		return false
+	}
+	if len(f.PeerIPs) == 0 {
+		return true
+	}
+	for _, want := range f.PeerIPs {
+		if want == ip {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *Filter) matchPrefix(p netip.Prefix) bool {
+	switch {
+	case f.AFI == mrt.AFIIPv4 && !p.Addr().Is4():
+		return false
+	case f.AFI == mrt.AFIIPv6 && !p.Addr().Is6():
+		return false
+	case len(f.Prefixes) == 0:
+		return true
+	}
+	for _, want := range f.Prefixes {
+		if want.Addr().BitLen() != p.Addr().BitLen() {
+			continue
+		}
+		more := want.Bits() <= p.Bits() && want.Contains(p.Addr())
+		less := p.Bits() <= want.Bits() && p.Contains(want.Addr())
+		switch f.PrefixMatch {
+		case MatchExact:
+			if want == p {
+				return true
+			}
+		case MatchMoreSpecific:
+			if more {
+				return true
+			}
+		case MatchLessSpecific:
+			if less {
+				return true
+			}
+		case MatchAny:
+			if more || less {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (f *Filter) matchAttributes(a *mrt.Attributes) bool {
+	if len(f.OriginASNs) > 0 {
+		found := false
+		for _, asn := range a.ASPath.Origins() {
+			if containsASN(f.OriginASNs, asn) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.ASPath != nil && !f.ASPath.MatchString(a.ASPath.String()) {
+		return false
+	}
+	if len(f.Communities) > 0 {
+		for _, c := range f.Communities {
+			if c.match(a) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func containsASN(asns []uint32, asn uint32) bool {
+	for _, a := range asns {
+		if a == asn {
+			return true
+		}
+	}
+	return false
+}
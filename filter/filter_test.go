@@ -0,0 +1,49 @@
+package filter
+
+import (
+	"bytes"
+	"net/netip"
+	"testing"
+
+	"bgp_downloader/internal/mrttest"
+	"bgp_downloader/mrt"
+)
+
+func decode(t *testing.T, data []byte) mrt.Record {
+	rec, err := mrt.NewReader(bytes.NewReader(data)).Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rec
+}
+
+func TestPrefixesBeforeAttributes(t *testing.T) {
+	path := mrttest.Attrs(mrttest.ASPath4(64500, 64600))
+	// A COMMUNITIES attribute of the wrong length fails the decoding
+	broken := decode(t, mrttest.Message(1000, "198.51.100.1", 64500, mrttest.Update(
+		nil, mrttest.Cat(path, mrttest.Attr(0xc0, mrt.AttrCommunities, []byte{1, 2, 3})), mrttest.Prefixes("192.0.2.0/24"))))
+	mpReach := mrttest.Cat(mrttest.U16(2), []byte{1, 16}, mrttest.IP("2001:db8::1"), []byte{0}, mrttest.Prefixes("2001:db8:1::/48"))
+	ipv6 := decode(t, mrttest.Message(1000, "2001:db8::2", 64500, mrttest.Update(
+		nil, mrttest.Cat(path, mrttest.Attr(0x80, mrt.AttrMPReach, mpReach)), nil)))
+
+	tests := []struct {
+		name    string
+		filter  Filter
+		rec     mrt.Record
+		want    int
+		wantErr bool
+	}{
+		{"other prefix", Filter{Prefixes: []netip.Prefix{netip.MustParsePrefix("203.0.113.0/24")}}, broken, 0, false},
+		{"other family", Filter{AFI: mrt.AFIIPv6}, broken, 0, false},
+		{"withdrawals only", Filter{Prefixes: []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}, Types: []mrt.ElemType{mrt.ElemWithdraw}}, broken, 0, false},
+		{"matching prefix", Filter{Prefixes: []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}}, broken, 0, true},
+		{"MP_REACH_NLRI", Filter{Prefixes: []netip.Prefix{netip.MustParsePrefix("2001:db8::/32")}, PrefixMatch: MatchMoreSpecific}, ipv6, 1, false},
+		{"MP_REACH_NLRI of another family", Filter{AFI: mrt.AFIIPv4}, ipv6, 0, false},
+	}
+	for _, tt := range tests {
+		elems, err := tt.filter.Elements(tt.rec)
+		if (err != nil) != tt.wantErr || len(elems) != tt.want {
+			t.Errorf("%s: got %d elements, error %v", tt.name, len(elems), err)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package filter
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"bgp_downloader/mrt"
+)
+
+// ParsePrefixMatch parses "exact", "more", "less" or "any".
+func ParsePrefixMatch(s string) (PrefixMatch, error) {
+	switch strings.ToLower(s) {
+	case "exact":
+		return MatchExact, nil
+	case "more", "more-specific":
+		return MatchMoreSpecific, nil
+	case "less", "less-specific":
+		return MatchLessSpecific, nil
+	case "any":
+		return MatchAny, nil
+	}
+	return 0, fmt.Errorf("invalid prefix match: %s", s)
+}
+
+// ParseType parses an element type: "rib", "announce", "withdraw" or
+// "state".
+func ParseType(s string) (mrt.ElemType, error) {
+	for _, t := range []mrt.ElemType{mrt.ElemRIB, mrt.ElemAnnounce, mrt.ElemWithdraw, mrt.ElemState} {
+		if strings.EqualFold(s, t.String()) {
+			return t, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid element type: %s", s)
+}
+
+// ParseAFI parses "ipv4" or "ipv6"; the empty string selects both.
+func ParseAFI(s string) (mrt.AFI, error) {
+	switch strings.ToLower(s) {
+	case "":
+		return 0, nil
+	case "ipv4", "4":
+		return mrt.AFIIPv4, nil
+	case "ipv6", "6":
+		return mrt.AFIIPv6, nil
+	}
+	return 0, fmt.Errorf("invalid address family: %s", s)
+}
+
+// ParseASN parses an AS number, with or without an "AS" prefix.
+func ParseASN(s string) (uint32, error) {
+	s = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(s)), "AS")
+	n, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid AS number: %s", s)
+	}
+	return uint32(n), nil
+}
+
+// asPathBoundary is what "_" stands for in AS path expressions
+const asPathBoundary = `(?:^|$|[ {}(),\[\]])`
+
+// CompileASPath compiles a regular expression matched against AS paths in
+// the form "64500 64501 {64502,64503}". As in router configurations, "_"
+// matches the start or end of the path or the space between two ASes, so
+// "_13335$" selects paths originated by AS13335.
+func CompileASPath(expr string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(strings.ReplaceAll(expr, "_", asPathBoundary))
+	if err != nil {
+		return nil, fmt.Errorf("invalid AS path expression: %v", err)
+	}
+	return re, nil
+}
+
+// Community matches a standard ("AS:value") or large ("global:local1:local2")
+// community. Any part may be "*" to match every value.
+type Community struct {
+	parts []int64 // -1 for "*"
+}
+
+// ParseCommunity parses a community pattern.
+func ParseCommunity(s string) (Community, error) {
+	fields := strings.Split(s, ":")
+	if len(fields) != 2 && len(fields) != 3 {
+		return Community{}, fmt.Errorf("invalid community: %s", s)
+	}
+	c := Community{parts: make([]int64, len(fields))}
+	for i, f := range fields {
+		if f == "*" {
+			c.parts[i] = -1
+			continue
+		}
+		bits := 32
+		if len(fields) == 2 {
+			bits = 16
+		}
+		n, err := strconv.ParseUint(f, 10, bits)
+		if err != nil {
+			return Community{}, fmt.Errorf("invalid community: %s", s)
+		}
+		c.parts[i] = int64(n)
+	}
+	return c, nil
+}
+
+// String returns the pattern as parsed.
+func (c Community) String() string {
+	fields := make([]string, len(c.parts))
+	for i, p := range c.parts {
+		if p < 0 {
+			fields[i] = "*"
+		} else {
+			fields[i] = strconv.FormatInt(p, 10)
+		}
+	}
+	return strings.Join(fields, ":")
+}
+
+// match reports whether the attributes carry a matching community
+func (c Community) match(a *mrt.Attributes) bool {
+	if len(c.parts) == 2 {
+		for _, comm := range a.Communities {
+			if c.matchParts(uint32(comm)>>16, uint32(comm)&0xffff) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, lc := range a.LargeCommunities {
+		if c.matchParts(lc.Global, lc.Local1, lc.Local2) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c Community) matchParts(values ...uint32) bool {
+	for i, v := range values {
+		if c.parts[i] >= 0 && c.parts[i] != int64(v) {
+			return false
+		}
+	}
+	return true
+}
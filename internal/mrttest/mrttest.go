@@ -0,0 +1,167 @@
+// Package mrttest builds MRT records byte by byte for tests, independently
+// of mrt.Writer, so that decoders are checked against the RFC layouts.
+package mrttest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// U16 encodes v as a big-endian 16-bit field.
+func U16(v int) []byte { return binary.BigEndian.AppendUint16(nil, uint16(v)) }
+
+// U32 encodes v as a big-endian 32-bit field.
+func U32(v uint32) []byte { return binary.BigEndian.AppendUint32(nil, v) }
+
+// Cat concatenates fields.
+func Cat(bs ...[]byte) []byte {
+	var b []byte
+	for _, v := range bs {
+		b = append(b, v...)
+	}
+	return b
+}
+
+// IP encodes an address as 4 or 16 bytes.
+func IP(s string) []byte { return netip.MustParseAddr(s).AsSlice() }
+
+// Prefix encodes a prefix as its length followed by the significant bytes
+// of its address, as in NLRI and RIB records.
+func Prefix(s string) []byte {
+	p := netip.MustParsePrefix(s)
+	return append([]byte{byte(p.Bits())}, p.Addr().AsSlice()[:(p.Bits()+7)/8]...)
+}
+
+// Record frames a record body with an MRT header.
+func Record(ts uint32, typ, subtype int, body []byte) []byte {
+	return Cat(U32(ts), U16(typ), U16(subtype), U32(uint32(len(body))), body)
+}
+
+// Attr encodes a path attribute with a one-byte length.
+func Attr(flags, code int, v []byte) []byte {
+	return Cat([]byte{byte(flags), byte(code), byte(len(v))}, v)
+}
+
+// ASPath4 encodes an AS_SEQUENCE segment of 4-byte ASes.
+func ASPath4(asns ...uint32) []byte {
+	b := []byte{2, byte(len(asns))}
+	for _, asn := range asns {
+		b = append(b, U32(asn)...)
+	}
+	return b
+}
+
+// ASSet4 encodes an AS_SET segment of 4-byte ASes.
+func ASSet4(asns ...uint32) []byte {
+	b := []byte{1, byte(len(asns))}
+	for _, asn := range asns {
+		b = append(b, U32(asn)...)
+	}
+	return b
+}
+
+// ASPath2 encodes an AS_SEQUENCE segment of 2-byte ASes.
+func ASPath2(asns ...uint32) []byte {
+	b := []byte{2, byte(len(asns))}
+	for _, asn := range asns {
+		b = append(b, U16(int(asn))...)
+	}
+	return b
+}
+
+// Update encodes a BGP UPDATE message with its marker and header.
+func Update(withdrawn, attrs, nlri []byte) []byte {
+	body := Cat(U16(len(withdrawn)), withdrawn, U16(len(attrs)), attrs, nlri)
+	return Cat(bytes.Repeat([]byte{0xff}, 16), U16(19+len(body)), []byte{2}, body)
+}
+
+// Attrs encodes an ORIGIN of IGP and an AS_PATH of 4-byte segments.
+func Attrs(segments ...[]byte) []byte {
+	return Cat(Attr(0x40, 1, []byte{0}), Attr(0x40, 2, Cat(segments...)))
+}
+
+// Prefixes encodes a list of prefixes as in the NLRI and withdrawn routes
+// of an UPDATE.
+func Prefixes(list ...string) []byte {
+	var b []byte
+	for _, p := range list {
+		b = append(b, Prefix(p)...)
+	}
+	return b
+}
+
+// Peer is a peer of a PEER_INDEX_TABLE.
+type Peer struct {
+	IP string
+	AS uint32
+}
+
+// PeerIndex encodes a PEER_INDEX_TABLE record listing peers with 4-byte
+// ASes.
+func PeerIndex(ts uint32, peers ...Peer) []byte {
+	b := Cat(IP("192.0.2.1"), U16(0), U16(len(peers)))
+	for _, p := range peers {
+		typ := byte(2)
+		if netip.MustParseAddr(p.IP).Is6() {
+			typ |= 1
+		}
+		b = Cat(b, []byte{typ}, IP("10.0.0.1"), IP(p.IP), U32(p.AS))
+	}
+	return Record(ts, 13, 1, b)
+}
+
+// Route is a RIB entry: the index of its peer in the PEER_INDEX_TABLE, the
+// time it was learned and its path attributes.
+type Route struct {
+	Peer       int
+	Originated uint32
+	Attrs      []byte
+}
+
+// RIB encodes a RIB_IPV4_UNICAST or RIB_IPV6_UNICAST record.
+func RIB(ts, seq uint32, prefix string, routes ...Route) []byte {
+	subtype := 2
+	if netip.MustParsePrefix(prefix).Addr().Is6() {
+		subtype = 4
+	}
+	b := Cat(U32(seq), Prefix(prefix), U16(len(routes)))
+	for _, r := range routes {
+		b = Cat(b, U16(r.Peer), U32(r.Originated), U16(len(r.Attrs)), r.Attrs)
+	}
+	return Record(ts, 13, subtype, b)
+}
+
+// bgp4mpHeader encodes the session fields of a BGP4MP_*_AS4 record
+func bgp4mpHeader(peer string, peerAS uint32) []byte {
+	afi, local := 1, "10.0.0.1"
+	if netip.MustParseAddr(peer).Is6() {
+		afi, local = 2, "2001:db8::1"
+	}
+	return Cat(U32(peerAS), U32(12654), U16(0), U16(afi), IP(peer), IP(local))
+}
+
+// Message encodes a BGP4MP_MESSAGE_AS4 record of a message received from a
+// peer.
+func Message(ts uint32, peer string, peerAS uint32, msg []byte) []byte {
+	return Record(ts, 16, 4, Cat(bgp4mpHeader(peer, peerAS), msg))
+}
+
+// StateChange encodes a BGP4MP_STATE_CHANGE_AS4 record.
+func StateChange(ts uint32, peer string, peerAS uint32, old, new int) []byte {
+	return Record(ts, 16, 5, Cat(bgp4mpHeader(peer, peerAS), U16(old), U16(new)))
+}
+
+// WriteFile writes records to a file in a temporary directory of the test
+// and returns its path.
+func WriteFile(t testing.TB, data ...[]byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "dump")
+	if err := os.WriteFile(path, Cat(data...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
@@ -4,11 +4,13 @@ import (
 	"bgp_downloader/cmd"
 	"fmt"
 	"log"
+	"os"
 )
 
 func main() {
 	if err := cmd.Execute(); err != nil {
 		log.Fatal(err)
 	}
-	fmt.Println("BGP Downloader finished successfully.")
+	// Keep stdout clean for commands that write data to it
+	fmt.Fprintln(os.Stderr, "BGP Downloader finished successfully.")
 }
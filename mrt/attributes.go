@@ -0,0 +1,590 @@
+package mrt
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// AFI is a BGP address family identifier.
+type AFI uint16
+
+// SAFI is a BGP subsequent address family identifier.
+type SAFI uint8
+
+const (
+	AFIIPv4 AFI = 1
+	AFIIPv6 AFI = 2
+
+	SAFIUnicast   SAFI = 1
+	SAFIMulticast SAFI = 2
+)
+
+// String returns "ipv4", "ipv6" or the number of an unknown family.
+func (a AFI) String() string {
+	switch a {
+	case AFIIPv4:
+		return "ipv4"
+	case AFIIPv6:
+		return "ipv6"
+	}
+	return strconv.Itoa(int(a))
+}
+
+// NLRI is a prefix carried in a BGP message, with its ADD-PATH path
+// identifier.
+type NLRI struct {
+	Prefix netip.Prefix
+	PathID uint32
+}
+
+// ComparePrefix orders prefixes by address, then length.
+func ComparePrefix(a, b netip.Prefix) int {
+	if c := a.Addr().Compare(b.Addr()); c != 0 {
+		return c
+	}
+	return a.Bits() - b.Bits()
+}
+
+// decodePrefix reads a length-prefixed, truncated prefix of the family
+func decodePrefix(d *decoder, afi AFI) (netip.Prefix, error) {
+	bits := int(d.u8())
+	size := 4
+	if afi == AFIIPv6 {
+		size = 16
+	} else if afi != AFIIPv4 {
+		return netip.Prefix{}, fmt.Errorf("unsupported AFI %d", afi)
+	}
+	if bits > size*8 {
+		return netip.Prefix{}, fmt.Errorf("invalid prefix length %d", bits)
+	}
+	b := d.bytes((bits + 7) / 8)
+	if d.err != nil {
+		return netip.Prefix{}, d.err
+	}
+	var buf [16]byte
+	copy(buf[:], b)
+	var addr netip.Addr
+	if afi == AFIIPv6 {
+		addr = netip.AddrFrom16(buf)
+	} else {
+		addr = netip.AddrFrom4([4]byte(buf[:4]))
+	}
+	return addr.Prefix(bits)
+}
+
+// decodeNLRI reads prefixes up to the end of b
+func decodeNLRI(b []byte, afi AFI, addPath bool) ([]NLRI, error) {
+	var nlri []NLRI
+	d := &decoder{b: b}
+	for len(d.b) > 0 {
+		var n NLRI
+		if addPath {
+			n.PathID = d.u32()
+		}
+		p, err := decodePrefix(d, afi)
+		if err != nil {
+			return nlri, err
+		}
+		n.Prefix = p
+		nlri = append(nlri, n)
+	}
+	return nlri, nil
+}
+
+// Path attribute type codes
+const (
+	AttrOrigin          = 1
+	AttrASPath          = 2
+	AttrNextHop         = 3
+	AttrMED             = 4
+	AttrLocalPref       = 5
+	AttrAtomicAggregate = 6
+	AttrAggregator      = 7
+	AttrCommunities     = 8
+	AttrMPReach         = 14
+	AttrMPUnreach       = 15
+	AttrAS4Path         = 17
+	AttrAS4Aggregator   = 18
+	AttrLargeCommunity  = 32
+)
+
+// asTrans is the placeholder for 4-byte AS numbers in 2-byte AS paths
+const asTrans = 23456
+
+// PathAttributes holds the encoded path attributes of a route. Decoding
+// them is comparatively costly, so it is only done on request.
+type PathAttributes struct {
+	raw     []byte
+	as4     bool // AS numbers are 4 bytes long
+	rib     bool // MP_REACH_NLRI is abbreviated as in TABLE_DUMP_V2
+	addPath bool // MP NLRI carry path identifiers
+}
+
+// Bytes returns the encoded attributes.
+func (a PathAttributes) Bytes() []byte {
+	return a.raw
+}
+
+// Decode decodes the attributes.
+func (a PathAttributes) Decode() (*Attributes, error) {
+	return decodeAttributes(a)
+}
+
+// Origin is the value of the ORIGIN attribute.
+type Origin uint8
+
+const (
+	OriginIGP        Origin = 0
+	OriginEGP        Origin = 1
+	OriginIncomplete Origin = 2
+)
+
+// String returns the name of the origin as printed by bgpdump.
+func (o Origin) String() string {
+	switch o {
+	case OriginIGP:
+		return "IGP"
+	case OriginEGP:
+		return "EGP"
+	case OriginIncomplete:
+		return "INCOMPLETE"
+	}
+	return strconv.Itoa(int(o))
+}
+
+// AS path segment types
+const (
+	ASSet            = 1
+	ASSequence       = 2
+	ASConfedSequence = 3
+	ASConfedSet      = 4
+)
+
+// ASPathSegment is a segment of an AS path.
+type ASPathSegment struct {
+	Type uint8
+	ASNs []uint32
+}
+
+// ASPath is a BGP AS path.
+type ASPath []ASPathSegment
+
+// String formats the path as bgpdump does, with sets in braces.
+func (p ASPath) String() string {
+	var b strings.Builder
+	for _, seg := range p {
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		asns := make([]string, len(seg.ASNs))
+		for i, asn := range seg.ASNs {
+			asns[i] = strconv.FormatUint(uint64(asn), 10)
+		}
+		switch seg.Type {
+		case ASSet:
+			b.WriteString("{" + strings.Join(asns, ",") + "}")
+		case ASConfedSequence:
+			b.WriteString("(" + strings.Join(asns, " ") + ")")
+		case ASConfedSet:
+			b.WriteString("[" + strings.Join(asns, ",") + "]")
+		default:
+			b.WriteString(strings.Join(asns, " "))
+		}
+	}
+	return b.String()
+}
+
+// Origins returns the origin AS of the path, or the members of the AS set
+// ending the path.
+func (p ASPath) Origins() []uint32 {
+	for i := len(p) - 1; i >= 0; i-- {
+		seg := p[i]
+		if len(seg.ASNs) == 0 || seg.Type == ASConfedSequence || seg.Type == ASConfedSet {
+			continue
+		}
+		if seg.Type == ASSet {
+			return seg.ASNs
+		}
+		return seg.ASNs[len(seg.ASNs)-1:]
+	}
+	return nil
+}
+
+// OriginString formats the origin of the path with FormatOrigin. It is
+// empty if the path has no origin.
+func (p ASPath) OriginString() string {
+	return FormatOrigin(p.Origins())
+}
+
+// FormatOrigin formats an origin AS, or the members of an AS set in
+// numeric order separated by commas.
+func FormatOrigin(origins []uint32) string {
+	if len(origins) == 1 {
+		return strconv.FormatUint(uint64(origins[0]), 10)
+	}
+	sorted := append([]uint32(nil), origins...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	asns := make([]string, len(sorted))
+	for i, asn := range sorted {
+		asns[i] = strconv.FormatUint(uint64(asn), 10)
+	}
+	return strings.Join(asns, ",")
+}
+
+// length counts the ASes of the path for RFC 6793 AS4_PATH merging
+func (p ASPath) length() int {
+	n := 0
+	for _, seg := range p {
+		switch seg.Type {
+		case ASSequence:
+			n += len(seg.ASNs)
+		case ASSet:
+			n++
+		}
+	}
+	return n
+}
+
+// mergeAS4Path reconstructs the 4-byte AS path from an AS_PATH with 2-byte
+// AS numbers and the AS4_PATH attribute (RFC 6793, section 4.2.3)
+func mergeAS4Path(path, as4 ASPath) ASPath {
+	keep := path.length() - as4.length()
+	if keep < 0 {
+		return path
+	}
+	var merged ASPath
+	for _, seg := range path {
+		if keep == 0 {
+			break
+		}
+		switch seg.Type {
+		case ASSequence:
+			n := len(seg.ASNs)
+			if n > keep {
+				n = keep
+			}
+			merged = append(merged, ASPathSegment{Type: seg.Type, ASNs: seg.ASNs[:n]})
+			keep -= n
+		case ASSet:
+			merged = append(merged, seg)
+			keep--
+		default:
+			merged = append(merged, seg)
+		}
+	}
+	return append(merged, as4...)
+}
+
+// Community is a BGP community (RFC 1997).
+type Community uint32
+
+// String formats the community as AS:value.
+func (c Community) String() string {
+	return fmt.Sprintf("%d:%d", uint32(c)>>16, uint32(c)&0xffff)
+}
+
+// LargeCommunity is a BGP large community (RFC 8092).
+type LargeCommunity struct {
+	Global, Local1, Local2 uint32
+}
+
+// String formats the community as global:local1:local2.
+func (c LargeCommunity) String() string {
+	return fmt.Sprintf("%d:%d:%d", c.Global, c.Local1, c.Local2)
+}
+
+// Aggregator is the value of the AGGREGATOR attribute.
+type Aggregator struct {
+	AS   uint32
+	Addr netip.Addr
+}
+
+// MPReach is the value of the MP_REACH_NLRI attribute. In TABLE_DUMP_V2
+// RIB entries only the next hops are present.
+type MPReach struct {
+	AFI      AFI
+	SAFI     SAFI
+	NextHops []netip.Addr
+	NLRI     []NLRI
+}
+
+// MPUnreach is the value of the MP_UNREACH_NLRI attribute.
+type MPUnreach struct {
+	AFI       AFI
+	SAFI      SAFI
+	Withdrawn []NLRI
+}
+
+// RawAttribute is an attribute the package does not decode.
+type RawAttribute struct {
+	Flags uint8
+	Type  uint8
+	Value []byte
+}
+
+// Attributes are decoded BGP path attributes. Has reports which of the
+// scalar attributes were present.
+type Attributes struct {
+	Origin           Origin
+	ASPath           ASPath
+	NextHop          netip.Addr
+	MED              uint32
+	LocalPref        uint32
+	AtomicAggregate  bool
+	Aggregator       *Aggregator
+	Communities      []Community
+	LargeCommunities []LargeCommunity
+	MPReach          *MPReach
+	MPUnreach        *MPUnreach
+	Other            []RawAttribute
+
+	present uint64
+}
+
+// Has reports whether the attribute with the type code was present.
+func (a *Attributes) Has(code uint8) bool {
+	return code < 64 && a.present&(1<<code) != 0
+}
+
+// NextHops returns the next hop of the route: the NEXT_HOP attribute, or
+// the next hops of MP_REACH_NLRI.
+func (a *Attributes) NextHops() []netip.Addr {
+	if a.MPReach != nil && len(a.MPReach.NextHops) > 0 {
+		return a.MPReach.NextHops
+	}
+	if a.NextHop.IsValid() {
+		return []netip.Addr{a.NextHop}
+	}
+	return nil
+}
+
+// decodeAttributes decodes a block of path attributes
+func decodeAttributes(pa PathAttributes) (*Attributes, error) {
+	a := &Attributes{}
+	var as4Path ASPath
+	var as4Aggregator *Aggregator
+
+	err := forEachAttribute(pa.raw, func(flags, code uint8, v []byte) (err error) {
+		if code < 64 {
+			a.present |= 1 << code
+		}
+
+		switch code {
+		case AttrOrigin:
+			if len(v) != 1 {
+				err = errBadLength
+				break
+			}
+			a.Origin = Origin(v[0])
+		case AttrASPath:
+			a.ASPath, err = decodeASPath(v, pa.as4)
+		case AttrNextHop:
+			if len(v) != 4 {
+				err = errBadLength
+				break
+			}
+			a.NextHop = netip.AddrFrom4([4]byte(v))
+		case AttrMED:
+			if len(v) != 4 {
+				err = errBadLength
+				break
+			}
+			a.MED = binary.BigEndian.Uint32(v)
+		case AttrLocalPref:
+			if len(v) != 4 {
+				err = errBadLength
+				break
+			}
+			a.LocalPref = binary.BigEndian.Uint32(v)
+		case AttrAtomicAggregate:
+			a.AtomicAggregate = true
+		case AttrAggregator:
+			a.Aggregator, err = decodeAggregator(v)
+		case AttrCommunities:
+			if len(v)%4 != 0 {
+				err = errBadLength
+				break
+			}
+			for i := 0; i < len(v); i += 4 {
+				a.Communities = append(a.Communities, Community(binary.BigEndian.Uint32(v[i:])))
+			}
+		case AttrLargeCommunity:
+			if len(v)%12 != 0 {
+				err = errBadLength
+				break
+			}
+			for i := 0; i < len(v); i += 12 {
+				a.LargeCommunities = append(a.LargeCommunities, LargeCommunity{
+					Global: binary.BigEndian.Uint32(v[i:]),
+					Local1: binary.BigEndian.Uint32(v[i+4:]),
+					Local2: binary.BigEndian.Uint32(v[i+8:]),
+				})
+			}
+		case AttrMPReach:
+			a.MPReach, err = decodeMPReach(v, pa)
+		case AttrMPUnreach:
+			a.MPUnreach, err = decodeMPUnreach(v, pa.addPath)
+		case AttrAS4Path:
+			as4Path, err = decodeASPath(v, true)
+		case AttrAS4Aggregator:
+			as4Aggregator, err = decodeAggregator(v)
+		default:
+			a.Other = append(a.Other, RawAttribute{Flags: flags, Type: code, Value: v})
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Restore 4-byte AS numbers hidden behind AS_TRANS
+	if !pa.as4 {
+		if as4Path != nil {
+			a.ASPath = mergeAS4Path(a.ASPath, as4Path)
+		}
+		if as4Aggregator != nil && a.Aggregator != nil && a.Aggregator.AS == asTrans {
+			a.Aggregator = as4Aggregator
+		}
+	}
+	return a, nil
+}
+
+// forEachAttribute calls fn with the flags, type code and value of each
+// attribute of an encoded block, stopping at the first error
+func forEachAttribute(raw []byte, fn func(flags, code uint8, v []byte) error) error {
+	d := &decoder{b: raw}
+	for len(d.b) > 0 {
+		flags := d.u8()
+		code := d.u8()
+		var n int
+		if flags&0x10 != 0 { // extended length
+			n = int(d.u16())
+		} else {
+			n = int(d.u8())
+		}
+		v := d.bytes(n)
+		if d.err != nil {
+			return fmt.Errorf("attribute %d: %v", code, d.err)
+		}
+		if err := fn(flags, code, v); err != nil {
+			return fmt.Errorf("attribute %d: %v", code, err)
+		}
+	}
+	return nil
+}
+
+// errBadLength is returned for attributes of the wrong size
+var errBadLength = errors.New("invalid length")
+
+// decodeASPath decodes an AS_PATH or AS4_PATH attribute
+func decodeASPath(v []byte, as4 bool) (ASPath, error) {
+	size := 2
+	if as4 {
+		size = 4
+	}
+	path := ASPath{}
+	d := &decoder{b: v}
+	for len(d.b) > 0 {
+		seg := ASPathSegment{Type: d.u8()}
+		n := int(d.u8())
+		b := d.bytes(n * size)
+		if d.err != nil {
+			return nil, d.err
+		}
+		seg.ASNs = make([]uint32, n)
+		for i := range seg.ASNs {
+			if as4 {
+				seg.ASNs[i] = binary.BigEndian.Uint32(b[i*4:])
+			} else {
+				seg.ASNs[i] = uint32(binary.BigEndian.Uint16(b[i*2:]))
+			}
+		}
+		path = append(path, seg)
+	}
+	return path, nil
+}
+
+// decodeAggregator decodes an AGGREGATOR or AS4_AGGREGATOR attribute
+func decodeAggregator(v []byte) (*Aggregator, error) {
+	switch len(v) {
+	case 6:
+		return &Aggregator{AS: uint32(binary.BigEndian.Uint16(v)), Addr: netip.AddrFrom4([4]byte(v[2:]))}, nil
+	case 8:
+		return &Aggregator{AS: binary.BigEndian.Uint32(v), Addr: netip.AddrFrom4([4]byte(v[4:]))}, nil
+	}
+	return nil, errBadLength
+}
+
+// decodeMPReach decodes an MP_REACH_NLRI attribute, in either its full or
+// its TABLE_DUMP_V2 form
+func decodeMPReach(v []byte, pa PathAttributes) (*MPReach, error) {
+	mp := &MPReach{}
+	d := &decoder{b: v}
+	if pa.rib && len(v) > 0 && int(v[0]) == len(v)-1 {
+		// RFC 6396 section 4.3.4: only the next hop is kept; the family
+		// follows from its length
+		nh := d.bytes(int(d.u8()))
+		mp.AFI, mp.SAFI = AFIIPv6, SAFIUnicast
+		if len(nh) == 4 {
+			mp.AFI = AFIIPv4
+		}
+		mp.NextHops = decodeNextHops(nh)
+		return mp, nil
+	}
+
+	mp.AFI = AFI(d.u16())
+	mp.SAFI = SAFI(d.u8())
+	nh := d.bytes(int(d.u8()))
+	d.u8() // reserved
+	if d.err != nil {
+		return nil, d.err
+	}
+	mp.NextHops = decodeNextHops(nh)
+	var err error
+	mp.NLRI, err = decodeNLRI(d.b, mp.AFI, pa.addPath)
+	return mp, err
+}
+
+// decodeNextHops splits the next hop field of MP_REACH_NLRI into addresses
+func decodeNextHops(nh []byte) []netip.Addr {
+	var addrs []netip.Addr
+	switch len(nh) {
+	case 4:
+		addrs = append(addrs, netip.AddrFrom4([4]byte(nh)))
+	case 16, 32:
+		for i := 0; i < len(nh); i += 16 {
+			addrs = append(addrs, netip.AddrFrom16([16]byte(nh[i:i+16])))
+		}
+	case 12, 24, 48: // route distinguisher in front of each address
+		step := len(nh)
+		if step == 48 {
+			step = 24
+		}
+		for i := 0; i < len(nh); i += step {
+			a := nh[i+8 : i+step]
+			if len(a) == 4 {
+				addrs = append(addrs, netip.AddrFrom4([4]byte(a)))
+			} else {
+				addrs = append(addrs, netip.AddrFrom16([16]byte(a)))
+			}
+		}
+	}
+	return addrs
+}
+
+// decodeMPUnreach decodes an MP_UNREACH_NLRI attribute
+func decodeMPUnreach(v []byte, addPath bool) (*MPUnreach, error) {
+	d := &decoder{b: v}
+	mp := &MPUnreach{AFI: AFI(d.u16()), SAFI: SAFI(d.u8())}
+	if d.err != nil {
+		return nil, d.err
+	}
+	var err error
+	mp.Withdrawn, err = decodeNLRI(d.b, mp.AFI, addPath)
+	return mp, err
+}
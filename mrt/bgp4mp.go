@@ -0,0 +1,241 @@
+package mrt
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+)
+
+// BGP4MP and BGP4MP_ET subtypes
+const (
+	SubtypeStateChange            = 0
+	SubtypeMessage                = 1
+	SubtypeMessageAS4             = 4
+	SubtypeStateChangeAS4         = 5
+	SubtypeMessageLocal           = 6
+	SubtypeMessageAS4Local        = 7
+	SubtypeMessageAddPath         = 8
+	SubtypeMessageAS4AddPath      = 9
+	SubtypeMessageLocalAddPath    = 10
+	SubtypeMessageAS4LocalAddPath = 11
+)
+
+// BGP message types
+const (
+	MsgOpen         = 1
+	MsgUpdate       = 2
+	MsgNotification = 3
+	MsgKeepalive    = 4
+	MsgRouteRefresh = 5
+)
+
+// State is a BGP finite state machine state.
+type State uint16
+
+const (
+	StateIdle        State = 1
+	StateConnect     State = 2
+	StateActive      State = 3
+	StateOpenSent    State = 4
+	StateOpenConfirm State = 5
+	StateEstablished State = 6
+)
+
+var stateNames = map[State]string{
+	StateIdle:        "Idle",
+	StateConnect:     "Connect",
+	StateActive:      "Active",
+	StateOpenSent:    "OpenSent",
+	StateOpenConfirm: "OpenConfirm",
+	StateEstablished: "Established",
+}
+
+// String returns the name of the state.
+func (s State) String() string {
+	if name, ok := stateNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("State(%d)", uint16(s))
+}
+
+// BGP4MPHeader holds the session fields common to BGP4MP records.
+type BGP4MPHeader struct {
+	PeerAS    uint32
+	LocalAS   uint32
+	Interface uint16
+	AFI       AFI
+	PeerIP    netip.Addr
+	LocalIP   netip.Addr
+}
+
+// BGP4MPStateChange records a state transition of a BGP session.
+type BGP4MPStateChange struct {
+	hdr Header
+	BGP4MPHeader
+	OldState State
+	NewState State
+}
+
+// Header returns the MRT header of the record.
+func (s *BGP4MPStateChange) Header() Header {
+	return s.hdr
+}
+
+// BGP4MPMessage is a BGP message received or sent by the collector.
+type BGP4MPMessage struct {
+	hdr Header
+	BGP4MPHeader
+	AS4     bool // AS numbers in the message are 4 bytes long
+	AddPath bool // NLRI carry path identifiers
+	Local   bool // sent by the collector
+	// Data is the whole BGP message, including the marker.
+	Data []byte
+}
+
+// Header returns the MRT header of the record.
+func (m *BGP4MPMessage) Header() Header {
+	return m.hdr
+}
+
+// Type returns the type of the BGP message.
+func (m *BGP4MPMessage) Type() uint8 {
+	if len(m.Data) < 19 {
+		return 0
+	}
+	return m.Data[18]
+}
+
+// Update decodes the message as a BGP UPDATE.
+func (m *BGP4MPMessage) Update() (*Update, error) {
+	if m.Type() != MsgUpdate {
+		return nil, fmt.Errorf("not an UPDATE message (type %d)", m.Type())
+	}
+	d := &decoder{b: m.Data[19:]}
+	withdrawn := d.bytes(int(d.u16()))
+	attrs := d.bytes(int(d.u16()))
+	if d.err != nil {
+		return nil, fmt.Errorf("UPDATE: %v", d.err)
+	}
+	u := &Update{
+		Attributes: PathAttributes{raw: attrs, as4: m.AS4, addPath: m.AddPath},
+	}
+	var err error
+	if u.Withdrawn, err = decodeNLRI(withdrawn, AFIIPv4, m.AddPath); err != nil {
+		return nil, fmt.Errorf("UPDATE withdrawn routes: %v", err)
+	}
+	if u.NLRI, err = decodeNLRI(d.b, AFIIPv4, m.AddPath); err != nil {
+		return nil, fmt.Errorf("UPDATE NLRI: %v", err)
+	}
+	return u, nil
+}
+
+// Update is a decoded BGP UPDATE message. Withdrawn and NLRI hold the IPv4
+// routes of the message body; routes of other families are carried in the
+// MP_REACH_NLRI and MP_UNREACH_NLRI attributes.
+type Update struct {
+	Withdrawn  []NLRI
+	NLRI       []NLRI
+	Attributes PathAttributes
+}
+
+// Routes returns all announced and withdrawn routes of the update, together
+// with its decoded attributes.
+func (u *Update) Routes() (announced, withdrawn []NLRI, attrs *Attributes, err error) {
+	attrs, err = u.Attributes.Decode()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	announced = u.NLRI
+	withdrawn = u.Withdrawn
+	if attrs.MPReach != nil {
+		announced = append(announced[:len(announced):len(announced)], attrs.MPReach.NLRI...)
+	}
+	if attrs.MPUnreach != nil {
+		withdrawn = append(withdrawn[:len(withdrawn):len(withdrawn)], attrs.MPUnreach.Withdrawn...)
+	}
+	return announced, withdrawn, attrs, nil
+}
+
+// Prefixes returns the announced and withdrawn routes of the update as
+// Routes does, but decodes no attribute other than MP_REACH_NLRI and
+// MP_UNREACH_NLRI, so that updates can be selected by prefix cheaply.
+func (u *Update) Prefixes() (announced, withdrawn []NLRI, err error) {
+	announced = u.NLRI[:len(u.NLRI):len(u.NLRI)]
+	withdrawn = u.Withdrawn[:len(u.Withdrawn):len(u.Withdrawn)]
+	err = forEachAttribute(u.Attributes.raw, func(_, code uint8, v []byte) error {
+		switch code {
+		case AttrMPReach:
+			mp, err := decodeMPReach(v, u.Attributes)
+			if err != nil {
+				return err
+			}
+			announced = append(announced, mp.NLRI...)
+		case AttrMPUnreach:
+			mp, err := decodeMPUnreach(v, u.Attributes.addPath)
+			if err != nil {
+				return err
+			}
+			withdrawn = append(withdrawn, mp.Withdrawn...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return announced, withdrawn, nil
+}
+
+// decodeBGP4MP decodes a BGP4MP or BGP4MP_ET record
+func decodeBGP4MP(hdr Header, data []byte) (Record, error) {
+	var as4 bool
+	switch hdr.Subtype {
+	case SubtypeStateChange, SubtypeMessage, SubtypeMessageLocal, SubtypeMessageAddPath, SubtypeMessageLocalAddPath:
+	case SubtypeStateChangeAS4, SubtypeMessageAS4, SubtypeMessageAS4Local, SubtypeMessageAS4AddPath, SubtypeMessageAS4LocalAddPath:
+		as4 = true
+	default:
+		return &Unknown{hdr: hdr, Data: data}, nil
+	}
+
+	d := &decoder{b: data}
+	var h BGP4MPHeader
+	if as4 {
+		h.PeerAS, h.LocalAS = d.u32(), d.u32()
+	} else {
+		h.PeerAS, h.LocalAS = uint32(d.u16()), uint32(d.u16())
+	}
+	h.Interface = d.u16()
+	h.AFI = AFI(d.u16())
+	switch h.AFI {
+	case AFIIPv4, AFIIPv6:
+	default:
+		if d.err == nil {
+			return nil, fmt.Errorf("unsupported AFI %d", h.AFI)
+		}
+	}
+	h.PeerIP = d.addr(h.AFI == AFIIPv6)
+	h.LocalIP = d.addr(h.AFI == AFIIPv6)
+
+	if hdr.Subtype == SubtypeStateChange || hdr.Subtype == SubtypeStateChangeAS4 {
+		s := &BGP4MPStateChange{hdr: hdr, BGP4MPHeader: h}
+		s.OldState = State(d.u16())
+		s.NewState = State(d.u16())
+		if d.err != nil {
+			return nil, d.err
+		}
+		return s, nil
+	}
+
+	m := &BGP4MPMessage{hdr: hdr, BGP4MPHeader: h, AS4: as4, Data: d.b}
+	switch hdr.Subtype {
+	case SubtypeMessageLocal, SubtypeMessageAS4Local, SubtypeMessageLocalAddPath, SubtypeMessageAS4LocalAddPath:
+		m.Local = true
+	}
+	m.AddPath = hdr.Subtype >= SubtypeMessageAddPath
+	if d.err != nil {
+		return nil, d.err
+	}
+	if len(m.Data) < 19 {
+		return nil, errors.New("BGP message too short")
+	}
+	return m, nil
+}
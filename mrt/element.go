@@ -0,0 +1,259 @@
+package mrt
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ElemType is the kind of an Element.
+type ElemType uint8
+
+const (
+	ElemRIB ElemType = iota + 1
+	ElemAnnounce
+	ElemWithdraw
+	ElemState
+)
+
+// String returns "rib", "announce", "withdraw" or "state".
+func (t ElemType) String() string {
+	switch t {
+	case ElemRIB:
+		return "rib"
+	case ElemAnnounce:
+		return "announce"
+	case ElemWithdraw:
+		return "withdraw"
+	case ElemState:
+		return "state"
+	}
+	return "unknown"
+}
+
+// Element is a single route or event of a record: one peer's route of a
+// RIB record, one prefix announced or withdrawn by an update, or a peer
+// state change. Time is the timestamp of the record, which for RIB elements
+// is the time of the dump.
+type Element struct {
+	Type   ElemType
+	Time   time.Time
+	PeerIP netip.Addr
+	PeerAS uint32
+	Prefix netip.Prefix
+	PathID uint32
+	// Originated is set for RIB elements: when the peer's route was
+	// learned.
+	Originated time.Time
+	// Attributes is set for RIB and announce elements.
+	Attributes *Attributes
+	// OldState and NewState are set for state elements.
+	OldState State
+	NewState State
+}
+
+// Elements splits a record into elements. Records that carry no routes,
+// such as a PEER_INDEX_TABLE or BGP messages other than UPDATE, yield none.
+func Elements(rec Record) ([]Element, error) {
+	switch r := rec.(type) {
+	case *RIB:
+		elems := make([]Element, 0, len(r.Entries))
+		for _, e := range r.Entries {
+			attrs, err := e.Attributes.Decode()
+			if err != nil {
+				return nil, fmt.Errorf("%s from %s: %v", r.Prefix, e.Peer.IP, err)
+			}
+			elems = append(elems, Element{
+				Type:       ElemRIB,
+				Time:       r.hdr.Timestamp,
+				PeerIP:     e.Peer.IP,
+				PeerAS:     e.Peer.AS,
+				Prefix:     r.Prefix,
+				PathID:     e.PathID,
+				Originated: e.Originated,
+				Attributes: attrs,
+			})
+		}
+		return elems, nil
+
+	case *BGP4MPMessage:
+		if r.Type() != MsgUpdate || r.Local {
+			return nil, nil
+		}
+		u, err := r.Update()
+		if err != nil {
+			return nil, err
+		}
+		announced, withdrawn, attrs, err := u.Routes()
+		if err != nil {
+			return nil, err
+		}
+		elems := make([]Element, 0, len(announced)+len(withdrawn))
+		for _, n := range withdrawn {
+			elems = append(elems, Element{
+				Type:   ElemWithdraw,
+				Time:   r.hdr.Timestamp,
+				PeerIP: r.PeerIP,
+				PeerAS: r.PeerAS,
+				Prefix: n.Prefix,
+				PathID: n.PathID,
+			})
+		}
+		for _, n := range announced {
+			elems = append(elems, Element{
+				Type:       ElemAnnounce,
+				Time:       r.hdr.Timestamp,
+				PeerIP:     r.PeerIP,
+				PeerAS:     r.PeerAS,
+				Prefix:     n.Prefix,
+				PathID:     n.PathID,
+				Attributes: attrs,
+			})
+		}
+		return elems, nil
+
+	case *BGP4MPStateChange:
+		return []Element{{
+			Type:     ElemState,
+			Time:     r.hdr.Timestamp,
+			PeerIP:   r.PeerIP,
+			PeerAS:   r.PeerAS,
+			OldState: r.OldState,
+			NewState: r.NewState,
+		}}, nil
+	}
+	return nil, nil
+}
+
+// String formats the element as a line of `bgpdump -m`.
+func (e Element) String() string {
+	kind, ts := "BGP4MP", strconv.FormatInt(e.Time.Unix(), 10)
+	if e.Type == ElemRIB {
+		kind = "TABLE_DUMP2"
+	} else if ns := e.Time.Nanosecond(); ns != 0 {
+		kind, ts = "BGP4MP_ET", fmt.Sprintf("%s.%06d", ts, ns/1000)
+	}
+	peer := fmt.Sprintf("%s|%d", e.PeerIP, e.PeerAS)
+
+	switch e.Type {
+	case ElemWithdraw:
+		return fmt.Sprintf("%s|%s|W|%s|%s", kind, ts, peer, e.Prefix)
+	case ElemState:
+		return fmt.Sprintf("%s|%s|STATE|%s|%d|%d", kind, ts, peer, e.OldState, e.NewState)
+	}
+
+	flag := "A"
+	if e.Type == ElemRIB {
+		flag = "B"
+	}
+	a := e.Attributes
+	if a == nil {
+		a = &Attributes{}
+	}
+	return fmt.Sprintf("%s|%s|%s|%s|%s|%s|%s|%s|%d|%d|%s|%s|%s|",
+		kind, ts, flag, peer, e.Prefix, a.ASPath, a.Origin, formatNextHop(a),
+		a.LocalPref, a.MED, formatCommunities(a), formatAtomicAggregate(a), formatAggregator(a))
+}
+
+// formatNextHop returns the first next hop of the route
+func formatNextHop(a *Attributes) string {
+	if nh := a.NextHops(); len(nh) > 0 {
+		return nh[0].String()
+	}
+	return ""
+}
+
+// formatCommunities returns the standard and large communities separated
+// by spaces
+func formatCommunities(a *Attributes) string {
+	var parts []string
+	for _, c := range a.Communities {
+		parts = append(parts, c.String())
+	}
+	for _, c := range a.LargeCommunities {
+		parts = append(parts, c.String())
+	}
+	return strings.Join(parts, " ")
+}
+
+func formatAtomicAggregate(a *Attributes) string {
+	if a.AtomicAggregate {
+		return "AG"
+	}
+	return "NAG"
+}
+
+func formatAggregator(a *Attributes) string {
+	if a.Aggregator == nil {
+		return ""
+	}
+	return fmt.Sprintf("%d %s", a.Aggregator.AS, a.Aggregator.Addr)
+}
+
+// elementJSON is the JSON encoding of an Element
+type elementJSON struct {
+	Type             string   `json:"type"`
+	Time             float64  `json:"time"`
+	PeerIP           string   `json:"peer_ip"`
+	PeerAS           uint32   `json:"peer_as"`
+	Prefix           string   `json:"prefix,omitempty"`
+	PathID           uint32   `json:"path_id,omitempty"`
+	Originated       float64  `json:"originated,omitempty"`
+	ASPath           string   `json:"as_path,omitempty"`
+	Origin           string   `json:"origin,omitempty"`
+	NextHop          string   `json:"next_hop,omitempty"`
+	LocalPref        *uint32  `json:"local_pref,omitempty"`
+	MED              *uint32  `json:"med,omitempty"`
+	Communities      []string `json:"communities,omitempty"`
+	LargeCommunities []string `json:"large_communities,omitempty"`
+	AtomicAggregate  bool     `json:"atomic_aggregate,omitempty"`
+	Aggregator       string   `json:"aggregator,omitempty"`
+	OldState         string   `json:"old_state,omitempty"`
+	NewState         string   `json:"new_state,omitempty"`
+}
+
+// MarshalJSON encodes the element as a flat JSON object. Absent attributes
+// are omitted.
+func (e Element) MarshalJSON() ([]byte, error) {
+	j := elementJSON{
+		Type:   e.Type.String(),
+		Time:   float64(e.Time.UnixMicro()) / 1e6,
+		PeerIP: e.PeerIP.String(),
+		PeerAS: e.PeerAS,
+		PathID: e.PathID,
+	}
+	if e.Prefix.IsValid() {
+		j.Prefix = e.Prefix.String()
+	}
+	if !e.Originated.IsZero() {
+		j.Originated = float64(e.Originated.Unix())
+	}
+	if e.Type == ElemState {
+		j.OldState, j.NewState = e.OldState.String(), e.NewState.String()
+	}
+	if a := e.Attributes; a != nil {
+		j.ASPath = a.ASPath.String()
+		if a.Has(AttrOrigin) {
+			j.Origin = a.Origin.String()
+		}
+		j.NextHop = formatNextHop(a)
+		if a.Has(AttrLocalPref) {
+			j.LocalPref = &a.LocalPref
+		}
+		if a.Has(AttrMED) {
+			j.MED = &a.MED
+		}
+		for _, c := range a.Communities {
+			j.Communities = append(j.Communities, c.String())
+		}
+		for _, c := range a.LargeCommunities {
+			j.LargeCommunities = append(j.LargeCommunities, c.String())
+		}
+		j.AtomicAggregate = a.AtomicAggregate
+		j.Aggregator = formatAggregator(a)
+	}
+	return json.Marshal(j)
+}
@@ -0,0 +1,281 @@
+// Package mrt decodes BGP routing data in the MRT format (RFC 6396) as
+// published by the RIPE RIS and RouteViews archives.
+package mrt
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"time"
+)
+
+// MRT record types
+const (
+	TypeTableDumpV2 = 13
+	TypeBGP4MP      = 16
+	TypeBGP4MPET    = 17
+)
+
+// MaxRecordLength is the longest record body Reader.Next accepts. The
+// largest records of the archives, RIB entries of prefixes seen by every
+// peer of a collector, stay well below it.
+const MaxRecordLength = 1 << 20
+
+// Header is the common header of all MRT records.
+type Header struct {
+	Timestamp time.Time
+	Type      uint16
+	Subtype   uint16
+	Length    uint32
+}
+
+// Record is a decoded MRT record: *PeerIndexTable, *RIB, *BGP4MPMessage,
+// *BGP4MPStateChange or *Unknown.
+type Record interface {
+	// Header returns the MRT header of the record.
+	Header() Header
+}
+
+// Unknown is a record of a type or subtype the package does not decode.
+type Unknown struct {
+	hdr  Header
+	Data []byte
+}
+
+// Header returns the MRT header of the record.
+func (u *Unknown) Header() Header {
+	return u.hdr
+}
+
+// Reader reads MRT records from a stream.
+type Reader struct {
+	r      *bufio.Reader
+	closer io.Closer
+	peers  *PeerIndexTable
+	buf    [12]byte
+}
+
+// NewReader returns a Reader reading uncompressed MRT data from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReaderSize(r, 1<<16)}
+}
+
+// Open opens an MRT file, decompressing it if it is gzip or bzip2
+// compressed.
+func Open(path string) (*Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := Decompress(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	mr := NewReader(r)
+	mr.closer = f
+	return mr, nil
+}
+
+// ForEachFile calls fn with each record of the MRT file at path, in order,
+// until fn returns false or the file ends. Records that cannot be decoded
+// are not passed to fn but counted in skipped.
+func ForEachFile(path string, fn func(Record) bool) (skipped int, err error) {
+	r, err := Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	for {
+		rec, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return skipped, nil
+		}
+		var de *DecodeError
+		if errors.As(err, &de) {
+			skipped++
+			continue
+		}
+		if err != nil {
+			return skipped, err
+		}
+		if !fn(rec) {
+			return skipped, nil
+		}
+	}
+}
+
+// Decompress returns a reader of the uncompressed content of r, detecting
+// gzip and bzip2 compression from the first bytes of the stream.
+// Uncompressed data is passed through.
+func Decompress(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReaderSize(r, 1<<16)
+	magic, err := br.Peek(3)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		return gzip.NewReader(br)
+	case bytes.HasPrefix(magic, []byte("BZh")):
+		return bzip2.NewReader(br), nil
+	}
+	return br, nil
+}
+
+// Close closes the file opened by Open. It does nothing for readers
+// created with NewReader.
+func (r *Reader) Close() error {
+	if r.closer == nil {
+		return nil
+	}
+	return r.closer.Close()
+}
+
+// Next returns the next record of the stream, or io.EOF at its end. A
+// record that cannot be decoded yields a *DecodeError; reading may continue
+// with the following record.
+func (r *Reader) Next() (Record, error) {
+	if _, err := io.ReadFull(r.r, r.buf[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, fmt.Errorf("mrt: truncated header: %w", err)
+		}
+		return nil, err
+	}
+	hdr := Header{
+		Timestamp: time.Unix(int64(binary.BigEndian.Uint32(r.buf[0:4])), 0).UTC(),
+		Type:      binary.BigEndian.Uint16(r.buf[4:6]),
+		Subtype:   binary.BigEndian.Uint16(r.buf[6:8]),
+		Length:    binary.BigEndian.Uint32(r.buf[8:12]),
+	}
+	// The length of a corrupt header would allocate up to 4 GiB; skip the
+	// body instead so that reading may continue
+	if hdr.Length > MaxRecordLength {
+		if _, err := io.CopyN(io.Discard, r.r, int64(hdr.Length)); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, fmt.Errorf("mrt: truncated record: %w", err)
+		}
+		return nil, &DecodeError{Header: hdr, Err: errTooLong}
+	}
+	data := make([]byte, hdr.Length)
+	if _, err := io.ReadFull(r.r, data); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("mrt: truncated record: %w", err)
+	}
+
+	// Extended timestamp records carry microseconds in front of the message
+	if hdr.Type == TypeBGP4MPET {
+		if len(data) < 4 {
+			return nil, &DecodeError{Header: hdr, Err: errShort}
+		}
+		usec := binary.BigEndian.Uint32(data[:4])
+		hdr.Timestamp = hdr.Timestamp.Add(time.Duration(usec) * time.Microsecond)
+		data = data[4:]
+	}
+
+	rec, err := r.decode(hdr, data)
+	if err != nil {
+		return nil, &DecodeError{Header: hdr, Err: err}
+	}
+	return rec, nil
+}
+
+// DecodeError is returned by Reader.Next for a record that was read but
+// could not be decoded.
+type DecodeError struct {
+	Header Header
+	Err    error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("mrt: type %d subtype %d at %s: %v",
+		e.Header.Type, e.Header.Subtype, e.Header.Timestamp.Format(time.RFC3339), e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// decode decodes the body of a record
+func (r *Reader) decode(hdr Header, data []byte) (Record, error) {
+	switch hdr.Type {
+	case TypeTableDumpV2:
+		return r.decodeTableDumpV2(hdr, data)
+	case TypeBGP4MP, TypeBGP4MPET:
+		return decodeBGP4MP(hdr, data)
+	}
+	return &Unknown{hdr: hdr, Data: data}, nil
+}
+
+// errShort is returned when a record ends before a field
+var errShort = errors.New("record too short")
+
+// errTooLong is returned for a record longer than MaxRecordLength
+var errTooLong = fmt.Errorf("record longer than %d bytes", MaxRecordLength)
+
+// decoder reads big-endian fields from a byte slice
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) bytes(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || len(d.b) < n {
+		d.err = errShort
+		d.b = nil
+		return nil
+	}
+	v := d.b[:n:n]
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) u8() uint8 {
+	if b := d.bytes(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *decoder) u16() uint16 {
+	if b := d.bytes(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (d *decoder) u32() uint32 {
+	if b := d.bytes(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+// addr reads an IPv4 or IPv6 address
+func (d *decoder) addr(ipv6 bool) netip.Addr {
+	if ipv6 {
+		if b := d.bytes(16); b != nil {
+			return netip.AddrFrom16([16]byte(b))
+		}
+		return netip.Addr{}
+	}
+	if b := d.bytes(4); b != nil {
+		return netip.AddrFrom4([4]byte(b))
+	}
+	return netip.Addr{}
+}
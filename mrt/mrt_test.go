@@ -0,0 +1,282 @@
+package mrt
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"bgp_downloader/internal/mrttest"
+)
+
+// peerIndexTable has an IPv4 peer with a 2-byte AS and an IPv6 peer with a
+// 4-byte AS
+var peerIndexTable = mrttest.Record(1000, TypeTableDumpV2, SubtypePeerIndexTable, mrttest.Cat(
+	mrttest.IP("192.0.2.1"), mrttest.U16(4), []byte("test"), mrttest.U16(2),
+	[]byte{0}, mrttest.IP("10.0.0.1"), mrttest.IP("198.51.100.1"), mrttest.U16(64500),
+	[]byte{3}, mrttest.IP("10.0.0.2"), mrttest.IP("2001:db8::1"), mrttest.U32(4200000000),
+))
+
+var ribAttrs = mrttest.Cat(
+	mrttest.Attr(0x40, AttrOrigin, []byte{0}),
+	mrttest.Attr(0x40, AttrASPath, mrttest.ASPath4(64500, 4200000001)),
+	mrttest.Attr(0x40, AttrNextHop, mrttest.IP("198.51.100.1")),
+)
+
+// readAll decodes a stream, collecting the records and the decode errors
+// until io.EOF or another error
+func readAll(data []byte) (recs []Record, decodeErrs []error, err error) {
+	r := NewReader(bytes.NewReader(data))
+	for {
+		rec, err := r.Next()
+		var de *DecodeError
+		switch {
+		case errors.Is(err, io.EOF):
+			return recs, decodeErrs, nil
+		case errors.As(err, &de):
+			decodeErrs = append(decodeErrs, err)
+		case err != nil:
+			return recs, decodeErrs, err
+		default:
+			recs = append(recs, rec)
+		}
+	}
+}
+
+func TestReaderNext(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  []byte
+		check func(t *testing.T, recs []Record)
+	}{
+		{
+			name: "peer index table",
+			data: peerIndexTable,
+			check: func(t *testing.T, recs []Record) {
+				pt := recs[0].(*PeerIndexTable)
+				want := []Peer{
+					{netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("198.51.100.1"), 64500},
+					{netip.MustParseAddr("10.0.0.2"), netip.MustParseAddr("2001:db8::1"), 4200000000},
+				}
+				if pt.ViewName != "test" || pt.CollectorID != netip.MustParseAddr("192.0.2.1") {
+					t.Errorf("got view %q collector %s", pt.ViewName, pt.CollectorID)
+				}
+				if len(pt.Peers) != len(want) || pt.Peers[0] != want[0] || pt.Peers[1] != want[1] {
+					t.Errorf("got peers %v, want %v", pt.Peers, want)
+				}
+			},
+		},
+		{
+			name: "rib ipv4 unicast",
+			data: mrttest.Cat(peerIndexTable, mrttest.Record(1000, TypeTableDumpV2, SubtypeRIBIPv4Unicast, mrttest.Cat(
+				mrttest.U32(7), mrttest.Prefix("203.0.113.0/24"), mrttest.U16(1),
+				mrttest.U16(1), mrttest.U32(900), mrttest.U16(len(ribAttrs)), ribAttrs,
+			))),
+			check: func(t *testing.T, recs []Record) {
+				rib := recs[1].(*RIB)
+				if rib.Sequence != 7 || rib.Prefix != netip.MustParsePrefix("203.0.113.0/24") ||
+					rib.AFI != AFIIPv4 || rib.SAFI != SAFIUnicast || rib.AddPath {
+					t.Fatalf("got RIB %+v", rib)
+				}
+				e := rib.Entries[0]
+				if e.Peer.AS != 4200000000 || !e.Originated.Equal(time.Unix(900, 0)) {
+					t.Errorf("got entry %+v", e)
+				}
+				elems, err := Elements(rib)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := elems[0].Attributes.ASPath.String(); got != "64500 4200000001" {
+					t.Errorf("got path %q", got)
+				}
+				// bgpdump prints the time of the dump, not when the route
+				// was learned
+				if !elems[0].Time.Equal(time.Unix(1000, 0)) || !elems[0].Originated.Equal(time.Unix(900, 0)) {
+					t.Errorf("got element time %s originated %s", elems[0].Time, elems[0].Originated)
+				}
+				want := "TABLE_DUMP2|1000|B|2001:db8::1|4200000000|203.0.113.0/24|64500 4200000001|IGP|198.51.100.1|0|0||NAG||"
+				if got := elems[0].String(); got != want {
+					t.Errorf("got %q, want %q", got, want)
+				}
+			},
+		},
+		{
+			name: "rib add-path",
+			data: mrttest.Cat(peerIndexTable, mrttest.Record(1000, TypeTableDumpV2, SubtypeRIBIPv4UnicastAddPath, mrttest.Cat(
+				mrttest.U32(1), mrttest.Prefix("203.0.113.0/24"), mrttest.U16(2),
+				mrttest.U16(0), mrttest.U32(900), mrttest.U32(1), mrttest.U16(len(ribAttrs)), ribAttrs,
+				mrttest.U16(0), mrttest.U32(900), mrttest.U32(2), mrttest.U16(len(ribAttrs)), ribAttrs,
+			))),
+			check: func(t *testing.T, recs []Record) {
+				rib := recs[1].(*RIB)
+				if !rib.AddPath || len(rib.Entries) != 2 || rib.Entries[0].PathID != 1 || rib.Entries[1].PathID != 2 {
+					t.Errorf("got RIB %+v", rib)
+				}
+			},
+		},
+		{
+			name: "rib generic",
+			data: mrttest.Cat(peerIndexTable, mrttest.Record(1000, TypeTableDumpV2, SubtypeRIBGeneric, mrttest.Cat(
+				mrttest.U32(1), mrttest.U16(int(AFIIPv6)), []byte{byte(SAFIMulticast)}, mrttest.Prefix("2001:db8::/32"), mrttest.U16(1),
+				mrttest.U16(1), mrttest.U32(900), mrttest.U16(len(ribAttrs)), ribAttrs,
+			))),
+			check: func(t *testing.T, recs []Record) {
+				rib := recs[1].(*RIB)
+				if rib.AFI != AFIIPv6 || rib.SAFI != SAFIMulticast || rib.Prefix != netip.MustParsePrefix("2001:db8::/32") {
+					t.Errorf("got RIB %+v", rib)
+				}
+			},
+		},
+		{
+			name: "bgp4mp as2 message with as4 path",
+			data: mrttest.Record(2000, TypeBGP4MP, SubtypeMessage, mrttest.Cat(
+				mrttest.U16(64500), mrttest.U16(64501), mrttest.U16(0), mrttest.U16(int(AFIIPv4)), mrttest.IP("198.51.100.1"), mrttest.IP("198.51.100.2"),
+				mrttest.Update(nil, mrttest.Cat(
+					mrttest.Attr(0x40, AttrOrigin, []byte{0}),
+					mrttest.Attr(0x40, AttrASPath, mrttest.ASPath2(64500, asTrans, asTrans)),
+					mrttest.Attr(0xc0, AttrAS4Path, mrttest.ASPath4(4200000001, 4200000002)),
+				), mrttest.Prefix("203.0.113.0/24")),
+			)),
+			check: func(t *testing.T, recs []Record) {
+				m := recs[0].(*BGP4MPMessage)
+				if m.AS4 || m.PeerAS != 64500 || m.PeerIP != netip.MustParseAddr("198.51.100.1") {
+					t.Fatalf("got message %+v", m.BGP4MPHeader)
+				}
+				u, err := m.Update()
+				if err != nil {
+					t.Fatal(err)
+				}
+				announced, _, attrs, err := u.Routes()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(announced) != 1 || announced[0].Prefix != netip.MustParsePrefix("203.0.113.0/24") {
+					t.Errorf("got announced %v", announced)
+				}
+				if got := attrs.ASPath.String(); got != "64500 4200000001 4200000002" {
+					t.Errorf("got merged path %q", got)
+				}
+			},
+		},
+		{
+			name: "bgp4mp_et state change",
+			data: mrttest.Record(2000, TypeBGP4MPET, SubtypeStateChangeAS4, mrttest.Cat(
+				mrttest.U32(250000),
+				mrttest.U32(4200000000), mrttest.U32(64501), mrttest.U16(0), mrttest.U16(int(AFIIPv6)), mrttest.IP("2001:db8::1"), mrttest.IP("2001:db8::2"),
+				mrttest.U16(int(StateEstablished)), mrttest.U16(int(StateIdle)),
+			)),
+			check: func(t *testing.T, recs []Record) {
+				s := recs[0].(*BGP4MPStateChange)
+				if want := time.Unix(2000, 250000000); !s.Header().Timestamp.Equal(want) {
+					t.Errorf("got time %s, want %s", s.Header().Timestamp, want)
+				}
+				if s.PeerAS != 4200000000 || s.OldState != StateEstablished || s.NewState != StateIdle {
+					t.Errorf("got state change %+v", s)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recs, decodeErrs, err := readAll(tt.data)
+			if err != nil || len(decodeErrs) > 0 {
+				t.Fatalf("got errors %v %v", err, decodeErrs)
+			}
+			tt.check(t, recs)
+		})
+	}
+}
+
+func TestReaderNextErrors(t *testing.T) {
+	state := mrttest.Record(3000, TypeBGP4MP, SubtypeStateChange, mrttest.Cat(
+		mrttest.U16(64500), mrttest.U16(64501), mrttest.U16(0), mrttest.U16(int(AFIIPv4)), mrttest.IP("198.51.100.1"), mrttest.IP("198.51.100.2"),
+		mrttest.U16(int(StateIdle)), mrttest.U16(int(StateEstablished)),
+	))
+	tooLong := mrttest.Cat(mrttest.U32(3000), mrttest.U16(TypeBGP4MP), mrttest.U16(SubtypeMessage), mrttest.U32(MaxRecordLength+1),
+		make([]byte, MaxRecordLength+1))
+
+	tests := []struct {
+		name string
+		data []byte
+		// records and decode errors expected before the end of the stream
+		records    int
+		decodeErrs int
+		truncated  bool
+	}{
+		{"truncated header", peerIndexTable[:6], 0, 0, true},
+		{"truncated body", peerIndexTable[:len(peerIndexTable)-3], 0, 0, true},
+		{"short field", mrttest.Cat(mrttest.Record(3000, TypeBGP4MP, SubtypeStateChange, state[12:len(state)-1]), state), 1, 1, false},
+		{"rib without peer index", mrttest.Record(1000, TypeTableDumpV2, SubtypeRIBIPv4Unicast, mrttest.Cat(
+			mrttest.U32(1), mrttest.Prefix("203.0.113.0/24"), mrttest.U16(1), mrttest.U16(0), mrttest.U32(900), mrttest.U16(len(ribAttrs)), ribAttrs,
+		)), 0, 1, false},
+		{"record too long", mrttest.Cat(tooLong, state), 1, 1, false},
+		{"record too long and truncated", tooLong[:1000], 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recs, decodeErrs, err := readAll(tt.data)
+			if len(recs) != tt.records || len(decodeErrs) != tt.decodeErrs {
+				t.Errorf("got %d records and %d decode errors, want %d and %d",
+					len(recs), len(decodeErrs), tt.records, tt.decodeErrs)
+			}
+			if got := errors.Is(err, io.ErrUnexpectedEOF); got != tt.truncated {
+				t.Errorf("got error %v, want truncated %v", err, tt.truncated)
+			}
+		})
+	}
+}
+
+func TestForEachFile(t *testing.T) {
+	state := func(ts uint32) []byte {
+		return mrttest.Record(ts, TypeBGP4MP, SubtypeStateChange, mrttest.Cat(
+			mrttest.U16(64500), mrttest.U16(64501), mrttest.U16(0), mrttest.U16(int(AFIIPv4)), mrttest.IP("198.51.100.1"), mrttest.IP("198.51.100.2"),
+			mrttest.U16(int(StateIdle)), mrttest.U16(int(StateEstablished)),
+		))
+	}
+	bad := mrttest.Record(1500, TypeBGP4MP, SubtypeStateChange, []byte{1, 2})
+	path := filepath.Join(t.TempDir(), "updates")
+	if err := os.WriteFile(path, mrttest.Cat(state(1000), bad, state(2000), state(3000)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var times []int64
+	skipped, err := ForEachFile(path, func(rec Record) bool {
+		times = append(times, rec.Header().Timestamp.Unix())
+		return len(times) < 2
+	})
+	if err != nil || skipped != 1 || len(times) != 2 || times[1] != 2000 {
+		t.Errorf("got records at %v, %d skipped, %v", times, skipped, err)
+	}
+
+	// A truncated file fails after the records before the cut
+	if err := os.WriteFile(path, mrttest.Cat(state(1000), state(2000)[:10]), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	_, err = ForEachFile(path, func(Record) bool { n++; return true })
+	if n != 1 || !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("got %d records, %v", n, err)
+	}
+}
+
+func TestOriginString(t *testing.T) {
+	tests := []struct {
+		path ASPath
+		want string
+	}{
+		{nil, ""},
+		{ASPath{{Type: ASSequence, ASNs: []uint32{64500, 64501}}}, "64501"},
+		// Set members are in numeric order, not string order
+		{ASPath{{Type: ASSequence, ASNs: []uint32{64500}}, {Type: ASSet, ASNs: []uint32{100000, 9, 65000}}}, "9,65000,100000"},
+		{ASPath{{Type: ASSequence, ASNs: []uint32{64500}}, {Type: ASConfedSequence, ASNs: []uint32{65001}}}, "64500"},
+	}
+	for _, tt := range tests {
+		if got := tt.path.OriginString(); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
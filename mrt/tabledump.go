@@ -0,0 +1,142 @@
+package mrt
+
+import (
+	"fmt"
+	"net/netip"
+	"time"
+)
+
+// TABLE_DUMP_V2 subtypes
+const (
+	SubtypePeerIndexTable          = 1
+	SubtypeRIBIPv4Unicast          = 2
+	SubtypeRIBIPv4Multicast        = 3
+	SubtypeRIBIPv6Unicast          = 4
+	SubtypeRIBIPv6Multicast        = 5
+	SubtypeRIBGeneric              = 6
+	SubtypeRIBIPv4UnicastAddPath   = 8
+	SubtypeRIBIPv4MulticastAddPath = 9
+	SubtypeRIBIPv6UnicastAddPath   = 10
+	SubtypeRIBIPv6MulticastAddPath = 11
+	SubtypeRIBGenericAddPath       = 12
+)
+
+// Peer is a BGP peer of the collector.
+type Peer struct {
+	BGPID netip.Addr
+	IP    netip.Addr
+	AS    uint32
+}
+
+// PeerIndexTable lists the peers that the RIB records of a TABLE_DUMP_V2
+// dump refer to.
+type PeerIndexTable struct {
+	hdr         Header
+	CollectorID netip.Addr
+	ViewName    string
+	Peers       []Peer
+}
+
+// Header returns the MRT header of the record.
+func (t *PeerIndexTable) Header() Header {
+	return t.hdr
+}
+
+// RIB is the set of routes to one prefix in a TABLE_DUMP_V2 dump.
+type RIB struct {
+	hdr      Header
+	Sequence uint32
+	AFI      AFI
+	SAFI     SAFI
+	Prefix   netip.Prefix
+	AddPath  bool
+	Entries  []RIBEntry
+}
+
+// Header returns the MRT header of the record.
+func (r *RIB) Header() Header {
+	return r.hdr
+}
+
+// RIBEntry is the route of one peer in a RIB record.
+type RIBEntry struct {
+	PeerIndex  uint16
+	Peer       Peer // resolved from the preceding PEER_INDEX_TABLE
+	Originated time.Time
+	PathID     uint32 // only set in ADDPATH dumps
+	Attributes PathAttributes
+}
+
+// decodeTableDumpV2 decodes a TABLE_DUMP_V2 record
+func (r *Reader) decodeTableDumpV2(hdr Header, data []byte) (Record, error) {
+	d := &decoder{b: data}
+	switch hdr.Subtype {
+	case SubtypePeerIndexTable:
+		t := &PeerIndexTable{hdr: hdr}
+		t.CollectorID = d.addr(false)
+		t.ViewName = string(d.bytes(int(d.u16())))
+		n := int(d.u16())
+		for i := 0; i < n && d.err == nil; i++ {
+			typ := d.u8()
+			p := Peer{BGPID: d.addr(false)}
+			p.IP = d.addr(typ&0x01 != 0)
+			if typ&0x02 != 0 {
+				p.AS = d.u32()
+			} else {
+				p.AS = uint32(d.u16())
+			}
+			t.Peers = append(t.Peers, p)
+		}
+		if d.err != nil {
+			return nil, d.err
+		}
+		r.peers = t
+		return t, nil
+
+	case SubtypeRIBIPv4Unicast, SubtypeRIBIPv4Multicast, SubtypeRIBIPv6Unicast, SubtypeRIBIPv6Multicast,
+		SubtypeRIBIPv4UnicastAddPath, SubtypeRIBIPv4MulticastAddPath, SubtypeRIBIPv6UnicastAddPath, SubtypeRIBIPv6MulticastAddPath,
+		SubtypeRIBGeneric, SubtypeRIBGenericAddPath:
+		rib := &RIB{hdr: hdr}
+		rib.Sequence = d.u32()
+		switch hdr.Subtype {
+		case SubtypeRIBIPv4Unicast, SubtypeRIBIPv4UnicastAddPath:
+			rib.AFI, rib.SAFI = AFIIPv4, SAFIUnicast
+		case SubtypeRIBIPv4Multicast, SubtypeRIBIPv4MulticastAddPath:
+			rib.AFI, rib.SAFI = AFIIPv4, SAFIMulticast
+		case SubtypeRIBIPv6Unicast, SubtypeRIBIPv6UnicastAddPath:
+			rib.AFI, rib.SAFI = AFIIPv6, SAFIUnicast
+		case SubtypeRIBIPv6Multicast, SubtypeRIBIPv6MulticastAddPath:
+			rib.AFI, rib.SAFI = AFIIPv6, SAFIMulticast
+		default:
+			rib.AFI, rib.SAFI = AFI(d.u16()), SAFI(d.u8())
+		}
+		rib.AddPath = hdr.Subtype >= SubtypeRIBIPv4UnicastAddPath
+		prefix, err := decodePrefix(d, rib.AFI)
+		if err != nil {
+			return nil, err
+		}
+		rib.Prefix = prefix
+
+		n := int(d.u16())
+		rib.Entries = make([]RIBEntry, 0, n)
+		for i := 0; i < n && d.err == nil; i++ {
+			e := RIBEntry{PeerIndex: d.u16()}
+			e.Originated = time.Unix(int64(d.u32()), 0).UTC()
+			if rib.AddPath {
+				e.PathID = d.u32()
+			}
+			e.Attributes = PathAttributes{raw: d.bytes(int(d.u16())), as4: true, rib: true}
+			if r.peers != nil && int(e.PeerIndex) < len(r.peers.Peers) {
+				e.Peer = r.peers.Peers[e.PeerIndex]
+			} else if d.err == nil {
+				return nil, fmt.Errorf("peer index %d not in PEER_INDEX_TABLE", e.PeerIndex)
+			}
+			rib.Entries = append(rib.Entries, e)
+		}
+		if d.err != nil {
+			return nil, d.err
+		}
+		return rib, nil
+	}
+	return &Unknown{hdr: hdr, Data: data}, nil
+}
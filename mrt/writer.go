@@ -0,0 +1,356 @@
+package mrt
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dsnet/compress/bzip2"
+)
+
+// Writer writes MRT records.
+//
+// RIB records refer to their peers by index into a PEER_INDEX_TABLE, which
+// the Writer rebuilds from the peers of the entries written, so that RIB
+// records of different dumps can be combined. As the table has to precede
+// them, the records from the first RIB record on are held in a temporary
+// file and written behind the table by Close.
+type Writer struct {
+	out     *bufio.Writer
+	closers []io.Closer // closed in order by Close
+
+	spool *os.File
+	w     *bufio.Writer // out, or the spool once a RIB record was written
+
+	collectorID netip.Addr
+	viewName    string
+	tableTime   time.Time
+	peers       []Peer
+	index       map[Peer]uint16
+	sequence    uint32
+	buf         []byte
+}
+
+// NewWriter returns a Writer writing uncompressed MRT data to w.
+func NewWriter(w io.Writer) *Writer {
+	out := bufio.NewWriterSize(w, 1<<16)
+	return &Writer{out: out, w: out, index: make(map[Peer]uint16)}
+}
+
+// Create creates an MRT file, compressed with gzip or bzip2 if the name
+// ends in ".gz" or ".bz2".
+func Create(path string) (*Writer, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	var mw *Writer
+	switch {
+	case strings.HasSuffix(path, ".gz"):
+		z := gzip.NewWriter(f)
+		mw = NewWriter(z)
+		mw.closers = []io.Closer{z, f}
+	case strings.HasSuffix(path, ".bz2"):
+		z, err := bzip2.NewWriter(f, nil)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		mw = NewWriter(z)
+		mw.closers = []io.Closer{z, f}
+	default:
+		mw = NewWriter(f)
+		mw.closers = []io.Closer{f}
+	}
+	return mw, nil
+}
+
+// Write writes a record. A PEER_INDEX_TABLE only provides the collector ID
+// and view name of the rebuilt table; the peer indexes of RIB entries are
+// assigned anew.
+func (w *Writer) Write(rec Record) error {
+	switch r := rec.(type) {
+	case *PeerIndexTable:
+		if !w.collectorID.IsValid() {
+			w.collectorID, w.viewName = r.CollectorID, r.ViewName
+			w.tableTime = r.hdr.Timestamp
+		}
+		return nil
+	case *RIB:
+		return w.writeRIB(r)
+	case *BGP4MPMessage:
+		return w.writeMessage(r)
+	case *BGP4MPStateChange:
+		return w.writeStateChange(r)
+	case *Unknown:
+		return w.writeRecord(r.hdr.Timestamp, r.hdr.Type, r.hdr.Subtype, r.Data)
+	}
+	return fmt.Errorf("mrt: cannot write %T", rec)
+}
+
+// Close writes the peer index table and the records held back for it, and
+// closes the file opened by Create.
+func (w *Writer) Close() error {
+	err := w.finish()
+	for _, c := range w.closers {
+		if cerr := c.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+func (w *Writer) finish() error {
+	if w.spool != nil {
+		defer func() {
+			w.spool.Close()
+			os.Remove(w.spool.Name())
+		}()
+		if err := w.w.Flush(); err != nil {
+			return err
+		}
+		if err := w.writePeerIndexTable(); err != nil {
+			return err
+		}
+		if _, err := w.spool.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.Copy(w.out, w.spool); err != nil {
+			return err
+		}
+	}
+	return w.out.Flush()
+}
+
+// writePeerIndexTable writes the rebuilt table to the output
+func (w *Writer) writePeerIndexTable() error {
+	id := w.collectorID
+	if !id.Is4() {
+		id = netip.IPv4Unspecified()
+	}
+	b := append(w.buf[:0], id.AsSlice()...)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(w.viewName)))
+	b = append(b, w.viewName...)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(w.peers)))
+	for _, p := range w.peers {
+		typ := byte(0x02) // 4-byte AS
+		if p.IP.Is6() {
+			typ |= 0x01
+		}
+		b = append(b, typ)
+		bgpID := p.BGPID
+		if !bgpID.Is4() {
+			bgpID = netip.IPv4Unspecified()
+		}
+		b = append(b, bgpID.AsSlice()...)
+		b = append(b, p.IP.AsSlice()...)
+		b = binary.BigEndian.AppendUint32(b, p.AS)
+	}
+	w.buf = b
+	return writeRecord(w.out, w.tableTime, TypeTableDumpV2, SubtypePeerIndexTable, b)
+}
+
+func (w *Writer) writeRIB(r *RIB) error {
+	if w.spool == nil {
+		if err := w.startSpool(); err != nil {
+			return err
+		}
+	}
+	if w.tableTime.IsZero() {
+		w.tableTime = r.hdr.Timestamp
+	}
+
+	var subtype uint16
+	switch {
+	case r.AFI == AFIIPv4 && r.SAFI == SAFIUnicast:
+		subtype = SubtypeRIBIPv4Unicast
+	case r.AFI == AFIIPv4 && r.SAFI == SAFIMulticast:
+		subtype = SubtypeRIBIPv4Multicast
+	case r.AFI == AFIIPv6 && r.SAFI == SAFIUnicast:
+		subtype = SubtypeRIBIPv6Unicast
+	case r.AFI == AFIIPv6 && r.SAFI == SAFIMulticast:
+		subtype = SubtypeRIBIPv6Multicast
+	default:
+		subtype = SubtypeRIBGeneric
+	}
+	if r.AddPath {
+		subtype += SubtypeRIBIPv4UnicastAddPath - SubtypeRIBIPv4Unicast
+	}
+
+	b := binary.BigEndian.AppendUint32(w.buf[:0], w.sequence)
+	w.sequence++
+	if subtype == SubtypeRIBGeneric || subtype == SubtypeRIBGenericAddPath {
+		b = binary.BigEndian.AppendUint16(b, uint16(r.AFI))
+		b = append(b, byte(r.SAFI))
+	}
+	b = appendPrefix(b, r.Prefix)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(r.Entries)))
+	for _, e := range r.Entries {
+		idx, err := w.peerIndex(e.Peer)
+		if err != nil {
+			return err
+		}
+		b = binary.BigEndian.AppendUint16(b, idx)
+		b = binary.BigEndian.AppendUint32(b, uint32(e.Originated.Unix()))
+		if r.AddPath {
+			b = binary.BigEndian.AppendUint32(b, e.PathID)
+		}
+		// RIB entries are always encoded with 4-byte AS numbers and the
+		// abbreviated MP_REACH_NLRI, so the attributes are copied as they are
+		raw := e.Attributes.Bytes()
+		b = binary.BigEndian.AppendUint16(b, uint16(len(raw)))
+		b = append(b, raw...)
+	}
+	w.buf = b
+	return w.writeRecord(r.hdr.Timestamp, TypeTableDumpV2, subtype, b)
+}
+
+// peerIndex returns the index of a peer in the rebuilt table
+func (w *Writer) peerIndex(p Peer) (uint16, error) {
+	if idx, ok := w.index[p]; ok {
+		return idx, nil
+	}
+	// The table counts its peers in 16 bits
+	if len(w.peers) >= 0xffff {
+		return 0, fmt.Errorf("mrt: more than %d peers", 0xffff)
+	}
+	idx := uint16(len(w.peers))
+	w.peers = append(w.peers, p)
+	w.index[p] = idx
+	return idx, nil
+}
+
+// startSpool redirects records to a temporary file until Close
+func (w *Writer) startSpool() error {
+	if err := w.out.Flush(); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp("", "mrt-*")
+	if err != nil {
+		return err
+	}
+	w.spool = f
+	w.w = bufio.NewWriterSize(f, 1<<16)
+	return nil
+}
+
+func (w *Writer) writeMessage(m *BGP4MPMessage) error {
+	var subtype uint16
+	switch {
+	case m.Local && m.AddPath:
+		subtype = SubtypeMessageLocalAddPath
+	case m.Local:
+		subtype = SubtypeMessageLocal
+	case m.AddPath:
+		subtype = SubtypeMessageAddPath
+	default:
+		subtype = SubtypeMessage
+	}
+	if m.AS4 {
+		// Each AS4 subtype follows its 2-byte counterpart, but for the first
+		if subtype == SubtypeMessage {
+			subtype = SubtypeMessageAS4
+		} else {
+			subtype++
+		}
+	}
+	b := appendBGP4MPHeader(w.buf[:0], &m.BGP4MPHeader, m.AS4)
+	b = append(b, m.Data...)
+	w.buf = b
+	return w.writeRecord(m.hdr.Timestamp, bgp4mpType(m.hdr), subtype, b)
+}
+
+func (w *Writer) writeStateChange(s *BGP4MPStateChange) error {
+	as4 := s.hdr.Subtype == SubtypeStateChangeAS4 || s.PeerAS > 0xffff || s.LocalAS > 0xffff
+	subtype := uint16(SubtypeStateChange)
+	if as4 {
+		subtype = SubtypeStateChangeAS4
+	}
+	b := appendBGP4MPHeader(w.buf[:0], &s.BGP4MPHeader, as4)
+	b = binary.BigEndian.AppendUint16(b, uint16(s.OldState))
+	b = binary.BigEndian.AppendUint16(b, uint16(s.NewState))
+	w.buf = b
+	return w.writeRecord(s.hdr.Timestamp, bgp4mpType(s.hdr), subtype, b)
+}
+
+// bgp4mpType keeps the extended timestamp of BGP4MP_ET records
+func bgp4mpType(hdr Header) uint16 {
+	if hdr.Type == TypeBGP4MPET {
+		return TypeBGP4MPET
+	}
+	return TypeBGP4MP
+}
+
+func (w *Writer) writeRecord(ts time.Time, typ, subtype uint16, body []byte) error {
+	return writeRecord(w.w, ts, typ, subtype, body)
+}
+
+// writeRecord writes the MRT header and body of a record
+func writeRecord(w io.Writer, ts time.Time, typ, subtype uint16, body []byte) error {
+	length := len(body)
+	if typ == TypeBGP4MPET {
+		length += 4
+	}
+	var hdr [16]byte
+	binary.BigEndian.PutUint32(hdr[0:], uint32(ts.Unix()))
+	binary.BigEndian.PutUint16(hdr[4:], typ)
+	binary.BigEndian.PutUint16(hdr[6:], subtype)
+	binary.BigEndian.PutUint32(hdr[8:], uint32(length))
+	n := 12
+	if typ == TypeBGP4MPET {
+		binary.BigEndian.PutUint32(hdr[12:], uint32(ts.Nanosecond()/1000))
+		n = 16
+	}
+	if _, err := w.Write(hdr[:n]); err != nil {
+		return err
+	}
+	_, err := w.Write(body)
+	return err
+}
+
+// appendBGP4MPHeader appends the session fields of a BGP4MP record
+func appendBGP4MPHeader(b []byte, h *BGP4MPHeader, as4 bool) []byte {
+	if as4 {
+		b = binary.BigEndian.AppendUint32(b, h.PeerAS)
+		b = binary.BigEndian.AppendUint32(b, h.LocalAS)
+	} else {
+		b = binary.BigEndian.AppendUint16(b, uint16(h.PeerAS))
+		b = binary.BigEndian.AppendUint16(b, uint16(h.LocalAS))
+	}
+	b = binary.BigEndian.AppendUint16(b, h.Interface)
+	afi, ipv6 := h.AFI, h.PeerIP.Is6()
+	if afi == 0 {
+		afi = AFIIPv4
+		if ipv6 {
+			afi = AFIIPv6
+		}
+	}
+	b = binary.BigEndian.AppendUint16(b, uint16(afi))
+	b = appendAddr(b, h.PeerIP, afi == AFIIPv6)
+	return appendAddr(b, h.LocalIP, afi == AFIIPv6)
+}
+
+// appendAddr appends an address in the size of the family, zero if unset
+func appendAddr(b []byte, a netip.Addr, ipv6 bool) []byte {
+	if ipv6 {
+		v := a.As16()
+		return append(b, v[:]...)
+	}
+	if !a.Is4() {
+		return append(b, 0, 0, 0, 0)
+	}
+	v := a.As4()
+	return append(b, v[:]...)
+}
+
+// appendPrefix appends a length-prefixed, truncated prefix
+func appendPrefix(b []byte, p netip.Prefix) []byte {
+	b = append(b, byte(p.Bits()))
+	return append(b, p.Addr().AsSlice()[:(p.Bits()+7)/8]...)
+}
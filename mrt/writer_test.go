@@ -0,0 +1,162 @@
+package mrt
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/netip"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"bgp_downloader/internal/mrttest"
+)
+
+// dumpOf returns the records of a RIB dump with its own peer index table:
+// each prefix is routed by every peer
+func dumpOf(ts uint32, peers []string, prefixes ...string) []byte {
+	table := mrttest.Cat(mrttest.IP("192.0.2.1"), mrttest.U16(4), []byte("test"), mrttest.U16(len(peers)))
+	for i, p := range peers {
+		typ := byte(2)
+		if netip.MustParseAddr(p).Is6() {
+			typ |= 1
+		}
+		table = mrttest.Cat(table, []byte{typ}, mrttest.IP("10.0.0.1"), mrttest.IP(p), mrttest.U32(64500+uint32(i)))
+	}
+	data := mrttest.Record(ts, TypeTableDumpV2, SubtypePeerIndexTable, table)
+	for i, prefix := range prefixes {
+		b := mrttest.Cat(mrttest.U32(uint32(i)), mrttest.Prefix(prefix), mrttest.U16(len(peers)))
+		for j := range peers {
+			b = mrttest.Cat(b, mrttest.U16(j), mrttest.U32(ts-uint32(j)), mrttest.U32(uint32(j+1)), mrttest.U16(len(ribAttrs)), ribAttrs)
+		}
+		data = mrttest.Cat(data, mrttest.Record(ts, TypeTableDumpV2, SubtypeRIBIPv4UnicastAddPath, b))
+	}
+	return data
+}
+
+// route is a RIB entry flattened for comparison
+type route struct {
+	prefix     netip.Prefix
+	peer       Peer
+	originated time.Time
+	pathID     uint32
+	attrs      string
+}
+
+func routes(recs []Record) []route {
+	var list []route
+	for _, rec := range recs {
+		if rib, ok := rec.(*RIB); ok {
+			for _, e := range rib.Entries {
+				list = append(list, route{rib.Prefix, e.Peer, e.Originated, e.PathID, string(e.Attributes.Bytes())})
+			}
+		}
+	}
+	return list
+}
+
+func TestWriterRoundTrip(t *testing.T) {
+	// The dumps share one peer, listed at different indexes
+	dump1 := dumpOf(1000, []string{"198.51.100.1", "198.51.100.2"}, "203.0.113.0/24", "198.18.0.0/15")
+	dump2 := dumpOf(2000, []string{"2001:db8::1", "198.51.100.2"}, "203.0.113.0/24")
+	update := mrttest.Record(2100, TypeBGP4MP, SubtypeMessageAS4, mrttest.Cat(
+		mrttest.U32(64500), mrttest.U32(64501), mrttest.U16(0), mrttest.U16(int(AFIIPv4)), mrttest.IP("198.51.100.1"), mrttest.IP("198.51.100.2"),
+		mrttest.Update(mrttest.Prefix("198.18.0.0/15"), nil, nil),
+	))
+	state := mrttest.Record(2200, TypeBGP4MPET, SubtypeStateChange, mrttest.Cat(
+		mrttest.U32(123456),
+		mrttest.U16(64500), mrttest.U16(64501), mrttest.U16(0), mrttest.U16(int(AFIIPv4)), mrttest.IP("198.51.100.1"), mrttest.IP("198.51.100.2"),
+		mrttest.U16(int(StateEstablished)), mrttest.U16(int(StateIdle)),
+	))
+	in, _, err := readAll(mrttest.Cat(dump1, dump2, update, state))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"out.mrt", "out.mrt.gz", "out.mrt.bz2"} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+			w, err := Create(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, rec := range in {
+				if err := w.Write(rec); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			r, err := Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			var out []Record
+			for {
+				rec, err := r.Next()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				out = append(out, rec)
+			}
+
+			// One table holding the three distinct peers, then the RIB
+			// records and messages in order
+			if len(out) != 6 {
+				t.Fatalf("got %d records, want 6", len(out))
+			}
+			table, ok := out[0].(*PeerIndexTable)
+			if !ok || len(table.Peers) != 3 || !table.Header().Timestamp.Equal(time.Unix(1000, 0)) {
+				t.Fatalf("got first record %+v", out[0])
+			}
+			if table.CollectorID != netip.MustParseAddr("192.0.2.1") || table.ViewName != "test" {
+				t.Errorf("got collector %s view %q", table.CollectorID, table.ViewName)
+			}
+			want, got := routes(in), routes(out)
+			if len(got) != len(want) {
+				t.Fatalf("got %d routes, want %d", len(got), len(want))
+			}
+			for i := range want {
+				if got[i] != want[i] {
+					t.Errorf("route %d: got %+v, want %+v", i, got[i], want[i])
+				}
+			}
+
+			m, ok := out[4].(*BGP4MPMessage)
+			if !ok || !m.AS4 || m.BGP4MPHeader != in[5].(*BGP4MPMessage).BGP4MPHeader ||
+				!bytes.Equal(m.Data, in[5].(*BGP4MPMessage).Data) {
+				t.Errorf("got message %+v", out[4])
+			}
+			s, ok := out[5].(*BGP4MPStateChange)
+			if !ok || s.Header().Type != TypeBGP4MPET || !s.Header().Timestamp.Equal(time.Unix(2200, 123456000)) ||
+				s.OldState != StateEstablished || s.NewState != StateIdle {
+				t.Errorf("got state change %+v", out[5])
+			}
+		})
+	}
+}
+
+func TestWriterPeerLimit(t *testing.T) {
+	w := NewWriter(io.Discard)
+	var p Peer
+	for i := 0; i < 0xffff; i++ {
+		p.IP = netip.AddrFrom4([4]byte{10, 0, byte(i >> 8), byte(i)})
+		if _, err := w.peerIndex(p); err != nil {
+			t.Fatalf("peer %d: %v", i, err)
+		}
+	}
+	// A known peer keeps its index
+	if idx, err := w.peerIndex(p); err != nil || idx != 0xfffe {
+		t.Errorf("got index %d, %v", idx, err)
+	}
+	p.IP = netip.MustParseAddr("192.0.2.1")
+	if _, err := w.peerIndex(p); err == nil {
+		t.Error("got no error for peer 65536")
+	}
+}
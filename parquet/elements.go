@@ -0,0 +1,161 @@
+package parquet
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"bgp_downloader/mrt"
+)
+
+// ElementColumns is the schema of the files written by ElementWriter, one
+// row per mrt.Element. Columns are only ever added at the end, so queries
+// against earlier files keep working; each is placed by the constant
+// ElementWriter fills it through.
+//
+// origin_as is the origin of the AS path and null if the path ends in an
+// AS set of several ASes. communities lists the standard and large
+// communities separated by spaces, as the text output of dump does. rpki is the route origin
+// validation state, null unless the routes were validated. timestamp is
+// the time of the record, which for RIB entries is the time of the dump;
+// originated is when the peer learned the route of a RIB entry.
+var ElementColumns = []Column{
+	colType:            {Name: "type", Type: String},
+	colTimestamp:       {Name: "timestamp", Type: Timestamp},
+	colSource:          {Name: "source", Type: String, Optional: true},
+	colCollector:       {Name: "collector", Type: String, Optional: true},
+	colPeerIP:          {Name: "peer_ip", Type: String},
+	colPeerASN:         {Name: "peer_asn", Type: Int64},
+	colPrefix:          {Name: "prefix", Type: String, Optional: true},
+	colPathID:          {Name: "path_id", Type: Int64, Optional: true},
+	colASPath:          {Name: "as_path", Type: String, Optional: true},
+	colOriginAS:        {Name: "origin_as", Type: Int64, Optional: true},
+	colOrigin:          {Name: "origin", Type: String, Optional: true},
+	colNextHop:         {Name: "next_hop", Type: String, Optional: true},
+	colLocalPref:       {Name: "local_pref", Type: Int64, Optional: true},
+	colMED:             {Name: "med", Type: Int64, Optional: true},
+	colCommunities:     {Name: "communities", Type: String, Optional: true},
+	colAtomicAggregate: {Name: "atomic_aggregate", Type: Boolean, Optional: true},
+	colAggregator:      {Name: "aggregator", Type: String, Optional: true},
+	colOldState:        {Name: "old_state", Type: String, Optional: true},
+	colNewState:        {Name: "new_state", Type: String, Optional: true},
+	colRPKI:            {Name: "rpki", Type: String, Optional: true},
+	colOriginated:      {Name: "originated", Type: Timestamp, Optional: true},
+}
+
+// Positions of the columns of ElementColumns
+const (
+	colType = iota
+	colTimestamp
+	colSource
+	colCollector
+	colPeerIP
+	colPeerASN
+	colPrefix
+	colPathID
+	colASPath
+	colOriginAS
+	colOrigin
+	colNextHop
+	colLocalPref
+	colMED
+	colCommunities
+	colAtomicAggregate
+	colAggregator
+	colOldState
+	colNewState
+	colRPKI
+	colOriginated
+	numElementColumns
+)
+
+// ElementWriter writes elements in the ElementColumns schema.
+type ElementWriter struct {
+	w   *Writer
+	row []Value
+}
+
+// NewElementWriter returns an ElementWriter writing to w.
+func NewElementWriter(w io.Writer, opts Options) *ElementWriter {
+	return &ElementWriter{w: NewWriter(w, ElementColumns, opts), row: make([]Value, numElementColumns)}
+}
+
+// CreateElementFile creates a Parquet file of elements.
+func CreateElementFile(path string, opts Options) (*ElementWriter, error) {
+	w, err := Create(path, ElementColumns, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &ElementWriter{w: w, row: make([]Value, numElementColumns)}, nil
+}
+
+// Write appends an element read from a collector of a source, with its
+// RPKI validation state. Any of them may be empty if unknown.
+func (w *ElementWriter) Write(source, collector string, e mrt.Element, rpki string) error {
+	null := Value{Null: true}
+	str := func(s string) Value {
+		if s == "" {
+			return null
+		}
+		return Value{Str: s}
+	}
+	row := w.row
+	for i := range row {
+		row[i] = null
+	}
+	row[colType] = Value{Str: e.Type.String()}
+	row[colTimestamp] = TimeValue(e.Time)
+	row[colSource] = str(source)
+	row[colCollector] = str(collector)
+	row[colPeerIP] = Value{Str: e.PeerIP.String()}
+	row[colPeerASN] = Value{Int: int64(e.PeerAS)}
+	if e.Prefix.IsValid() {
+		row[colPrefix] = Value{Str: e.Prefix.String()}
+		row[colPathID] = Value{Int: int64(e.PathID)}
+	}
+	if !e.Originated.IsZero() {
+		row[colOriginated] = TimeValue(e.Originated)
+	}
+	if e.Type == mrt.ElemState {
+		row[colOldState] = Value{Str: e.OldState.String()}
+		row[colNewState] = Value{Str: e.NewState.String()}
+	}
+	row[colRPKI] = str(rpki)
+
+	if a := e.Attributes; a != nil {
+		row[colASPath] = Value{Str: a.ASPath.String()}
+		if origins := a.ASPath.Origins(); len(origins) == 1 {
+			row[colOriginAS] = Value{Int: int64(origins[0])}
+		}
+		if a.Has(mrt.AttrOrigin) {
+			row[colOrigin] = Value{Str: a.Origin.String()}
+		}
+		if nh := a.NextHops(); len(nh) > 0 {
+			row[colNextHop] = Value{Str: nh[0].String()}
+		}
+		if a.Has(mrt.AttrLocalPref) {
+			row[colLocalPref] = Value{Int: int64(a.LocalPref)}
+		}
+		if a.Has(mrt.AttrMED) {
+			row[colMED] = Value{Int: int64(a.MED)}
+		}
+		var comms []string
+		for _, c := range a.Communities {
+			comms = append(comms, c.String())
+		}
+		for _, c := range a.LargeCommunities {
+			comms = append(comms, c.String())
+		}
+		row[colCommunities] = str(strings.Join(comms, " "))
+		row[colAtomicAggregate] = Value{Bool: a.AtomicAggregate}
+		if a.Aggregator != nil {
+			row[colAggregator] = Value{Str: fmt.Sprintf("%d %s", a.Aggregator.AS, a.Aggregator.Addr)}
+		}
+	}
+	return w.w.Write(row)
+}
+
+// Close completes the file.
+func (w *ElementWriter) Close() error {
+	return w.w.Close()
+}
@@ -0,0 +1,426 @@
+// Package parquet writes Apache Parquet files with flat schemas of required
+// and optional columns. Values are PLAIN encoded in gzip compressed pages,
+// which every Parquet reader supports; the package has no dependencies
+// outside the standard library.
+package parquet
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Type is the type of a column.
+type Type int
+
+const (
+	// Int64 is a signed 64-bit integer.
+	Int64 Type = iota
+	// Boolean is a boolean.
+	Boolean
+	// String is a UTF-8 string.
+	String
+	// Timestamp is a UTC timestamp in microseconds.
+	Timestamp
+)
+
+// Column describes a column of the schema.
+type Column struct {
+	Name     string
+	Type     Type
+	Optional bool
+}
+
+// Value is the value of a column in a row. Int holds Int64 and Timestamp
+// values, the latter in microseconds since the epoch.
+type Value struct {
+	Null bool
+	Int  int64
+	Bool bool
+	Str  string
+}
+
+// TimeValue returns the Timestamp value of t.
+func TimeValue(t time.Time) Value {
+	return Value{Int: t.UnixMicro()}
+}
+
+// Options tunes the layout of a file.
+type Options struct {
+	// RowGroupSize is the uncompressed size in bytes at which a row group
+	// is completed. Readers process row groups independently, so large
+	// exports should use large groups; the default is 128 MiB. The
+	// compressed data of the current row group is held in memory.
+	RowGroupSize int64
+	// PageSize is the uncompressed size in bytes at which a page is
+	// completed; the default is 1 MiB.
+	PageSize int
+}
+
+// Parquet constants, as defined in parquet.thrift
+const (
+	magic = "PAR1"
+
+	physBoolean   = 0
+	physInt64     = 2
+	physByteArray = 6
+
+	repetitionRequired = 0
+	repetitionOptional = 1
+
+	convertedUTF8            = 0
+	convertedTimestampMicros = 10
+
+	encodingPlain = 0
+	encodingRLE   = 3
+
+	codecGzip = 2
+
+	pageData = 0
+)
+
+// Writer writes rows to a Parquet file.
+type Writer struct {
+	w       *bufio.Writer
+	closer  io.Closer
+	offset  int64
+	err     error
+	opts    Options
+	columns []*column
+	groups  []rowGroup
+	rows    int64 // in the current row group
+	gz      *gzip.Writer
+	buf     bytes.Buffer
+}
+
+// column holds the data of a column in the current row group
+type column struct {
+	Column
+	values  []byte // PLAIN encoded values of the current page
+	nbools  int    // booleans packed into values
+	defs    []byte // definition levels of the current page, one bit each
+	count   int    // values of the current page, nulls included
+	chunk   []byte // completed pages of the row group
+	size    int64  // uncompressed size of the completed pages
+	nvalues int64
+	nulls   int64
+	min     int64
+	max     int64
+}
+
+// rowGroup is the metadata of a written row group
+type rowGroup struct {
+	rows         int64
+	size         int64
+	compressed   int64
+	columnChunks []columnChunk
+}
+
+type columnChunk struct {
+	offset       int64
+	nvalues      int64
+	nulls        int64
+	size         int64
+	compressed   int64
+	min, max     int64
+	hasMinMax    bool
+	optional     bool
+	physicalType int32
+}
+
+// NewWriter returns a Writer writing a file with the columns to w.
+// Closing the Writer does not close w.
+func NewWriter(w io.Writer, columns []Column, opts Options) *Writer {
+	if opts.RowGroupSize <= 0 {
+		opts.RowGroupSize = 128 << 20
+	}
+	if opts.PageSize <= 0 {
+		opts.PageSize = 1 << 20
+	}
+	pw := &Writer{w: bufio.NewWriterSize(w, 1<<16), opts: opts}
+	for _, c := range columns {
+		pw.columns = append(pw.columns, &column{Column: c})
+	}
+	pw.gz, _ = gzip.NewWriterLevel(&pw.buf, gzip.DefaultCompression)
+	pw.write([]byte(magic))
+	return pw
+}
+
+// Create creates a Parquet file with the columns.
+func Create(path string, columns []Column, opts Options) (*Writer, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := NewWriter(f, columns, opts)
+	w.closer = f
+	return w, nil
+}
+
+// Write appends a row, with a value for each column in order.
+func (w *Writer) Write(row []Value) error {
+	if w.err != nil {
+		return w.err
+	}
+	if len(row) != len(w.columns) {
+		return fmt.Errorf("parquet: row has %d values for %d columns", len(row), len(w.columns))
+	}
+	var size int64
+	for i, c := range w.columns {
+		if err := c.add(row[i]); err != nil {
+			return err
+		}
+		if len(c.values) >= w.opts.PageSize {
+			w.flushPage(c)
+		}
+		size += c.size + int64(len(c.values)+len(c.defs))
+	}
+	w.rows++
+	if size >= w.opts.RowGroupSize {
+		w.flushRowGroup()
+	}
+	return w.err
+}
+
+// Close completes the last row group and writes the file footer.
+func (w *Writer) Close() error {
+	if w.rows > 0 {
+		w.flushRowGroup()
+	}
+	footer := w.footer()
+	w.write(footer)
+	var n [4]byte
+	binary.LittleEndian.PutUint32(n[:], uint32(len(footer)))
+	w.write(n[:])
+	w.write([]byte(magic))
+	if w.err == nil {
+		w.err = w.w.Flush()
+	}
+	if w.closer != nil {
+		if err := w.closer.Close(); err != nil && w.err == nil {
+			w.err = err
+		}
+	}
+	return w.err
+}
+
+// add appends a value to the current page
+func (c *column) add(v Value) error {
+	if v.Null && !c.Optional {
+		return fmt.Errorf("parquet: null value in required column %s", c.Name)
+	}
+	if c.Optional {
+		if c.count%8 == 0 {
+			c.defs = append(c.defs, 0)
+		}
+		if !v.Null {
+			c.defs[len(c.defs)-1] |= 1 << (c.count % 8)
+		}
+	}
+	c.count++
+	c.nvalues++
+	if v.Null {
+		c.nulls++
+		return nil
+	}
+
+	switch c.Type {
+	case Int64, Timestamp:
+		c.values = binary.LittleEndian.AppendUint64(c.values, uint64(v.Int))
+		if c.nvalues-c.nulls == 1 || v.Int < c.min {
+			c.min = v.Int
+		}
+		if c.nvalues-c.nulls == 1 || v.Int > c.max {
+			c.max = v.Int
+		}
+	case Boolean:
+		if c.nbools%8 == 0 {
+			c.values = append(c.values, 0)
+		}
+		if v.Bool {
+			c.values[len(c.values)-1] |= 1 << (c.nbools % 8)
+		}
+		c.nbools++
+	case String:
+		c.values = binary.LittleEndian.AppendUint32(c.values, uint32(len(v.Str)))
+		c.values = append(c.values, v.Str...)
+	}
+	return nil
+}
+
+// flushPage compresses the current page of a column into its chunk
+func (w *Writer) flushPage(c *column) {
+	if c.count == 0 {
+		return
+	}
+	var data []byte
+	if c.Optional {
+		// Definition levels as a single bit-packed run of the RLE/bit-packing
+		// hybrid encoding, preceded by its length
+		levels := binary.AppendUvarint(nil, uint64(len(c.defs))<<1|1)
+		levels = append(levels, c.defs...)
+		data = binary.LittleEndian.AppendUint32(data, uint32(len(levels)))
+		data = append(data, levels...)
+	}
+	data = append(data, c.values...)
+
+	w.buf.Reset()
+	w.gz.Reset(&w.buf)
+	w.gz.Write(data)
+	if err := w.gz.Close(); err != nil && w.err == nil {
+		w.err = err
+	}
+
+	t := &thriftWriter{}
+	t.beginStruct()
+	t.i32(1, pageData)
+	t.i32(2, int32(len(data)))
+	t.i32(3, int32(w.buf.Len()))
+	t.structField(5)
+	t.i32(1, int32(c.count))
+	t.i32(2, encodingPlain)
+	t.i32(3, encodingRLE)
+	t.i32(4, encodingRLE)
+	t.endStruct()
+	t.endStruct()
+
+	c.chunk = append(c.chunk, t.b...)
+	c.chunk = append(c.chunk, w.buf.Bytes()...)
+	c.size += int64(len(t.b) + len(data))
+	c.values, c.defs = c.values[:0], c.defs[:0]
+	c.count, c.nbools = 0, 0
+}
+
+// flushRowGroup writes the chunks of the current row group
+func (w *Writer) flushRowGroup() {
+	g := rowGroup{rows: w.rows}
+	for _, c := range w.columns {
+		w.flushPage(c)
+		cc := columnChunk{
+			offset:       w.offset,
+			nvalues:      c.nvalues,
+			nulls:        c.nulls,
+			size:         c.size,
+			compressed:   int64(len(c.chunk)),
+			min:          c.min,
+			max:          c.max,
+			hasMinMax:    (c.Type == Int64 || c.Type == Timestamp) && c.nvalues > c.nulls,
+			optional:     c.Optional,
+			physicalType: physicalType(c.Type),
+		}
+		w.write(c.chunk)
+		g.columnChunks = append(g.columnChunks, cc)
+		g.size += cc.size
+		g.compressed += cc.compressed
+
+		c.chunk = c.chunk[:0]
+		c.size, c.nvalues, c.nulls, c.min, c.max = 0, 0, 0, 0, 0
+	}
+	w.groups = append(w.groups, g)
+	w.rows = 0
+}
+
+// footer encodes the FileMetaData
+func (w *Writer) footer() []byte {
+	t := &thriftWriter{}
+	t.beginStruct()
+	t.i32(1, 1) // version
+
+	t.list(2, thriftStruct, len(w.columns)+1)
+	t.beginStruct()
+	t.string(4, "schema")
+	t.i32(5, int32(len(w.columns)))
+	t.endStruct()
+	for _, c := range w.columns {
+		t.beginStruct()
+		t.i32(1, physicalType(c.Type))
+		if c.Optional {
+			t.i32(3, repetitionOptional)
+		} else {
+			t.i32(3, repetitionRequired)
+		}
+		t.string(4, c.Name)
+		switch c.Type {
+		case String:
+			t.i32(6, convertedUTF8)
+		case Timestamp:
+			t.i32(6, convertedTimestampMicros)
+		}
+		t.endStruct()
+	}
+
+	var rows int64
+	for _, g := range w.groups {
+		rows += g.rows
+	}
+	t.i64(3, rows)
+
+	t.list(4, thriftStruct, len(w.groups))
+	for _, g := range w.groups {
+		t.beginStruct()
+		t.list(1, thriftStruct, len(g.columnChunks))
+		for i, cc := range g.columnChunks {
+			t.beginStruct()
+			t.i64(2, cc.offset)
+			t.structField(3)
+			t.i32(1, cc.physicalType)
+			if cc.optional {
+				t.list(2, thriftI32, 2)
+				t.zigzag(encodingPlain)
+				t.zigzag(encodingRLE)
+			} else {
+				t.list(2, thriftI32, 1)
+				t.zigzag(encodingPlain)
+			}
+			t.list(3, thriftBinary, 1)
+			t.varint(uint64(len(w.columns[i].Name)))
+			t.b = append(t.b, w.columns[i].Name...)
+			t.i32(4, codecGzip)
+			t.i64(5, cc.nvalues)
+			t.i64(6, cc.size)
+			t.i64(7, cc.compressed)
+			t.i64(9, cc.offset)
+			t.structField(12) // statistics
+			t.i64(3, cc.nulls)
+			if cc.hasMinMax {
+				t.binary(5, binary.LittleEndian.AppendUint64(nil, uint64(cc.max)))
+				t.binary(6, binary.LittleEndian.AppendUint64(nil, uint64(cc.min)))
+			}
+			t.endStruct()
+			t.endStruct() // ColumnMetaData
+			t.endStruct() // ColumnChunk
+		}
+		t.i64(2, g.size)
+		t.i64(3, g.rows)
+		t.i64(6, g.compressed)
+		t.endStruct()
+	}
+
+	t.string(6, "bgp-downloader")
+	t.endStruct()
+	return t.b
+}
+
+func (w *Writer) write(b []byte) {
+	if w.err != nil {
+		return
+	}
+	n, err := w.w.Write(b)
+	w.offset += int64(n)
+	w.err = err
+}
+
+func physicalType(t Type) int32 {
+	switch t {
+	case Boolean:
+		return physBoolean
+	case String:
+		return physByteArray
+	}
+	return physInt64
+}
@@ -0,0 +1,444 @@
+package parquet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/netip"
+	"testing"
+	"time"
+
+	"bgp_downloader/mrt"
+)
+
+// The reader below decodes the files independently of the writer, from
+// the Thrift compact protocol and the Parquet format specification.
+
+// thriftReader decodes compact protocol structs into maps of field ids to
+// values: int64, bool, []byte, []any or map[int16]any
+type thriftReader struct {
+	b   []byte
+	err error
+}
+
+func (r *thriftReader) byte() byte {
+	if len(r.b) == 0 {
+		r.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	c := r.b[0]
+	r.b = r.b[1:]
+	return c
+}
+
+func (r *thriftReader) varint() uint64 {
+	v, n := binary.Uvarint(r.b)
+	if n <= 0 {
+		r.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *thriftReader) zigzag() int64 {
+	v := r.varint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (r *thriftReader) value(typ byte) any {
+	switch typ {
+	case thriftTrue:
+		return true
+	case thriftFalse:
+		return false
+	case 3: // byte
+		return int64(r.byte())
+	case 4, thriftI32, thriftI64:
+		return r.zigzag()
+	case thriftBinary:
+		n := int(r.varint())
+		if n > len(r.b) {
+			r.err = io.ErrUnexpectedEOF
+			return nil
+		}
+		v := r.b[:n]
+		r.b = r.b[n:]
+		return v
+	case thriftList:
+		h := r.byte()
+		n := int(h >> 4)
+		if n == 15 {
+			n = int(r.varint())
+		}
+		list := make([]any, 0, n)
+		for i := 0; i < n && r.err == nil; i++ {
+			if h&0x0f == thriftTrue || h&0x0f == thriftFalse {
+				list = append(list, r.byte() == thriftTrue)
+			} else {
+				list = append(list, r.value(h&0x0f))
+			}
+		}
+		return list
+	case thriftStruct:
+		return r.readStruct()
+	}
+	r.err = fmt.Errorf("unsupported thrift type %d", typ)
+	return nil
+}
+
+func (r *thriftReader) readStruct() map[int16]any {
+	s := make(map[int16]any)
+	var id int16
+	for r.err == nil {
+		h := r.byte()
+		if h == 0 {
+			break
+		}
+		if delta := h >> 4; delta != 0 {
+			id += int16(delta)
+		} else {
+			id = int16(r.zigzag())
+		}
+		s[id] = r.value(h & 0x0f)
+	}
+	return s
+}
+
+// readFile decodes the schema and rows of a file
+func readFile(t *testing.T, data []byte) (meta map[int16]any, columns []Column, rows [][]Value) {
+	t.Helper()
+	if !bytes.HasPrefix(data, []byte(magic)) || !bytes.HasSuffix(data, []byte(magic)) {
+		t.Fatal("missing magic")
+	}
+	n := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	tr := &thriftReader{b: data[len(data)-8-n : len(data)-8]}
+	meta = tr.readStruct()
+	if tr.err != nil || len(tr.b) != 0 {
+		t.Fatalf("footer: %v, %d bytes left", tr.err, len(tr.b))
+	}
+
+	schema := meta[2].([]any)
+	if root := schema[0].(map[int16]any); root[5].(int64) != int64(len(schema)-1) {
+		t.Fatalf("root has %d children for %d columns", root[5], len(schema)-1)
+	}
+	for _, e := range schema[1:] {
+		el := e.(map[int16]any)
+		c := Column{Name: string(el[4].([]byte)), Optional: el[3].(int64) == repetitionOptional}
+		switch {
+		case el[1].(int64) == physBoolean:
+			c.Type = Boolean
+		case el[1].(int64) == physByteArray:
+			c.Type = String
+		case el[6] != nil:
+			c.Type = Timestamp
+		default:
+			c.Type = Int64
+		}
+		columns = append(columns, c)
+	}
+
+	for _, g := range meta[4].([]any) {
+		group := g.(map[int16]any)
+		nrows := int(group[3].(int64))
+		groupRows := make([][]Value, nrows)
+		for i := range groupRows {
+			groupRows[i] = make([]Value, len(columns))
+		}
+		for i, cc := range group[1].([]any) {
+			md := cc.(map[int16]any)[3].(map[int16]any)
+			if name := string(md[3].([]any)[0].([]byte)); name != columns[i].Name {
+				t.Fatalf("chunk %d is for column %s", i, name)
+			}
+			offset, compressed := md[9].(int64), md[7].(int64)
+			values := readChunk(t, columns[i], data[offset:offset+compressed], nrows)
+			stats := md[12].(map[int16]any)
+			nulls := 0
+			for j, v := range values {
+				groupRows[j][i] = v
+				if v.Null {
+					nulls++
+				}
+			}
+			if stats[3].(int64) != int64(nulls) || md[5].(int64) != int64(nrows) {
+				t.Errorf("column %s: got %d nulls and %d values in metadata, want %d and %d",
+					columns[i].Name, stats[3], md[5], nulls, nrows)
+			}
+			if max, ok := stats[5].([]byte); ok {
+				lo, hi := int64(binary.LittleEndian.Uint64(stats[6].([]byte))), int64(binary.LittleEndian.Uint64(max))
+				for _, v := range values {
+					if !v.Null && (v.Int < lo || v.Int > hi) {
+						t.Errorf("column %s: value %d outside statistics [%d, %d]", columns[i].Name, v.Int, lo, hi)
+					}
+				}
+			}
+		}
+		rows = append(rows, groupRows...)
+	}
+	if meta[3].(int64) != int64(len(rows)) {
+		t.Errorf("footer counts %d rows, row groups %d", meta[3], len(rows))
+	}
+	return meta, columns, rows
+}
+
+// readChunk decodes the pages of a column chunk
+func readChunk(t *testing.T, c Column, chunk []byte, nrows int) []Value {
+	t.Helper()
+	var values []Value
+	for len(chunk) > 0 {
+		tr := &thriftReader{b: chunk}
+		h := tr.readStruct()
+		if tr.err != nil || h[1].(int64) != pageData {
+			t.Fatalf("column %s: page header %v: %v", c.Name, h, tr.err)
+		}
+		size := int(h[3].(int64))
+		zr, err := gzip.NewReader(bytes.NewReader(tr.b[:size]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		page, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) != int(h[2].(int64)) {
+			t.Fatalf("column %s: page of %d bytes, header says %d", c.Name, len(page), h[2])
+		}
+		chunk = tr.b[size:]
+
+		count := int(h[5].(map[int16]any)[1].(int64))
+		defined := make([]bool, count)
+		for i := range defined {
+			defined[i] = true
+		}
+		if c.Optional {
+			n := binary.LittleEndian.Uint32(page)
+			defined = decodeLevels(page[4:4+n], count)
+			page = page[4+n:]
+		}
+		bit := 0
+		for _, ok := range defined {
+			if !ok {
+				values = append(values, Value{Null: true})
+				continue
+			}
+			var v Value
+			switch c.Type {
+			case Int64, Timestamp:
+				v.Int = int64(binary.LittleEndian.Uint64(page))
+				page = page[8:]
+			case Boolean:
+				v.Bool = page[bit/8]&(1<<(bit%8)) != 0
+				bit++
+			case String:
+				n := binary.LittleEndian.Uint32(page)
+				v.Str = string(page[4 : 4+n])
+				page = page[4+n:]
+			}
+			values = append(values, v)
+		}
+	}
+	if len(values) != nrows {
+		t.Fatalf("column %s: got %d values for %d rows", c.Name, len(values), nrows)
+	}
+	return values
+}
+
+// decodeLevels decodes definition levels of bit width 1 in the RLE/bit
+// packing hybrid encoding
+func decodeLevels(b []byte, count int) []bool {
+	var levels []bool
+	for len(b) > 0 && len(levels) < count {
+		h, n := binary.Uvarint(b)
+		b = b[n:]
+		if h&1 == 0 { // RLE run
+			for i := uint64(0); i < h>>1; i++ {
+				levels = append(levels, b[0] == 1)
+			}
+			b = b[1:]
+			continue
+		}
+		groups := int(h >> 1)
+		for _, c := range b[:groups] {
+			for i := 0; i < 8; i++ {
+				levels = append(levels, c&(1<<i) != 0)
+			}
+		}
+		b = b[groups:]
+	}
+	return levels[:count]
+}
+
+func TestWriterRoundTrip(t *testing.T) {
+	columns := []Column{
+		{Name: "id", Type: Int64},
+		{Name: "name", Type: String},
+		{Name: "time", Type: Timestamp, Optional: true},
+		{Name: "flag", Type: Boolean, Optional: true},
+		{Name: "note", Type: String, Optional: true},
+	}
+	var want [][]Value
+	for i := 0; i < 5000; i++ {
+		want = append(want, []Value{
+			{Int: int64(i*7919%5000 - 2500)},
+			{Str: fmt.Sprintf("row %d", i)},
+			{Null: i%3 == 0, Int: int64(i) * 1e6},
+			{Null: i%5 == 0, Bool: i%2 == 0},
+			{Null: i%11 != 0, Str: fmt.Sprint(i)},
+		})
+		// Values of null entries are not stored
+		for j := range want[i] {
+			if want[i][j].Null {
+				want[i][j] = Value{Null: true}
+			}
+		}
+	}
+
+	tests := []struct {
+		name   string
+		opts   Options
+		groups int
+	}{
+		{"defaults", Options{}, 1},
+		{"small pages and groups", Options{RowGroupSize: 20000, PageSize: 1000}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w := NewWriter(&buf, columns, tt.opts)
+			for _, row := range want {
+				if err := w.Write(row); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			meta, gotColumns, got := readFile(t, buf.Bytes())
+			groups := len(meta[4].([]any))
+			if tt.groups > 0 && groups != tt.groups || tt.groups == 0 && groups < 2 {
+				t.Errorf("got %d row groups", groups)
+			}
+			for i, c := range gotColumns {
+				if c != columns[i] {
+					t.Errorf("got column %+v, want %+v", c, columns[i])
+				}
+			}
+			if len(got) != len(want) {
+				t.Fatalf("got %d rows, want %d", len(got), len(want))
+			}
+			for i := range want {
+				for j := range want[i] {
+					if got[i][j] != want[i][j] {
+						t.Fatalf("row %d column %s: got %+v, want %+v", i, columns[j].Name, got[i][j], want[i][j])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestWriterErrors(t *testing.T) {
+	w := NewWriter(io.Discard, []Column{{Name: "id", Type: Int64}}, Options{})
+	if err := w.Write([]Value{{Null: true}}); err == nil {
+		t.Error("null in required column accepted")
+	}
+	if err := w.Write([]Value{{Int: 1}, {Int: 2}}); err == nil {
+		t.Error("row with too many values accepted")
+	}
+}
+
+func TestElementWriter(t *testing.T) {
+	// Every position constant has a column, and no column was skipped
+	if len(ElementColumns) != numElementColumns {
+		t.Fatalf("got %d columns for %d positions", len(ElementColumns), numElementColumns)
+	}
+	for i, c := range ElementColumns {
+		if c.Name == "" {
+			t.Fatalf("no column at position %d", i)
+		}
+	}
+
+	ts := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	elems := []mrt.Element{
+		{
+			Type:       mrt.ElemRIB,
+			Time:       ts,
+			PeerIP:     netip.MustParseAddr("198.51.100.1"),
+			PeerAS:     64500,
+			Prefix:     netip.MustParsePrefix("203.0.113.0/24"),
+			Originated: ts.Add(-time.Hour),
+			Attributes: &mrt.Attributes{
+				ASPath:      mrt.ASPath{{Type: mrt.ASSequence, ASNs: []uint32{64500, 64501}}},
+				Communities: []mrt.Community{64500<<16 | 1},
+			},
+		},
+		{
+			Type:     mrt.ElemState,
+			Time:     ts.Add(time.Second),
+			PeerIP:   netip.MustParseAddr("2001:db8::1"),
+			PeerAS:   64502,
+			OldState: mrt.StateEstablished,
+			NewState: mrt.StateIdle,
+		},
+	}
+	var buf bytes.Buffer
+	w := NewElementWriter(&buf, Options{})
+	if err := w.Write("ripe", "rrc00", elems[0], "valid"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write("", "", elems[1], ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, columns, rows := readFile(t, buf.Bytes())
+	if len(columns) != len(ElementColumns) || len(rows) != 2 {
+		t.Fatalf("got %d columns and %d rows", len(columns), len(rows))
+	}
+	get := func(row int, name string) Value {
+		for i, c := range columns {
+			if c.Name == name {
+				return rows[row][i]
+			}
+		}
+		t.Fatalf("no column %s", name)
+		return Value{}
+	}
+	tests := []struct {
+		row  int
+		name string
+		want Value
+	}{
+		{0, "type", Value{Str: "rib"}},
+		{0, "timestamp", TimeValue(ts)},
+		{0, "source", Value{Str: "ripe"}},
+		{0, "prefix", Value{Str: "203.0.113.0/24"}},
+		{0, "path_id", Value{}},
+		{0, "as_path", Value{Str: "64500 64501"}},
+		{0, "origin_as", Value{Int: 64501}},
+		{0, "origin", Value{Null: true}},
+		{0, "communities", Value{Str: "64500:1"}},
+		{0, "atomic_aggregate", Value{}},
+		{0, "rpki", Value{Str: "valid"}},
+		{0, "originated", TimeValue(ts.Add(-time.Hour))},
+		{0, "old_state", Value{Null: true}},
+		{1, "type", Value{Str: "state"}},
+		{1, "collector", Value{Null: true}},
+		{1, "peer_ip", Value{Str: "2001:db8::1"}},
+		{1, "prefix", Value{Null: true}},
+		{1, "as_path", Value{Null: true}},
+		{1, "old_state", Value{Str: "Established"}},
+		{1, "new_state", Value{Str: "Idle"}},
+		{1, "originated", Value{Null: true}},
+	}
+	for _, tt := range tests {
+		if got := get(tt.row, tt.name); got != tt.want {
+			t.Errorf("row %d %s: got %+v, want %+v", tt.row, tt.name, got, tt.want)
+		}
+	}
+}
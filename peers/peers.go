@@ -0,0 +1,211 @@
+// Package peers summarises the peers of route collectors from their RIB
+// dumps and updates: table sizes, feed completeness and session activity.
+package peers
+
+import (
+	"net/netip"
+	"sort"
+	"time"
+
+	"bgp_downloader/mrt"
+)
+
+// Peer is the summary of a peer of a collector.
+type Peer struct {
+	Source    string
+	Collector string
+	IP        netip.Addr
+	AS        uint32
+	// BGPID is the BGP identifier listed in the peer index table, if any.
+	BGPID netip.Addr
+	// IPv4 and IPv6 are the numbers of unicast prefixes of the peer in the
+	// latest RIB dump of the collector listing it.
+	IPv4 int
+	IPv6 int
+	// FullIPv4 and FullIPv6 report full tables, as decided by Peers.
+	FullIPv4 bool
+	FullIPv6 bool
+	// Updates counts the BGP UPDATE messages received from the peer.
+	Updates int
+	// Ups and Downs count the session transitions into and out of the
+	// Established state.
+	Ups   int
+	Downs int
+	// LastSeen is the time of the last RIB dump with routes of the peer or
+	// of the last message or state change of the peer.
+	LastSeen time.Time
+
+	ribTime time.Time // time of the RIB dump the prefix counts are from
+}
+
+// Family returns "ipv4" or "ipv6", the address family of the peering.
+func (p Peer) Family() string {
+	if p.IP.Is4() || p.IP.Is4In6() {
+		return "ipv4"
+	}
+	return "ipv6"
+}
+
+// peerKey identifies a peer of a collector
+type peerKey struct {
+	source    string
+	collector string
+	ip        netip.Addr
+	as        uint32
+}
+
+// Summary accumulates the peers of the files of collectors. The zero value
+// is not usable; create one with New.
+type Summary struct {
+	// Skipped counts the records that could not be decoded.
+	Skipped int
+
+	peers map[peerKey]*Peer
+}
+
+// New returns an empty summary.
+func New() *Summary {
+	return &Summary{peers: make(map[peerKey]*Peer)}
+}
+
+// peer returns the summary of a peer, creating it if needed
+func (s *Summary) peer(k peerKey) *Peer {
+	p, ok := s.peers[k]
+	if !ok {
+		p = &Peer{Source: k.source, Collector: k.collector, IP: k.ip, AS: k.as}
+		s.peers[k] = p
+	}
+	return p
+}
+
+// seen records that the peer was seen at time t
+func (p *Peer) seen(t time.Time) {
+	if t.After(p.LastSeen) {
+		p.LastSeen = t
+	}
+}
+
+// ReadFile adds the peers of an MRT file of a collector: the peer index
+// table and RIB records of a dump, or the messages and state changes of an
+// updates file. The prefix counts of a peer are replaced by those of a
+// later RIB dump.
+func (s *Summary) ReadFile(source, collector, path string) error {
+	var dumpTime time.Time
+	listed := make(map[peerKey]bool)
+	counts := make(map[peerKey]*[2]int)
+	last := make(map[peerKey]int) // number of the last RIB record counted
+	records := 0
+	skipped, err := mrt.ForEachFile(path, func(rec mrt.Record) bool {
+		switch r := rec.(type) {
+		case *mrt.PeerIndexTable:
+			dumpTime = r.Header().Timestamp
+			for _, peer := range r.Peers {
+				k := peerKey{source, collector, peer.IP, peer.AS}
+				s.peer(k).BGPID = peer.BGPID
+				listed[k] = true
+			}
+
+		case *mrt.RIB:
+			records++
+			if r.SAFI != mrt.SAFIUnicast {
+				return true
+			}
+			family := 0
+			if r.Prefix.Addr().Is6() {
+				family = 1
+			}
+			for _, e := range r.Entries {
+				k := peerKey{source, collector, e.Peer.IP, e.Peer.AS}
+				// ADD-PATH dumps list a peer once per path
+				if last[k] == records {
+					continue
+				}
+				last[k] = records
+				c, ok := counts[k]
+				if !ok {
+					c = &[2]int{}
+					counts[k] = c
+				}
+				c[family]++
+			}
+
+		case *mrt.BGP4MPMessage:
+			if r.Local {
+				return true
+			}
+			p := s.peer(peerKey{source, collector, r.PeerIP, r.PeerAS})
+			if r.Type() == mrt.MsgUpdate {
+				p.Updates++
+			}
+			p.seen(r.Header().Timestamp)
+
+		case *mrt.BGP4MPStateChange:
+			p := s.peer(peerKey{source, collector, r.PeerIP, r.PeerAS})
+			if r.NewState == mrt.StateEstablished && r.OldState != mrt.StateEstablished {
+				p.Ups++
+			} else if r.OldState == mrt.StateEstablished && r.NewState != mrt.StateEstablished {
+				p.Downs++
+			}
+			p.seen(r.Header().Timestamp)
+		}
+		return true
+	})
+	s.Skipped += skipped
+	if err != nil {
+		return err
+	}
+
+	// Listed peers without routes have empty tables in this dump
+	for k := range counts {
+		listed[k] = true
+	}
+	for k := range listed {
+		p := s.peer(k)
+		if dumpTime.Before(p.ribTime) {
+			continue
+		}
+		p.ribTime = dumpTime
+		p.IPv4, p.IPv6 = 0, 0
+		if c, ok := counts[k]; ok {
+			p.IPv4, p.IPv6 = c[0], c[1]
+			p.seen(dumpTime)
+		}
+	}
+	return nil
+}
+
+// Peers returns the summaries ordered by source, collector and address. A
+// peer has a full table of a family if it carries at least ratio times the
+// prefixes of the largest table of the family among all peers.
+func (s *Summary) Peers(ratio float64) []Peer {
+	var max4, max6 int
+	for _, p := range s.peers {
+		if p.IPv4 > max4 {
+			max4 = p.IPv4
+		}
+		if p.IPv6 > max6 {
+			max6 = p.IPv6
+		}
+	}
+	list := make([]Peer, 0, len(s.peers))
+	for _, p := range s.peers {
+		peer := *p
+		peer.FullIPv4 = peer.IPv4 > 0 && float64(peer.IPv4) >= ratio*float64(max4)
+		peer.FullIPv6 = peer.IPv6 > 0 && float64(peer.IPv6) >= ratio*float64(max6)
+		list = append(list, peer)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if a.Source != b.Source {
+			return a.Source < b.Source
+		}
+		if a.Collector != b.Collector {
+			return a.Collector < b.Collector
+		}
+		if a.IP != b.IP {
+			return a.IP.Less(b.IP)
+		}
+		return a.AS < b.AS
+	})
+	return list
+}
@@ -0,0 +1,74 @@
+package peers
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"bgp_downloader/internal/mrttest"
+	"bgp_downloader/mrt"
+)
+
+func TestSummary(t *testing.T) {
+	route := func(peer int) mrttest.Route {
+		return mrttest.Route{Peer: peer, Attrs: mrttest.Attrs(mrttest.ASPath4(64500, 64600))}
+	}
+	peers := []mrttest.Peer{
+		{IP: "198.51.100.1", AS: 64500},
+		{IP: "198.51.100.2", AS: 64501},
+		{IP: "198.51.100.3", AS: 64502},
+	}
+	// The third peer is listed without routes
+	early := mrttest.WriteFile(t,
+		mrttest.PeerIndex(1000, peers...),
+		mrttest.RIB(1000, 0, "192.0.2.0/24", route(0), route(1)),
+		mrttest.RIB(1000, 1, "198.18.0.0/15", route(0)),
+		mrttest.RIB(1000, 2, "203.0.113.0/24", route(0)),
+	)
+	// The first peer only is listed in the later dump
+	late := mrttest.WriteFile(t,
+		mrttest.PeerIndex(2000, peers[0]),
+		mrttest.RIB(2000, 0, "192.0.2.0/24", route(0)),
+		mrttest.RIB(2000, 1, "198.18.0.0/15", route(0)),
+		mrttest.RIB(2000, 2, "2001:db8::/32", route(0)),
+	)
+	update := mrttest.Update(nil, mrttest.Attrs(mrttest.ASPath4(64501)), mrttest.Prefixes("192.0.2.0/24"))
+	keepalive := mrttest.Cat(make([]byte, 16), mrttest.U16(19), []byte{4})
+	established, idle := int(mrt.StateEstablished), int(mrt.StateIdle)
+	updates := mrttest.WriteFile(t,
+		mrttest.Message(1500, "198.51.100.2", 64501, update),
+		mrttest.Message(1510, "198.51.100.2", 64501, update),
+		mrttest.Message(1520, "198.51.100.2", 64501, keepalive),
+		mrttest.StateChange(1600, "198.51.100.2", 64501, established, idle),
+		mrttest.StateChange(1700, "198.51.100.2", 64501, idle, established),
+	)
+
+	s := New()
+	// The prefix counts of the later dump are kept whatever the order
+	for _, path := range []string{late, updates, early} {
+		if err := s.ReadFile("ripe", "rrc00", path); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := s.Peers(0.9)
+	want := []Peer{
+		{IP: netip.MustParseAddr("198.51.100.1"), AS: 64500, IPv4: 2, IPv6: 1, FullIPv4: true, FullIPv6: true, LastSeen: time.Unix(2000, 0)},
+		{IP: netip.MustParseAddr("198.51.100.2"), AS: 64501, IPv4: 1, Updates: 2, Ups: 1, Downs: 1, LastSeen: time.Unix(1700, 0)},
+		{IP: netip.MustParseAddr("198.51.100.3"), AS: 64502},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d peers", len(got))
+	}
+	for i, p := range got {
+		w := want[i]
+		if p.Source != "ripe" || p.Collector != "rrc00" || p.IP != w.IP || p.AS != w.AS ||
+			p.IPv4 != w.IPv4 || p.IPv6 != w.IPv6 || p.FullIPv4 != w.FullIPv4 || p.FullIPv6 != w.FullIPv6 ||
+			p.Updates != w.Updates || p.Ups != w.Ups || p.Downs != w.Downs || !p.LastSeen.Equal(w.LastSeen) {
+			t.Errorf("got peer %+v, want %+v", p, w)
+		}
+		if p.Family() != "ipv4" {
+			t.Errorf("%s: got family %s", p.IP, p.Family())
+		}
+	}
+}
@@ -0,0 +1,149 @@
+// Package pfx2as maps prefixes to the ASes originating them, as seen in the
+// RIB dumps of route collectors, in the manner of the CAIDA Routeviews
+// Prefix-to-AS datasets.
+package pfx2as
+
+import (
+	"bufio"
+	"io"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+
+	"bgp_downloader/mrt"
+)
+
+// Entry is the origin information of a prefix.
+type Entry struct {
+	Prefix netip.Prefix
+	// Origins are the distinct origins of the routes to the prefix in
+	// order, each a single AS or the members of the AS set ending a path.
+	// More than one origin is a multi-origin AS (MOAS) conflict.
+	Origins [][]uint32
+	// Peers counts the peer sessions with a route to the prefix, summed
+	// over the RIB dumps read.
+	Peers int
+}
+
+// String formats the entry as a line of a pfx2as file: the address, the
+// length, the origins and the number of peers, separated by tabs. AS set
+// members are joined by commas and MOAS origins by underscores.
+func (e Entry) String() string {
+	origins := make([]string, len(e.Origins))
+	for i, o := range e.Origins {
+		origins[i] = mrt.FormatOrigin(o)
+	}
+	return e.Prefix.Addr().String() + "\t" + strconv.Itoa(e.Prefix.Bits()) + "\t" +
+		strings.Join(origins, "_") + "\t" + strconv.Itoa(e.Peers)
+}
+
+// Table accumulates the origins of the unicast prefixes of RIB dumps. The
+// zero value is not usable; create one with New.
+type Table struct {
+	// Skipped counts the undecodable records, and the routes left out of
+	// the origins because their attributes could not be decoded.
+	Skipped int
+
+	entries map[netip.Prefix]*Entry
+}
+
+// New returns an empty table.
+func New() *Table {
+	return &Table{entries: make(map[netip.Prefix]*Entry)}
+}
+
+// AddRIB adds the routes of a RIB record. Multicast records are ignored, as
+// are routes without an origin, such as those of iBGP peers.
+func (t *Table) AddRIB(r *mrt.RIB) {
+	if r.SAFI != mrt.SAFIUnicast {
+		return
+	}
+	seen := make(map[mrt.Peer]bool, len(r.Entries))
+	for _, re := range r.Entries {
+		attrs, err := re.Attributes.Decode()
+		if err != nil {
+			t.Skipped++
+			continue
+		}
+		origin := attrs.ASPath.Origins()
+		if len(origin) == 0 {
+			continue
+		}
+		e, ok := t.entries[r.Prefix]
+		if !ok {
+			e = &Entry{Prefix: r.Prefix}
+			t.entries[r.Prefix] = e
+		}
+		e.addOrigin(origin)
+		if !seen[re.Peer] {
+			seen[re.Peer] = true
+			e.Peers++
+		}
+	}
+}
+
+// addOrigin adds an origin unless it is already known. AS set members are
+// sorted so that sets listing the same ASes compare equal.
+func (e *Entry) addOrigin(origin []uint32) {
+	if !sort.SliceIsSorted(origin, func(i, j int) bool { return origin[i] < origin[j] }) {
+		origin = append([]uint32(nil), origin...)
+		sort.Slice(origin, func(i, j int) bool { return origin[i] < origin[j] })
+	}
+	i := sort.Search(len(e.Origins), func(i int) bool { return compareOrigin(e.Origins[i], origin) >= 0 })
+	if i < len(e.Origins) && compareOrigin(e.Origins[i], origin) == 0 {
+		return
+	}
+	e.Origins = append(e.Origins, nil)
+	copy(e.Origins[i+1:], e.Origins[i:])
+	e.Origins[i] = append([]uint32(nil), origin...)
+}
+
+// compareOrigin orders origins by their AS numbers
+func compareOrigin(a, b []uint32) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return len(a) - len(b)
+}
+
+// ReadFile adds the RIB records of an MRT file.
+func (t *Table) ReadFile(path string) error {
+	skipped, err := mrt.ForEachFile(path, func(rec mrt.Record) bool {
+		if rib, ok := rec.(*mrt.RIB); ok {
+			t.AddRIB(rib)
+		}
+		return true
+	})
+	t.Skipped += skipped
+	return err
+}
+
+// Entries returns the entries of the prefixes seen by at least minPeers
+// peers, IPv4 before IPv6 and ordered by address, then length.
+func (t *Table) Entries(minPeers int) []Entry {
+	entries := make([]Entry, 0, len(t.entries))
+	for _, e := range t.entries {
+		if e.Peers >= minPeers {
+			entries = append(entries, *e)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return mrt.ComparePrefix(entries[i].Prefix, entries[j].Prefix) < 0 })
+	return entries
+}
+
+// Write writes the entries seen by at least minPeers peers to w in the
+// format of Entry.String, one per line.
+func (t *Table) Write(w io.Writer, minPeers int) error {
+	bw := bufio.NewWriter(w)
+	for _, e := range t.Entries(minPeers) {
+		bw.WriteString(e.String())
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
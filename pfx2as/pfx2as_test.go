@@ -0,0 +1,60 @@
+package pfx2as
+
+import (
+	"strings"
+	"testing"
+
+	"bgp_downloader/internal/mrttest"
+)
+
+func TestTable(t *testing.T) {
+	dump := mrttest.WriteFile(t,
+		mrttest.PeerIndex(1000,
+			mrttest.Peer{IP: "198.51.100.1", AS: 64500},
+			mrttest.Peer{IP: "198.51.100.2", AS: 64501},
+			mrttest.Peer{IP: "2001:db8::2", AS: 64502},
+		),
+		// Both peers see the same origin, which is listed once
+		mrttest.RIB(1000, 0, "203.0.113.0/24",
+			mrttest.Route{Peer: 0, Attrs: mrttest.Attrs(mrttest.ASPath4(64500, 64600))},
+			mrttest.Route{Peer: 1, Attrs: mrttest.Attrs(mrttest.ASPath4(64501, 64501, 64600))},
+		),
+		// The AS sets list the same ASes in different orders
+		mrttest.RIB(1000, 1, "198.18.0.0/15",
+			mrttest.Route{Peer: 0, Attrs: mrttest.Attrs(mrttest.ASPath4(64500), mrttest.ASSet4(64602, 64601))},
+			mrttest.Route{Peer: 1, Attrs: mrttest.Attrs(mrttest.ASPath4(64501), mrttest.ASSet4(64601, 64602))},
+			mrttest.Route{Peer: 2, Attrs: mrttest.Attrs(mrttest.ASPath4(64502, 64599))},
+		),
+		// A route without an origin is left out
+		mrttest.RIB(1000, 2, "192.0.2.0/24", mrttest.Route{Peer: 0, Attrs: mrttest.Attrs()}),
+		mrttest.RIB(1000, 3, "2001:db8:1::/48",
+			mrttest.Route{Peer: 2, Attrs: mrttest.Attrs(mrttest.ASPath4(64502, 64603))},
+		),
+		// Truncated attributes are skipped
+		mrttest.RIB(1000, 4, "203.0.114.0/24", mrttest.Route{Peer: 0, Attrs: []byte{0x40, 2, 6, 2, 1}}),
+	)
+
+	tbl := New()
+	if err := tbl.ReadFile(dump); err != nil {
+		t.Fatal(err)
+	}
+	if tbl.Skipped != 1 {
+		t.Errorf("got %d skipped, want 1", tbl.Skipped)
+	}
+
+	var b strings.Builder
+	if err := tbl.Write(&b, 1); err != nil {
+		t.Fatal(err)
+	}
+	want := "198.18.0.0\t15\t64599_64601,64602\t3\n" +
+		"203.0.113.0\t24\t64600\t2\n" +
+		"2001:db8:1::\t48\t64603\t1\n"
+	if b.String() != want {
+		t.Errorf("got\n%swant\n%s", b.String(), want)
+	}
+
+	entries := tbl.Entries(2)
+	if len(entries) != 2 || entries[0].Prefix.String() != "198.18.0.0/15" || entries[1].Prefix.String() != "203.0.113.0/24" {
+		t.Errorf("got entries %v with 2 peers", entries)
+	}
+}
@@ -0,0 +1,229 @@
+package ribstate
+
+import (
+	"bytes"
+	"net/netip"
+	"sort"
+
+	"bgp_downloader/mrt"
+)
+
+// Changes are the differences between two states of a collector.
+type Changes struct {
+	// Added are the prefixes carried by some peer only in the second
+	// state, Removed those carried only in the first.
+	Added   []PrefixOrigins
+	Removed []PrefixOrigins
+	// OriginChanges are the prefixes carried in both states whose set of
+	// origins across all peers differs.
+	OriginChanges []OriginChange
+	// PathChanges are the routes of a peer in both states whose AS path
+	// differs.
+	PathChanges []PathChange
+	// Peers are the table size changes of the peers of either state,
+	// ordered by address.
+	Peers []PeerChange
+	// Skipped counts the routes whose attributes could not be decoded.
+	Skipped int
+}
+
+// PrefixOrigins is a prefix with its origins across all peers. An origin is
+// an AS number, or the members of an AS set separated by commas.
+type PrefixOrigins struct {
+	Prefix  netip.Prefix
+	Origins []string
+}
+
+// OriginChange is a change of the origins of a prefix.
+type OriginChange struct {
+	Prefix netip.Prefix
+	Before []string
+	After  []string
+}
+
+// PathChange is a change of the AS path of a route of a peer.
+type PathChange struct {
+	PeerIP netip.Addr
+	PeerAS uint32
+	Prefix netip.Prefix
+	PathID uint32
+	Before mrt.ASPath
+	After  mrt.ASPath
+}
+
+// PeerChange is the change of the table of a peer.
+type PeerChange struct {
+	PeerIP netip.Addr
+	PeerAS uint32
+	// Before and After are the numbers of routes in the two states.
+	Before int
+	After  int
+	// Added, Removed and Changed count the routes only in the second
+	// state, only in the first, and with a different AS path.
+	Added   int
+	Removed int
+	Changed int
+}
+
+// Delta returns the change of the number of routes.
+func (p PeerChange) Delta() int {
+	return p.After - p.Before
+}
+
+// Diff compares the state a with the later state b.
+func Diff(a, b *State) *Changes {
+	c := &Changes{}
+	before := c.origins(a)
+	after := c.origins(b)
+	for p, o := range after {
+		old, ok := before[p]
+		if !ok {
+			c.Added = append(c.Added, PrefixOrigins{p, o})
+		} else if !equalStrings(old, o) {
+			c.OriginChanges = append(c.OriginChanges, OriginChange{p, old, o})
+		}
+	}
+	for p, o := range before {
+		if _, ok := after[p]; !ok {
+			c.Removed = append(c.Removed, PrefixOrigins{p, o})
+		}
+	}
+	sort.Slice(c.Added, func(i, j int) bool { return mrt.ComparePrefix(c.Added[i].Prefix, c.Added[j].Prefix) < 0 })
+	sort.Slice(c.Removed, func(i, j int) bool { return mrt.ComparePrefix(c.Removed[i].Prefix, c.Removed[j].Prefix) < 0 })
+	sort.Slice(c.OriginChanges, func(i, j int) bool {
+		return mrt.ComparePrefix(c.OriginChanges[i].Prefix, c.OriginChanges[j].Prefix) < 0
+	})
+
+	peers := make(map[netip.Addr]bool)
+	for ip := range a.tables {
+		peers[ip] = true
+	}
+	for ip := range b.tables {
+		peers[ip] = true
+	}
+	for ip := range peers {
+		c.Peers = append(c.Peers, c.diffTables(ip, a.tables[ip], b.tables[ip]))
+	}
+	sort.Slice(c.Peers, func(i, j int) bool { return c.Peers[i].PeerIP.Less(c.Peers[j].PeerIP) })
+	sort.Slice(c.PathChanges, func(i, j int) bool {
+		x, y := c.PathChanges[i], c.PathChanges[j]
+		if x.Prefix != y.Prefix {
+			return mrt.ComparePrefix(x.Prefix, y.Prefix) < 0
+		}
+		if x.PeerIP != y.PeerIP {
+			return x.PeerIP.Less(y.PeerIP)
+		}
+		return x.PathID < y.PathID
+	})
+	return c
+}
+
+// diffTables compares the tables of a peer in two states; either may be
+// nil if the peer is missing from its state
+func (c *Changes) diffTables(ip netip.Addr, ta, tb *Table) PeerChange {
+	pc := PeerChange{PeerIP: ip}
+	var before, after map[routeKey]Route
+	if ta != nil {
+		pc.PeerAS, pc.Before, before = ta.PeerAS, ta.Len(), ta.routes
+	}
+	if tb != nil {
+		pc.PeerAS, pc.After, after = tb.PeerAS, tb.Len(), tb.routes
+	}
+	for k, rb := range after {
+		ra, ok := before[k]
+		if !ok {
+			pc.Added++
+			continue
+		}
+		if bytes.Equal(ra.Attributes.Bytes(), rb.Attributes.Bytes()) {
+			continue
+		}
+		// Undecodable routes were counted as skipped with the origins
+		attrsA, err := ra.Attributes.Decode()
+		if err != nil {
+			continue
+		}
+		attrsB, err := rb.Attributes.Decode()
+		if err != nil {
+			continue
+		}
+		if !equalPaths(attrsA.ASPath, attrsB.ASPath) {
+			pc.Changed++
+			c.PathChanges = append(c.PathChanges, PathChange{
+				PeerIP: ip,
+				PeerAS: pc.PeerAS,
+				Prefix: k.prefix,
+				PathID: k.pathID,
+				Before: attrsA.ASPath,
+				After:  attrsB.ASPath,
+			})
+		}
+	}
+	for k := range before {
+		if _, ok := after[k]; !ok {
+			pc.Removed++
+		}
+	}
+	return pc
+}
+
+// origins returns the sorted origins of every prefix of a state
+func (c *Changes) origins(s *State) map[netip.Prefix][]string {
+	sets := make(map[netip.Prefix]map[string]bool)
+	for _, t := range s.tables {
+		for k, r := range t.routes {
+			set, ok := sets[k.prefix]
+			if !ok {
+				set = make(map[string]bool)
+				sets[k.prefix] = set
+			}
+			attrs, err := r.Attributes.Decode()
+			if err != nil {
+				c.Skipped++
+				continue
+			}
+			if origin := attrs.ASPath.OriginString(); origin != "" {
+				set[origin] = true
+			}
+		}
+	}
+	origins := make(map[netip.Prefix][]string, len(sets))
+	for p, set := range sets {
+		list := make([]string, 0, len(set))
+		for o := range set {
+			list = append(list, o)
+		}
+		sort.Strings(list)
+		origins[p] = list
+	}
+	return origins
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func equalPaths(a, b mrt.ASPath) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Type != b[i].Type || len(a[i].ASNs) != len(b[i].ASNs) {
+			return false
+		}
+		for j := range a[i].ASNs {
+			if a[i].ASNs[j] != b[i].ASNs[j] {
+				return false
+			}
+		}
+	}
+	return true
+}
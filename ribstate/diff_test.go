@@ -0,0 +1,55 @@
+package ribstate
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"bgp_downloader/internal/mrttest"
+)
+
+func TestDiff(t *testing.T) {
+	route := func(peer int, path ...uint32) mrttest.Route {
+		return mrttest.Route{Peer: peer, Attrs: mrttest.Attrs(mrttest.ASPath4(path...))}
+	}
+	before := mrttest.WriteFile(t,
+		mrttest.PeerIndex(1000, mrttest.Peer{IP: "198.51.100.1", AS: 64500}, mrttest.Peer{IP: "198.51.100.2", AS: 64501}),
+		mrttest.RIB(1000, 0, "192.0.2.0/24", route(0, 64500, 64600), route(1, 64501, 64600)),
+		mrttest.RIB(1000, 1, "198.18.0.0/15", route(0, 64500, 64601)),
+	)
+	after := mrttest.WriteFile(t,
+		mrttest.PeerIndex(2000, mrttest.Peer{IP: "198.51.100.1", AS: 64500}, mrttest.Peer{IP: "198.51.100.3", AS: 64502}),
+		mrttest.RIB(2000, 0, "192.0.2.0/24", route(0, 64500, 64610, 64600), route(1, 64502, 64602)),
+		mrttest.RIB(2000, 1, "203.0.113.0/24", route(0, 64500, 64603)),
+	)
+	a, err := Reconstruct(before, nil, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Reconstruct(after, nil, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := Diff(a, b)
+	for _, tt := range []struct {
+		name      string
+		got, want string
+	}{
+		{"added", fmt.Sprint(c.Added), "[{203.0.113.0/24 [64603]}]"},
+		{"removed", fmt.Sprint(c.Removed), "[{198.18.0.0/15 [64601]}]"},
+		{"origin changes", fmt.Sprint(c.OriginChanges), "[{192.0.2.0/24 [64600] [64600 64602]}]"},
+		{"path changes", fmt.Sprint(c.PathChanges), "[{198.51.100.1 64500 192.0.2.0/24 0 64500 64600 64500 64610 64600}]"},
+		{"peers", fmt.Sprint(c.Peers), "[{198.51.100.1 64500 2 2 1 1 1} {198.51.100.2 64501 1 0 0 1 0} {198.51.100.3 64502 0 1 1 0 0}]"},
+	} {
+		if tt.got != tt.want {
+			t.Errorf("got %s %s, want %s", tt.name, tt.got, tt.want)
+		}
+	}
+	if c.Skipped != 0 {
+		t.Errorf("got %d skipped", c.Skipped)
+	}
+	if d := c.Peers[1].Delta(); d != -1 {
+		t.Errorf("got delta %d", d)
+	}
+}
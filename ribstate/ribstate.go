@@ -0,0 +1,224 @@
+// Package ribstate maintains the routing tables of the peers of a route
+// collector, starting from a RIB dump and replaying updates.
+package ribstate
+
+import (
+	"net/netip"
+	"sort"
+	"time"
+
+	"bgp_downloader/mrt"
+)
+
+// Route is a route in the table of a peer.
+type Route struct {
+	Prefix netip.Prefix
+	PathID uint32
+	// Time is when the route was learned: the originated time of a RIB
+	// entry or the time of the update announcing it.
+	Time       time.Time
+	Attributes mrt.PathAttributes
+}
+
+// routeKey identifies a route within a table
+type routeKey struct {
+	prefix netip.Prefix
+	pathID uint32
+}
+
+// Table is the routing table of one peer.
+type Table struct {
+	PeerIP netip.Addr
+	PeerAS uint32
+	routes map[routeKey]Route
+}
+
+// Len returns the number of routes in the table.
+func (t *Table) Len() int {
+	return len(t.routes)
+}
+
+// Lookup returns the route to the exact prefix, if any.
+func (t *Table) Lookup(prefix netip.Prefix, pathID uint32) (Route, bool) {
+	r, ok := t.routes[routeKey{prefix, pathID}]
+	return r, ok
+}
+
+// Routes returns the routes of the table sorted by prefix.
+func (t *Table) Routes() []Route {
+	routes := make([]Route, 0, len(t.routes))
+	for _, r := range t.routes {
+		routes = append(routes, r)
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		a, b := routes[i], routes[j]
+		if a.Prefix != b.Prefix {
+			return mrt.ComparePrefix(a.Prefix, b.Prefix) < 0
+		}
+		return a.PathID < b.PathID
+	})
+	return routes
+}
+
+// Element returns the route as a RIB element of the table's peer, stamped
+// with the time at as if taken from a dump of the state then.
+func (t *Table) Element(r Route, at time.Time) (mrt.Element, error) {
+	attrs, err := r.Attributes.Decode()
+	if err != nil {
+		return mrt.Element{}, err
+	}
+	return mrt.Element{
+		Type:       mrt.ElemRIB,
+		Time:       at,
+		PeerIP:     t.PeerIP,
+		PeerAS:     t.PeerAS,
+		Prefix:     r.Prefix,
+		PathID:     r.PathID,
+		Originated: r.Time,
+		Attributes: attrs,
+	}, nil
+}
+
+// State is the set of peer tables of a collector. The zero value is not
+// usable; create one with New.
+type State struct {
+	// Time is the timestamp of the last record applied.
+	Time time.Time
+	// Skipped counts the records that could not be decoded or applied.
+	Skipped int
+
+	tables map[netip.Addr]*Table
+}
+
+// New returns an empty state.
+func New() *State {
+	return &State{tables: make(map[netip.Addr]*Table)}
+}
+
+// table returns the table of the peer, creating it if needed
+func (s *State) table(ip netip.Addr, as uint32) *Table {
+	t, ok := s.tables[ip]
+	if !ok {
+		t = &Table{PeerIP: ip, PeerAS: as, routes: make(map[routeKey]Route)}
+		s.tables[ip] = t
+	}
+	t.PeerAS = as
+	return t
+}
+
+// Apply updates the state with a record: RIB entries and announcements
+// replace the route of the peer, withdrawals remove it, and a session
+// leaving the Established state clears the table of the peer.
+func (s *State) Apply(rec mrt.Record) error {
+	s.Time = rec.Header().Timestamp
+	switch r := rec.(type) {
+	case *mrt.RIB:
+		for _, e := range r.Entries {
+			s.table(e.Peer.IP, e.Peer.AS).routes[routeKey{r.Prefix, e.PathID}] = Route{
+				Prefix:     r.Prefix,
+				PathID:     e.PathID,
+				Time:       e.Originated,
+				Attributes: e.Attributes,
+			}
+		}
+
+	case *mrt.BGP4MPMessage:
+		if r.Type() != mrt.MsgUpdate || r.Local {
+			return nil
+		}
+		u, err := r.Update()
+		if err != nil {
+			return err
+		}
+		announced, withdrawn, _, err := u.Routes()
+		if err != nil {
+			return err
+		}
+		t := s.table(r.PeerIP, r.PeerAS)
+		for _, n := range withdrawn {
+			delete(t.routes, routeKey{n.Prefix, n.PathID})
+		}
+		for _, n := range announced {
+			t.routes[routeKey{n.Prefix, n.PathID}] = Route{
+				Prefix:     n.Prefix,
+				PathID:     n.PathID,
+				Time:       r.Header().Timestamp,
+				Attributes: u.Attributes,
+			}
+		}
+
+	case *mrt.BGP4MPStateChange:
+		if r.OldState == mrt.StateEstablished && r.NewState != mrt.StateEstablished {
+			if t, ok := s.tables[r.PeerIP]; ok {
+				t.routes = make(map[routeKey]Route)
+			}
+		}
+	}
+	return nil
+}
+
+// Peers returns the tables of all peers sorted by peer address.
+func (s *State) Peers() []*Table {
+	tables := make([]*Table, 0, len(s.tables))
+	for _, t := range s.tables {
+		tables = append(tables, t)
+	}
+	sort.Slice(tables, func(i, j int) bool { return tables[i].PeerIP.Less(tables[j].PeerIP) })
+	return tables
+}
+
+// Table returns the table of the peer with the given address.
+func (s *State) Table(peer netip.Addr) (*Table, bool) {
+	t, ok := s.tables[peer]
+	return t, ok
+}
+
+// Len returns the number of routes in all tables.
+func (s *State) Len() int {
+	n := 0
+	for _, t := range s.tables {
+		n += t.Len()
+	}
+	return n
+}
+
+// ReadFile applies the records of an MRT file up to and including the time
+// until; a zero until applies the whole file. It reports whether the end of
+// the file was reached.
+func (s *State) ReadFile(path string, until time.Time) (bool, error) {
+	complete := true
+	skipped, err := mrt.ForEachFile(path, func(rec mrt.Record) bool {
+		if !until.IsZero() && rec.Header().Timestamp.After(until) {
+			complete = false
+			return false
+		}
+		if err := s.Apply(rec); err != nil {
+			s.Skipped++
+		}
+		return true
+	})
+	s.Skipped += skipped
+	if err != nil {
+		return false, err
+	}
+	return complete, nil
+}
+
+// Reconstruct builds the state of the collector at time at from a RIB dump
+// and the updates files that follow it, given in time order.
+func Reconstruct(rib string, updates []string, at time.Time) (*State, error) {
+	s := New()
+	if _, err := s.ReadFile(rib, time.Time{}); err != nil {
+		return nil, err
+	}
+	for _, path := range updates {
+		complete, err := s.ReadFile(path, at)
+		if err != nil {
+			return nil, err
+		}
+		if !complete {
+			break
+		}
+	}
+	return s, nil
+}
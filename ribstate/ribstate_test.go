@@ -0,0 +1,151 @@
+package ribstate
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"bgp_downloader/internal/mrttest"
+)
+
+// attrs are the ORIGIN and AS_PATH of a route originated by origin
+func attrs(origin uint32) []byte {
+	return mrttest.Attrs(mrttest.ASPath4(64500, origin))
+}
+
+func peerIndex(ts uint32, peers ...string) []byte {
+	var list []mrttest.Peer
+	for i, p := range peers {
+		list = append(list, mrttest.Peer{IP: p, AS: 64500 + uint32(i)})
+	}
+	return mrttest.PeerIndex(ts, list...)
+}
+
+func rib(ts uint32, prefix string, peers ...int) []byte {
+	var routes []mrttest.Route
+	for _, p := range peers {
+		routes = append(routes, mrttest.Route{Peer: p, Originated: ts - 100, Attrs: attrs(64600)})
+	}
+	return mrttest.RIB(ts, 0, prefix, routes...)
+}
+
+func update(ts uint32, peer string, withdrawn, announced []string) []byte {
+	a := attrs(64601)
+	if len(announced) == 0 {
+		a = nil
+	}
+	msg := mrttest.Update(mrttest.Prefixes(withdrawn...), a, mrttest.Prefixes(announced...))
+	return mrttest.Message(ts, peer, 64500, msg)
+}
+
+func stateChange(ts uint32, peer string, old, new int) []byte {
+	return mrttest.StateChange(ts, peer, 64500, old, new)
+}
+
+// prefixes lists the prefixes in the table of a peer
+func prefixes(s *State, peer string) []string {
+	t, ok := s.Table(netip.MustParseAddr(peer))
+	if !ok {
+		return nil
+	}
+	var list []string
+	for _, r := range t.Routes() {
+		list = append(list, r.Prefix.String())
+	}
+	return list
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestApply(t *testing.T) {
+	dump := mrttest.WriteFile(t,
+		peerIndex(1000, "198.51.100.1", "198.51.100.2"),
+		rib(1000, "203.0.113.0/24", 0, 1),
+		rib(1000, "203.0.114.0/24", 0),
+	)
+	updates := mrttest.WriteFile(t,
+		update(1100, "198.51.100.1", []string{"203.0.113.0/24"}, []string{"192.0.2.0/24"}),
+		// A withdrawal of an unknown route changes nothing
+		update(1110, "198.51.100.1", []string{"198.18.0.0/15"}, nil),
+		stateChange(1200, "198.51.100.2", 6, 1),
+		// A session coming up does not clear the table
+		stateChange(1210, "198.51.100.1", 1, 6),
+		// Not a BGP4MP header: skipped
+		mrttest.Record(1220, 16, 4, []byte{1, 2}),
+	)
+
+	s, err := Reconstruct(dump, []string{updates}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := prefixes(s, "198.51.100.1"), []string{"192.0.2.0/24", "203.0.114.0/24"}; !equal(got, want) {
+		t.Errorf("got peer 1 routes %v, want %v", got, want)
+	}
+	if got := prefixes(s, "198.51.100.2"); len(got) != 0 {
+		t.Errorf("got peer 2 routes %v after session down", got)
+	}
+	if s.Skipped != 1 {
+		t.Errorf("got %d skipped, want 1", s.Skipped)
+	}
+	if !s.Time.Equal(time.Unix(1210, 0)) {
+		t.Errorf("got time %s", s.Time)
+	}
+
+	tbl, _ := s.Table(netip.MustParseAddr("198.51.100.1"))
+	r, ok := tbl.Lookup(netip.MustParsePrefix("192.0.2.0/24"), 0)
+	if !ok || !r.Time.Equal(time.Unix(1100, 0)) {
+		t.Fatalf("got route %+v, %v", r, ok)
+	}
+	e, err := tbl.Element(r, time.Unix(1300, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !e.Time.Equal(time.Unix(1300, 0)) || !e.Originated.Equal(r.Time) {
+		t.Errorf("got element time %s originated %s", e.Time, e.Originated)
+	}
+	if got := e.Attributes.ASPath.String(); got != "64500 64601" {
+		t.Errorf("got path %q", got)
+	}
+}
+
+func TestReadFileUntil(t *testing.T) {
+	updates := mrttest.WriteFile(t,
+		update(100, "198.51.100.1", nil, []string{"192.0.2.0/24"}),
+		update(200, "198.51.100.1", nil, []string{"198.18.0.0/15"}),
+		update(300, "198.51.100.1", []string{"192.0.2.0/24"}, nil),
+	)
+
+	tests := []struct {
+		until    time.Time
+		complete bool
+		want     []string
+	}{
+		{time.Unix(50, 0), false, nil},
+		{time.Unix(200, 0), false, []string{"192.0.2.0/24", "198.18.0.0/15"}},
+		{time.Unix(300, 0), true, []string{"198.18.0.0/15"}},
+		{time.Time{}, true, []string{"198.18.0.0/15"}},
+	}
+	for _, tt := range tests {
+		s := New()
+		complete, err := s.ReadFile(updates, tt.until)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if complete != tt.complete {
+			t.Errorf("until %d: got complete %v, want %v", tt.until.Unix(), complete, tt.complete)
+		}
+		if got := prefixes(s, "198.51.100.1"); !equal(got, tt.want) {
+			t.Errorf("until %d: got routes %v, want %v", tt.until.Unix(), got, tt.want)
+		}
+	}
+}
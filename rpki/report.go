@@ -0,0 +1,149 @@
+package rpki
+
+import (
+	"net/netip"
+	"sort"
+
+	"bgp_downloader/mrt"
+)
+
+// Counts are the numbers of routes in each validation state.
+type Counts struct {
+	Valid    int
+	Invalid  int
+	NotFound int
+}
+
+// Total returns the number of routes.
+func (c Counts) Total() int {
+	return c.Valid + c.Invalid + c.NotFound
+}
+
+func (c *Counts) add(v Validity) {
+	switch v {
+	case Valid:
+		c.Valid++
+	case Invalid:
+		c.Invalid++
+	default:
+		c.NotFound++
+	}
+}
+
+// PeerReport is the validation summary of the routes of a peer.
+type PeerReport struct {
+	PeerIP netip.Addr
+	PeerAS uint32
+	Counts
+}
+
+// CollectorReport is the validation summary of the routes of a collector
+// and of each of its peers.
+type CollectorReport struct {
+	Source    string
+	Collector string
+	Counts
+	Peers []PeerReport
+}
+
+// Report validates the unicast routes of RIB dumps and counts the states
+// per collector and peer. The zero value is not usable; create one with
+// NewReport.
+type Report struct {
+	// Skipped counts the undecodable records, and the routes left
+	// unvalidated because their attributes could not be decoded.
+	Skipped int
+	// NoOrigin counts the routes left unvalidated because their AS path
+	// has no AS, such as routes originated in the AS of an iBGP peer.
+	NoOrigin int
+
+	vrps       *VRPs
+	collectors map[[2]string]*CollectorReport
+	peers      map[[2]string]map[mrt.Peer]*PeerReport
+}
+
+// NewReport returns an empty report validating against vrps.
+func NewReport(vrps *VRPs) *Report {
+	return &Report{
+		vrps:       vrps,
+		collectors: make(map[[2]string]*CollectorReport),
+		peers:      make(map[[2]string]map[mrt.Peer]*PeerReport),
+	}
+}
+
+// AddRIB validates the routes of a RIB record of a collector. Multicast
+// records are ignored, and routes without an origin AS are counted in
+// NoOrigin only.
+func (r *Report) AddRIB(source, collector string, rib *mrt.RIB) {
+	if rib.SAFI != mrt.SAFIUnicast {
+		return
+	}
+	key := [2]string{source, collector}
+	c, ok := r.collectors[key]
+	if !ok {
+		c = &CollectorReport{Source: source, Collector: collector}
+		r.collectors[key] = c
+		r.peers[key] = make(map[mrt.Peer]*PeerReport)
+	}
+	peers := r.peers[key]
+	for _, e := range rib.Entries {
+		attrs, err := e.Attributes.Decode()
+		if err != nil {
+			r.Skipped++
+			continue
+		}
+		if len(attrs.ASPath.Origins()) == 0 {
+			r.NoOrigin++
+			continue
+		}
+		v := r.vrps.Validate(rib.Prefix, attrs.ASPath)
+		c.add(v)
+		p, ok := peers[e.Peer]
+		if !ok {
+			p = &PeerReport{PeerIP: e.Peer.IP, PeerAS: e.Peer.AS}
+			peers[e.Peer] = p
+		}
+		p.add(v)
+	}
+}
+
+// ReadFile validates the RIB records of an MRT file of a collector.
+func (r *Report) ReadFile(source, collector, path string) error {
+	skipped, err := mrt.ForEachFile(path, func(rec mrt.Record) bool {
+		if rib, ok := rec.(*mrt.RIB); ok {
+			r.AddRIB(source, collector, rib)
+		}
+		return true
+	})
+	r.Skipped += skipped
+	return err
+}
+
+// Collectors returns the summaries of the collectors ordered by source and
+// name, with their peers ordered by address.
+func (r *Report) Collectors() []CollectorReport {
+	var reports []CollectorReport
+	for key, c := range r.collectors {
+		report := *c
+		report.Peers = nil
+		for _, p := range r.peers[key] {
+			report.Peers = append(report.Peers, *p)
+		}
+		sort.Slice(report.Peers, func(i, j int) bool {
+			a, b := report.Peers[i], report.Peers[j]
+			if a.PeerIP != b.PeerIP {
+				return a.PeerIP.Less(b.PeerIP)
+			}
+			return a.PeerAS < b.PeerAS
+		})
+		reports = append(reports, report)
+	}
+	sort.Slice(reports, func(i, j int) bool {
+		a, b := reports[i], reports[j]
+		if a.Source != b.Source {
+			return a.Source < b.Source
+		}
+		return a.Collector < b.Collector
+	})
+	return reports
+}
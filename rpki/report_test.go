@@ -0,0 +1,40 @@
+package rpki
+
+import (
+	"net/netip"
+	"testing"
+
+	"bgp_downloader/internal/mrttest"
+)
+
+func TestReport(t *testing.T) {
+	vrps := NewVRPs([]VRP{{netip.MustParsePrefix("192.0.2.0/24"), 24, 64600}})
+	dump := mrttest.WriteFile(t,
+		mrttest.PeerIndex(1000, mrttest.Peer{IP: "198.51.100.1", AS: 64500}, mrttest.Peer{IP: "198.51.100.2", AS: 64501}),
+		mrttest.RIB(1000, 0, "192.0.2.0/24",
+			mrttest.Route{Peer: 0, Attrs: mrttest.Attrs(mrttest.ASPath4(64500, 64600))},
+			mrttest.Route{Peer: 1, Attrs: mrttest.Attrs(mrttest.ASPath4(64501), mrttest.ASSet4(64600))},
+		),
+		// An iBGP route without an origin is not validated
+		mrttest.RIB(1000, 1, "192.0.2.0/24", mrttest.Route{Peer: 1, Attrs: mrttest.Attrs()}),
+		mrttest.RIB(1000, 2, "198.18.0.0/15", mrttest.Route{Peer: 1, Attrs: mrttest.Attrs(mrttest.ASPath4(64501, 64602))}),
+	)
+	r := NewReport(vrps)
+	if err := r.ReadFile("ripe", "rrc00", dump); err != nil {
+		t.Fatal(err)
+	}
+	if r.NoOrigin != 1 || r.Skipped != 0 {
+		t.Errorf("got %d without origin, %d skipped", r.NoOrigin, r.Skipped)
+	}
+	reports := r.Collectors()
+	if len(reports) != 1 {
+		t.Fatalf("got %d collectors", len(reports))
+	}
+	c := reports[0]
+	if want := (Counts{Valid: 1, Invalid: 1, NotFound: 1}); c.Counts != want {
+		t.Errorf("got collector counts %+v, want %+v", c.Counts, want)
+	}
+	if len(c.Peers) != 2 || c.Peers[0].Counts != (Counts{Valid: 1}) || c.Peers[1].Counts != (Counts{Invalid: 1, NotFound: 1}) {
+		t.Errorf("got peers %+v", c.Peers)
+	}
+}
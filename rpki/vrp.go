@@ -0,0 +1,259 @@
+// Package rpki performs RPKI route origin validation (RFC 6811) against
+// Validated ROA Payloads exported by a relying party such as rpki-client or
+// Routinator, without any network access.
+package rpki
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"bgp_downloader/mrt"
+)
+
+// VRP is a Validated ROA Payload: the AS authorised to originate a prefix
+// and its more-specifics up to MaxLength.
+type VRP struct {
+	Prefix    netip.Prefix
+	MaxLength int
+	ASN       uint32
+}
+
+// Validity is the route origin validation state of a route.
+type Validity uint8
+
+const (
+	// NotFound means no VRP covers the prefix.
+	NotFound Validity = iota
+	// Valid means a VRP covering the prefix matches the origin and length.
+	Valid
+	// Invalid means VRPs cover the prefix but none matches.
+	Invalid
+)
+
+// String returns "not-found", "valid" or "invalid".
+func (v Validity) String() string {
+	switch v {
+	case Valid:
+		return "valid"
+	case Invalid:
+		return "invalid"
+	}
+	return "not-found"
+}
+
+// VRPs is a set of VRPs indexed for validation. The zero value is not
+// usable; create one with NewVRPs or Load.
+type VRPs struct {
+	vrps    map[netip.Prefix][]VRP
+	lengths map[bool][]int // prefix lengths in use, by IPv6
+	n       int
+}
+
+// NewVRPs indexes a list of VRPs.
+func NewVRPs(list []VRP) *VRPs {
+	v := &VRPs{vrps: make(map[netip.Prefix][]VRP), lengths: make(map[bool][]int)}
+	for _, vrp := range list {
+		vrp.Prefix = vrp.Prefix.Masked()
+		if _, ok := v.vrps[vrp.Prefix]; !ok {
+			ipv6 := vrp.Prefix.Addr().Is6()
+			if !containsInt(v.lengths[ipv6], vrp.Prefix.Bits()) {
+				v.lengths[ipv6] = append(v.lengths[ipv6], vrp.Prefix.Bits())
+			}
+		}
+		v.vrps[vrp.Prefix] = append(v.vrps[vrp.Prefix], vrp)
+		v.n++
+	}
+	for _, l := range v.lengths {
+		sort.Ints(l)
+	}
+	return v
+}
+
+// Len returns the number of VRPs.
+func (v *VRPs) Len() int {
+	return v.n
+}
+
+// Validate returns the validity of a route with an AS path. A path ending
+// in an AS set has the origin NONE of RFC 6811, which no VRP matches, so it
+// is invalid if any VRP covers the prefix. A path without ASes is treated
+// the same way, but callers should rather leave such routes out: their
+// origin is the AS of the peer or collector, which the path does not tell.
+func (v *VRPs) Validate(prefix netip.Prefix, path mrt.ASPath) Validity {
+	origin, ok := pathOrigin(path)
+	state := NotFound
+	for _, bits := range v.lengths[prefix.Addr().Is6()] {
+		if bits > prefix.Bits() {
+			break
+		}
+		covering, _ := prefix.Addr().Prefix(bits)
+		for _, vrp := range v.vrps[covering] {
+			// AS 0 VRPs only deny (RFC 6483, section 4)
+			if ok && vrp.ASN != 0 && vrp.ASN == origin && prefix.Bits() <= vrp.MaxLength {
+				return Valid
+			}
+			state = Invalid
+		}
+	}
+	return state
+}
+
+// pathOrigin returns the last AS of the path, unless the path ends in an
+// AS set. Confederation segments are skipped.
+func pathOrigin(path mrt.ASPath) (uint32, bool) {
+	for i := len(path) - 1; i >= 0; i-- {
+		seg := path[i]
+		if len(seg.ASNs) == 0 || seg.Type == mrt.ASConfedSequence || seg.Type == mrt.ASConfedSet {
+			continue
+		}
+		if seg.Type == mrt.ASSet {
+			return 0, false
+		}
+		return seg.ASNs[len(seg.ASNs)-1], true
+	}
+	return 0, false
+}
+
+// Load reads a VRP export: the JSON of rpki-client or Routinator, or the
+// CSV of Routinator, told apart by their first character.
+func Load(path string) (*VRPs, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var list []VRP
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		list, err = parseJSON(trimmed)
+	} else {
+		list, err = parseCSV(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return NewVRPs(list), nil
+}
+
+// parseJSON parses the "roas" array of an rpki-client or Routinator JSON
+// export. rpki-client writes AS numbers as numbers, Routinator as strings
+// such as "AS13335".
+func parseJSON(data []byte) ([]VRP, error) {
+	var doc struct {
+		ROAs []struct {
+			ASN       json.RawMessage `json:"asn"`
+			Prefix    string          `json:"prefix"`
+			MaxLength *int            `json:"maxLength"`
+		} `json:"roas"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	list := make([]VRP, 0, len(doc.ROAs))
+	for i, r := range doc.ROAs {
+		asn := string(r.ASN)
+		if s, err := strconv.Unquote(asn); err == nil {
+			asn = s
+		}
+		maxLength := ""
+		if r.MaxLength != nil {
+			maxLength = strconv.Itoa(*r.MaxLength)
+		}
+		vrp, err := parseVRP(asn, r.Prefix, maxLength)
+		if err != nil {
+			return nil, fmt.Errorf("roa %d: %v", i, err)
+		}
+		list = append(list, vrp)
+	}
+	return list, nil
+}
+
+// parseCSV parses a Routinator CSV export, locating the ASN, IP Prefix and
+// Max Length columns by the header, so that the extended formats work too.
+func parseCSV(r io.Reader) ([]VRP, error) {
+	cr := csv.NewReader(bufio.NewReader(r))
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("empty VRP file")
+		}
+		return nil, err
+	}
+	cols := map[string]int{"asn": -1, "ip prefix": -1, "max length": -1}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if _, ok := cols[name]; ok {
+			cols[name] = i
+		}
+	}
+	for name, i := range cols {
+		if i < 0 {
+			return nil, fmt.Errorf("no %q column in CSV header", name)
+		}
+	}
+
+	var list []VRP
+	for line := 2; ; line++ {
+		rec, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return list, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		field := func(name string) string {
+			if i := cols[name]; i < len(rec) {
+				return rec[i]
+			}
+			return ""
+		}
+		vrp, err := parseVRP(field("asn"), field("ip prefix"), field("max length"))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		list = append(list, vrp)
+	}
+}
+
+// parseVRP parses the fields of a VRP. The AS number may carry an "AS"
+// prefix; an empty max length is the length of the prefix.
+func parseVRP(asn, prefix, maxLength string) (VRP, error) {
+	var vrp VRP
+	asn = strings.TrimSpace(asn)
+	n, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(asn), "AS"), 10, 32)
+	if err != nil {
+		return vrp, fmt.Errorf("invalid ASN: %s", asn)
+	}
+	vrp.ASN = uint32(n)
+	if vrp.Prefix, err = netip.ParsePrefix(strings.TrimSpace(prefix)); err != nil {
+		return vrp, err
+	}
+	vrp.MaxLength = vrp.Prefix.Bits()
+	if maxLength = strings.TrimSpace(maxLength); maxLength != "" {
+		if vrp.MaxLength, err = strconv.Atoi(maxLength); err != nil {
+			return vrp, fmt.Errorf("invalid max length: %s", maxLength)
+		}
+	}
+	if vrp.MaxLength < vrp.Prefix.Bits() || vrp.MaxLength > vrp.Prefix.Addr().BitLen() {
+		return vrp, fmt.Errorf("invalid max length %d for %s", vrp.MaxLength, vrp.Prefix)
+	}
+	return vrp, nil
+}
+
+func containsInt(list []int, v int) bool {
+	for _, x := range list {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+package rpki
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"bgp_downloader/mrt"
+)
+
+// path returns an AS path of one AS_SEQUENCE segment
+func path(asns ...uint32) mrt.ASPath {
+	return mrt.ASPath{{Type: mrt.ASSequence, ASNs: asns}}
+}
+
+func TestValidate(t *testing.T) {
+	vrps := NewVRPs([]VRP{
+		{netip.MustParsePrefix("192.0.2.0/24"), 24, 64500},
+		{netip.MustParsePrefix("198.51.100.0/22"), 24, 64501},
+		{netip.MustParsePrefix("203.0.113.0/24"), 24, 0},
+		// An AS 0 VRP next to one authorising an AS
+		{netip.MustParsePrefix("198.18.0.0/15"), 16, 0},
+		{netip.MustParsePrefix("198.18.0.0/15"), 16, 64503},
+		{netip.MustParsePrefix("2001:db8::/32"), 48, 64502},
+	})
+	if vrps.Len() != 6 {
+		t.Errorf("got %d VRPs", vrps.Len())
+	}
+
+	tests := []struct {
+		prefix string
+		path   mrt.ASPath
+		want   Validity
+	}{
+		{"192.0.2.0/24", path(64510, 64500), Valid},
+		{"192.0.2.0/24", path(64510, 64501), Invalid},
+		// Longer than the maximum length
+		{"192.0.2.0/25", path(64500), Invalid},
+		{"198.51.101.0/24", path(64501), Valid},
+		{"198.51.101.0/25", path(64501), Invalid},
+		// AS 0 VRPs match no origin, not even AS 0
+		{"203.0.113.0/24", path(64500), Invalid},
+		{"203.0.113.0/24", path(0), Invalid},
+		{"198.18.0.0/15", path(64503), Valid},
+		{"198.18.0.0/17", path(64503), Invalid},
+		{"2001:db8:1::/48", path(64502), Valid},
+		{"2001:db8:1::/49", path(64502), Invalid},
+		{"10.0.0.0/8", path(64500), NotFound},
+		{"2001:db9::/32", path(64502), NotFound},
+		// A path ending in an AS set has no origin
+		{"192.0.2.0/24", mrt.ASPath{{Type: mrt.ASSequence, ASNs: []uint32{64510}}, {Type: mrt.ASSet, ASNs: []uint32{64500}}}, Invalid},
+		{"10.0.0.0/8", mrt.ASPath{{Type: mrt.ASSet, ASNs: []uint32{64500}}}, NotFound},
+		// Confederation segments are skipped
+		{"192.0.2.0/24", mrt.ASPath{{Type: mrt.ASSequence, ASNs: []uint32{64500}}, {Type: mrt.ASConfedSequence, ASNs: []uint32{65000}}}, Valid},
+	}
+	for _, tt := range tests {
+		if got := vrps.Validate(netip.MustParsePrefix(tt.prefix), tt.path); got != tt.want {
+			t.Errorf("%s %s: got %s, want %s", tt.prefix, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"rpki-client.json": `{"metadata": {}, "roas": [{"asn": 64500, "prefix": "192.0.2.0/24", "maxLength": 24, "ta": "ripe"}, {"asn": 64501, "prefix": "2001:db8::/32", "maxLength": 48}]}`,
+		"routinator.json":  `{"roas": [{"asn": "AS64500", "prefix": "192.0.2.0/24", "maxLength": 24}, {"asn": "AS64501", "prefix": "2001:db8::/32", "maxLength": 48}]}`,
+		"routinator.csv":   "ASN,IP Prefix,Max Length,Trust Anchor\nAS64500,192.0.2.0/24,24,ripe\nAS64501,2001:db8::/32,48,arin\n",
+	}
+	for name, data := range files {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		vrps, err := Load(p)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if vrps.Len() != 2 {
+			t.Errorf("%s: got %d VRPs", name, vrps.Len())
+		}
+		if v := vrps.Validate(netip.MustParsePrefix("2001:db8:1::/48"), path(64501)); v != Valid {
+			t.Errorf("%s: got %s", name, v)
+		}
+	}
+
+	bad := filepath.Join(dir, "bad.csv")
+	if err := os.WriteFile(bad, []byte("ASN,IP Prefix,Max Length\nAS64500,192.0.2.0/24,16\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(bad); err == nil {
+		t.Error("got no error for a max length shorter than the prefix")
+	}
+}
@@ -0,0 +1,134 @@
+package sessions
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+
+	"bgp_downloader/mrt"
+)
+
+// Kind is the kind of a timeline event.
+type Kind uint8
+
+const (
+	// Down is a session of a peer leaving the Established state.
+	Down Kind = iota + 1
+	// Up is a session of a peer reaching the Established state.
+	Up
+	// Transition is any other state change of a session.
+	Transition
+	// Reset is a share of the peers of a collector going down together.
+	Reset
+	// Gap is a run of missing updates files of a collector.
+	Gap
+)
+
+// String returns "down", "up", "state", "reset" or "gap".
+func (k Kind) String() string {
+	switch k {
+	case Down:
+		return "down"
+	case Up:
+		return "up"
+	case Transition:
+		return "state"
+	case Reset:
+		return "reset"
+	case Gap:
+		return "gap"
+	}
+	return "unknown"
+}
+
+// Event is an entry of the timeline of a collector.
+type Event struct {
+	Kind      Kind
+	Time      time.Time
+	Source    string
+	Collector string
+
+	// PeerIP, PeerAS, OldState and NewState describe state changes.
+	PeerIP   netip.Addr
+	PeerAS   uint32
+	OldState mrt.State
+	NewState mrt.State
+	// InReset marks the down events that are part of a reset.
+	InReset bool
+
+	// Peers is the number of peers going down in a reset, out of Seen, the
+	// peers seen in the collector's updates.
+	Peers int
+	Seen  int
+	// End is the end of a reset or gap: the last down of the reset, or the
+	// time the updates resume after the gap. Files is the number of files
+	// missing in the gap.
+	End   time.Time
+	Files int
+}
+
+// String formats the event as a line of text.
+func (e Event) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s %s %s", e.Time.UTC().Format(time.RFC3339), e.Source, e.Collector, e.Kind)
+	switch e.Kind {
+	case Reset:
+		fmt.Fprintf(&b, " %d of %d peers down until %s", e.Peers, e.Seen, e.End.UTC().Format(time.RFC3339))
+	case Gap:
+		files := "files"
+		if e.Files == 1 {
+			files = "file"
+		}
+		fmt.Fprintf(&b, " no updates until %s (%d %s missing)", e.End.UTC().Format(time.RFC3339), e.Files, files)
+	default:
+		fmt.Fprintf(&b, " %s AS%d %s -> %s", e.PeerIP, e.PeerAS, e.OldState, e.NewState)
+		if e.InReset {
+			b.WriteString(" (reset)")
+		}
+	}
+	return b.String()
+}
+
+// eventJSON is the JSON encoding of an Event
+type eventJSON struct {
+	Type      string  `json:"type"`
+	Time      float64 `json:"time"`
+	Source    string  `json:"source,omitempty"`
+	Collector string  `json:"collector"`
+	PeerIP    string  `json:"peer_ip,omitempty"`
+	PeerAS    uint32  `json:"peer_as,omitempty"`
+	OldState  string  `json:"old_state,omitempty"`
+	NewState  string  `json:"new_state,omitempty"`
+	InReset   bool    `json:"in_reset,omitempty"`
+	Peers     int     `json:"peers,omitempty"`
+	Seen      int     `json:"seen,omitempty"`
+	End       float64 `json:"end,omitempty"`
+	Files     int     `json:"files,omitempty"`
+}
+
+// MarshalJSON encodes the event as a flat JSON object with times in
+// seconds.
+func (e Event) MarshalJSON() ([]byte, error) {
+	j := eventJSON{
+		Type:      e.Kind.String(),
+		Time:      float64(e.Time.UnixMicro()) / 1e6,
+		Source:    e.Source,
+		Collector: e.Collector,
+		InReset:   e.InReset,
+		Peers:     e.Peers,
+		Seen:      e.Seen,
+		Files:     e.Files,
+	}
+	if e.PeerIP.IsValid() {
+		j.PeerIP = e.PeerIP.String()
+		j.PeerAS = e.PeerAS
+		j.OldState = e.OldState.String()
+		j.NewState = e.NewState.String()
+	}
+	if !e.End.IsZero() {
+		j.End = float64(e.End.UnixMicro()) / 1e6
+	}
+	return json.Marshal(j)
+}
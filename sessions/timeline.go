@@ -0,0 +1,189 @@
+// Package sessions builds timelines of the BGP sessions of route
+// collectors from the state changes in their updates, merged with the gaps
+// of the updates feeds, to tell routing events from collector-side resets.
+package sessions
+
+import (
+	"net/netip"
+	"sort"
+	"time"
+
+	"bgp_downloader/mrt"
+)
+
+// Options tunes a Timeline.
+type Options struct {
+	// AllStates keeps the state changes that neither reach nor leave the
+	// Established state.
+	AllStates bool
+	// ResetWindow is the longest time between the first and last down of
+	// a reset; the default is a minute.
+	ResetWindow time.Duration
+	// ResetShare is the share of the peers seen at a collector that must go
+	// down within ResetWindow for a reset; the default is one half. A reset
+	// takes at least two peers.
+	ResetShare float64
+}
+
+// feed identifies a collector
+type feed struct {
+	source    string
+	collector string
+}
+
+// peerKey identifies a peer of a collector
+type peerKey struct {
+	ip netip.Addr
+	as uint32
+}
+
+// Timeline collects the events of collectors. The zero value is not
+// usable; create one with New.
+type Timeline struct {
+	opts   Options
+	events []Event
+	peers  map[feed]map[peerKey]bool
+}
+
+// New returns an empty timeline.
+func New(opts Options) *Timeline {
+	if opts.ResetWindow <= 0 {
+		opts.ResetWindow = time.Minute
+	}
+	if opts.ResetShare <= 0 {
+		opts.ResetShare = 0.5
+	}
+	return &Timeline{opts: opts, peers: make(map[feed]map[peerKey]bool)}
+}
+
+// Add records the state changes of a record of a collector's updates, and
+// the peer of its messages as seen.
+func (t *Timeline) Add(source, collector string, rec mrt.Record) {
+	var hdr mrt.BGP4MPHeader
+	switch r := rec.(type) {
+	case *mrt.BGP4MPMessage:
+		if r.Local {
+			return
+		}
+		hdr = r.BGP4MPHeader
+	case *mrt.BGP4MPStateChange:
+		hdr = r.BGP4MPHeader
+		kind := Transition
+		if r.OldState == mrt.StateEstablished && r.NewState != mrt.StateEstablished {
+			kind = Down
+		} else if r.NewState == mrt.StateEstablished && r.OldState != mrt.StateEstablished {
+			kind = Up
+		}
+		if kind != Transition || t.opts.AllStates {
+			t.events = append(t.events, Event{
+				Kind:      kind,
+				Time:      r.Header().Timestamp,
+				Source:    source,
+				Collector: collector,
+				PeerIP:    r.PeerIP,
+				PeerAS:    r.PeerAS,
+				OldState:  r.OldState,
+				NewState:  r.NewState,
+			})
+		}
+	default:
+		return
+	}
+	f := feed{source, collector}
+	peers, ok := t.peers[f]
+	if !ok {
+		peers = make(map[peerKey]bool)
+		t.peers[f] = peers
+	}
+	peers[peerKey{hdr.PeerIP, hdr.PeerAS}] = true
+}
+
+// AddGap records a run of missing updates files of a collector, from the
+// first missing file to the time the updates resume.
+func (t *Timeline) AddGap(source, collector string, from, end time.Time, files int) {
+	t.events = append(t.events, Event{
+		Kind:      Gap,
+		Time:      from,
+		Source:    source,
+		Collector: collector,
+		End:       end,
+		Files:     files,
+	})
+}
+
+// Events returns the timeline in time order, with the resets found among
+// the down events. At the same time gaps come first, then resets, then the
+// state changes ordered by collector and peer.
+func (t *Timeline) Events() []Event {
+	events := append([]Event(nil), t.events...)
+	sortEvents(events)
+
+	// Group the downs by collector, in time order
+	downs := make(map[feed][]int)
+	for i, e := range events {
+		if e.Kind == Down {
+			f := feed{e.Source, e.Collector}
+			downs[f] = append(downs[f], i)
+		}
+	}
+	for f, list := range downs {
+		seen := len(t.peers[f])
+		for i := 0; i < len(list); {
+			start := events[list[i]].Time
+			j := i
+			peers := make(map[peerKey]bool)
+			for ; j < len(list) && events[list[j]].Time.Sub(start) <= t.opts.ResetWindow; j++ {
+				e := events[list[j]]
+				peers[peerKey{e.PeerIP, e.PeerAS}] = true
+			}
+			if len(peers) < 2 || float64(len(peers)) < t.opts.ResetShare*float64(seen) {
+				i++
+				continue
+			}
+			for _, k := range list[i:j] {
+				events[k].InReset = true
+			}
+			events = append(events, Event{
+				Kind:      Reset,
+				Time:      start,
+				Source:    f.source,
+				Collector: f.collector,
+				Peers:     len(peers),
+				Seen:      seen,
+				End:       events[list[j-1]].Time,
+			})
+			i = j
+		}
+	}
+	sortEvents(events)
+	return events
+}
+
+// sortEvents orders events by time, kind, collector and peer
+func sortEvents(events []Event) {
+	rank := func(k Kind) int {
+		switch k {
+		case Gap:
+			return 0
+		case Reset:
+			return 1
+		}
+		return 2
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		a, b := events[i], events[j]
+		if !a.Time.Equal(b.Time) {
+			return a.Time.Before(b.Time)
+		}
+		if rank(a.Kind) != rank(b.Kind) {
+			return rank(a.Kind) < rank(b.Kind)
+		}
+		if a.Source != b.Source {
+			return a.Source < b.Source
+		}
+		if a.Collector != b.Collector {
+			return a.Collector < b.Collector
+		}
+		return a.PeerIP.Less(b.PeerIP)
+	})
+}
@@ -0,0 +1,90 @@
+package sessions
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"bgp_downloader/internal/mrttest"
+	"bgp_downloader/mrt"
+)
+
+// add adds the records of an updates file of a collector
+func add(t *testing.T, tl *Timeline, collector string, data ...[]byte) {
+	_, err := mrt.ForEachFile(mrttest.WriteFile(t, data...), func(rec mrt.Record) bool {
+		tl.Add("ripe", collector, rec)
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// summary formats the fields of an event the tests check
+func summary(e Event) string {
+	s := fmt.Sprintf("%d %s %s", e.Time.Unix(), e.Collector, e.Kind)
+	switch e.Kind {
+	case Reset:
+		s += fmt.Sprintf(" %d/%d until %d", e.Peers, e.Seen, e.End.Unix())
+	case Gap:
+		s += fmt.Sprintf(" %d until %d", e.Files, e.End.Unix())
+	default:
+		s += " " + e.PeerIP.String()
+	}
+	if e.InReset {
+		s += " in reset"
+	}
+	return s
+}
+
+func TestResets(t *testing.T) {
+	const (
+		established = int(mrt.StateEstablished)
+		idle        = int(mrt.StateIdle)
+		connect     = int(mrt.StateConnect)
+	)
+	keepalive := mrttest.Cat(make([]byte, 16), mrttest.U16(19), []byte{4})
+	tl := New(Options{})
+	add(t, tl, "rrc00",
+		// The fourth peer is only seen through its messages until it goes
+		// down alone
+		mrttest.Message(900, "198.51.100.4", 64504, keepalive),
+		mrttest.StateChange(1000, "198.51.100.1", 64501, established, idle),
+		mrttest.StateChange(1010, "198.51.100.2", 64502, established, idle),
+		mrttest.StateChange(1030, "198.51.100.3", 64503, established, idle),
+		mrttest.StateChange(1050, "198.51.100.1", 64501, idle, connect),
+		mrttest.StateChange(1100, "198.51.100.1", 64501, connect, established),
+		mrttest.StateChange(2000, "198.51.100.4", 64504, established, idle),
+	)
+	// A single peer going down is no reset
+	add(t, tl, "rrc01", mrttest.StateChange(1000, "198.51.100.5", 64505, established, idle))
+	tl.AddGap("ripe", "rrc00", time.Unix(1500, 0), time.Unix(1800, 0), 1)
+
+	var got []string
+	for _, e := range tl.Events() {
+		got = append(got, summary(e))
+	}
+	want := []string{
+		"1000 rrc00 reset 3/4 until 1030",
+		"1000 rrc00 down 198.51.100.1 in reset",
+		"1000 rrc01 down 198.51.100.5",
+		"1010 rrc00 down 198.51.100.2 in reset",
+		"1030 rrc00 down 198.51.100.3 in reset",
+		"1100 rrc00 up 198.51.100.1",
+		"1500 rrc00 gap 1 until 1800",
+		"2000 rrc00 down 198.51.100.4",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got events\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// Within 20s only two of the four peers go down
+	tl.opts.ResetWindow = 20 * time.Second
+	tl.opts.ResetShare = 0.75
+	for _, e := range tl.Events() {
+		if e.Kind == Reset || e.InReset {
+			t.Errorf("got %s with a 20s window", summary(e))
+		}
+	}
+}
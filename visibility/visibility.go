@@ -0,0 +1,165 @@
+// Package visibility tracks which peers of route collectors route to a set
+// of prefixes, and from which origins, as RIB dumps and updates are
+// replayed.
+package visibility
+
+import (
+	"net/netip"
+	"sort"
+	"time"
+
+	"bgp_downloader/mrt"
+)
+
+// Sample is the visibility of a prefix at a point in time.
+type Sample struct {
+	Time   time.Time
+	Prefix netip.Prefix
+	// Peers counts the peers with a route to the prefix.
+	Peers int
+	// Origins are the origins of the routes, ordered by origin, with the
+	// number of peers routing to each.
+	Origins []Origin
+}
+
+// Origin is an origin AS, or the members of an AS set separated by commas,
+// with the number of peers whose routes it originates.
+type Origin struct {
+	Origin string
+	Peers  int
+}
+
+// peerKey identifies a peer of a collector
+type peerKey struct {
+	source    string
+	collector string
+	ip        netip.Addr
+}
+
+// Tracker holds the routes of all peers to the tracked prefixes. The zero
+// value is not usable; create one with New.
+type Tracker struct {
+	prefixes []netip.Prefix
+	// routes holds the origin of each route by prefix, peer and path ID
+	routes map[netip.Prefix]map[peerKey]map[uint32]string
+}
+
+// New returns a tracker of the exact prefixes given.
+func New(prefixes []netip.Prefix) *Tracker {
+	t := &Tracker{routes: make(map[netip.Prefix]map[peerKey]map[uint32]string)}
+	for _, p := range prefixes {
+		p = p.Masked()
+		if _, ok := t.routes[p]; !ok {
+			t.prefixes = append(t.prefixes, p)
+			t.routes[p] = make(map[peerKey]map[uint32]string)
+		}
+	}
+	return t
+}
+
+// Apply updates the routes with a record of a collector: a peer index
+// table starts a new RIB dump and forgets the routes of the collector, RIB
+// entries and announcements replace the route of the peer, withdrawals
+// remove it, and a session leaving the Established state removes all routes
+// of the peer. Records must be given in time order.
+func (t *Tracker) Apply(source, collector string, rec mrt.Record) error {
+	switch r := rec.(type) {
+	case *mrt.PeerIndexTable:
+		for _, peers := range t.routes {
+			for k := range peers {
+				if k.source == source && k.collector == collector {
+					delete(peers, k)
+				}
+			}
+		}
+
+	case *mrt.RIB:
+		peers, ok := t.routes[r.Prefix]
+		if !ok || r.SAFI != mrt.SAFIUnicast {
+			return nil
+		}
+		var err error
+		for _, e := range r.Entries {
+			attrs, decodeErr := e.Attributes.Decode()
+			if decodeErr != nil {
+				err = decodeErr
+				continue
+			}
+			t.set(peers, peerKey{source, collector, e.Peer.IP}, e.PathID, attrs.ASPath)
+		}
+		return err
+
+	case *mrt.BGP4MPMessage:
+		if r.Type() != mrt.MsgUpdate || r.Local {
+			return nil
+		}
+		u, err := r.Update()
+		if err != nil {
+			return err
+		}
+		announced, withdrawn, attrs, err := u.Routes()
+		if err != nil {
+			return err
+		}
+		k := peerKey{source, collector, r.PeerIP}
+		for _, n := range withdrawn {
+			if peers, ok := t.routes[n.Prefix]; ok {
+				delete(peers[k], n.PathID)
+			}
+		}
+		for _, n := range announced {
+			if peers, ok := t.routes[n.Prefix]; ok {
+				t.set(peers, k, n.PathID, attrs.ASPath)
+			}
+		}
+
+	case *mrt.BGP4MPStateChange:
+		if r.OldState == mrt.StateEstablished && r.NewState != mrt.StateEstablished {
+			k := peerKey{source, collector, r.PeerIP}
+			for _, peers := range t.routes {
+				delete(peers, k)
+			}
+		}
+	}
+	return nil
+}
+
+// set records the route of a peer to a tracked prefix
+func (t *Tracker) set(peers map[peerKey]map[uint32]string, k peerKey, pathID uint32, path mrt.ASPath) {
+	paths, ok := peers[k]
+	if !ok {
+		paths = make(map[uint32]string)
+		peers[k] = paths
+	}
+	paths[pathID] = path.OriginString()
+}
+
+// Samples returns the visibility of the tracked prefixes, in the order
+// they were given to New, stamped with the time at.
+func (t *Tracker) Samples(at time.Time) []Sample {
+	samples := make([]Sample, 0, len(t.prefixes))
+	for _, p := range t.prefixes {
+		s := Sample{Time: at, Prefix: p}
+		origins := make(map[string]int)
+		for _, paths := range t.routes[p] {
+			if len(paths) == 0 {
+				continue
+			}
+			s.Peers++
+			// A peer with several paths counts once per origin
+			seen := make(map[string]bool, len(paths))
+			for _, o := range paths {
+				if !seen[o] {
+					seen[o] = true
+					origins[o]++
+				}
+			}
+		}
+		for o, n := range origins {
+			s.Origins = append(s.Origins, Origin{o, n})
+		}
+		sort.Slice(s.Origins, func(i, j int) bool { return s.Origins[i].Origin < s.Origins[j].Origin })
+		samples = append(samples, s)
+	}
+	return samples
+}
@@ -0,0 +1,81 @@
+package visibility
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"bgp_downloader/internal/mrttest"
+	"bgp_downloader/mrt"
+)
+
+// apply applies the records of a file of a collector
+func apply(t *testing.T, tr *Tracker, collector string, data ...[]byte) {
+	_, err := mrt.ForEachFile(mrttest.WriteFile(t, data...), func(rec mrt.Record) bool {
+		if err := tr.Apply("ripe", collector, rec); err != nil {
+			t.Error(err)
+		}
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// samples formats the samples of the tracker, one prefix per line
+func samples(tr *Tracker) string {
+	var lines []string
+	for _, s := range tr.Samples(time.Time{}) {
+		line := fmt.Sprintf("%s %d", s.Prefix, s.Peers)
+		for _, o := range s.Origins {
+			line += fmt.Sprintf(" %s:%d", o.Origin, o.Peers)
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestTracker(t *testing.T) {
+	route := func(peer int, origin uint32) mrttest.Route {
+		return mrttest.Route{Peer: peer, Attrs: mrttest.Attrs(mrttest.ASPath4(64500+uint32(peer), origin))}
+	}
+	peers := []mrttest.Peer{{IP: "198.51.100.1", AS: 64500}, {IP: "198.51.100.2", AS: 64501}}
+	// The prefixes are masked and listed once
+	tr := New([]netip.Prefix{
+		netip.MustParsePrefix("192.0.2.0/24"),
+		netip.MustParsePrefix("203.0.113.1/24"),
+		netip.MustParsePrefix("192.0.2.0/24"),
+	})
+	check := func(step, want string) {
+		t.Helper()
+		if got := samples(tr); got != want {
+			t.Errorf("%s: got\n%s\nwant\n%s", step, got, want)
+		}
+	}
+
+	apply(t, tr, "rrc00",
+		mrttest.PeerIndex(1000, peers...),
+		mrttest.RIB(1000, 0, "192.0.2.0/24", route(0, 64600), route(1, 64601)),
+		mrttest.RIB(1000, 1, "198.18.0.0/15", route(0, 64600)),
+	)
+	check("dump", "192.0.2.0/24 2 64600:1 64601:1\n203.0.113.0/24 0")
+
+	update := mrttest.Update(mrttest.Prefixes("192.0.2.0/24"), mrttest.Attrs(mrttest.ASPath4(64501, 64602)), mrttest.Prefixes("203.0.113.0/24"))
+	apply(t, tr, "rrc00", mrttest.Message(1100, "198.51.100.2", 64501, update))
+	check("update", "192.0.2.0/24 1 64600:1\n203.0.113.0/24 1 64602:1")
+
+	apply(t, tr, "rrc00", mrttest.StateChange(1200, "198.51.100.1", 64500, int(mrt.StateEstablished), int(mrt.StateIdle)))
+	check("session down", "192.0.2.0/24 0\n203.0.113.0/24 1 64602:1")
+
+	apply(t, tr, "rrc01",
+		mrttest.PeerIndex(1250, peers[0]),
+		mrttest.RIB(1250, 0, "203.0.113.0/24", route(0, 64603)),
+	)
+	check("second collector", "192.0.2.0/24 0\n203.0.113.0/24 2 64602:1 64603:1")
+
+	// A new dump of the first collector forgets its routes only
+	apply(t, tr, "rrc00", mrttest.PeerIndex(1300, peers...))
+	check("new dump", "192.0.2.0/24 0\n203.0.113.0/24 1 64603:1")
+}